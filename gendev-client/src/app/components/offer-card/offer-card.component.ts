@@ -2,7 +2,7 @@ import { Component, input } from '@angular/core';
 import { CommonModule } from '@angular/common';
 import { FontAwesomeModule } from '@fortawesome/angular-fontawesome';
 import { faGlobe, faInfoCircle } from '@fortawesome/free-solid-svg-icons';
-import { Offer } from '../../models/offer.model';
+import { installationOption, Offer } from '../../models/offer.model';
 import { HlmBadgeDirective } from '@spartan-ng/helm/badge';
 
 @Component({
@@ -33,6 +33,19 @@ export class OfferCardComponent {
     return this.getProviderLogo(providerName) !== null;
   }
 
+  isInstallationOptional(): boolean {
+    return (this.offer().installationOptions?.length ?? 0) > 1;
+  }
+
+  // additional monthly cost of the installation service if it is optional
+  getInstallationSurcharge(): string {
+    const withInstallation = installationOption(this.offer(), true);
+    const withoutInstallation = installationOption(this.offer(), false);
+    if (!withInstallation || !withoutInstallation) return '';
+
+    return `€${((withInstallation.monthlyCostInCent - withoutInstallation.monthlyCostInCent) / 100).toFixed(2)}`;
+  }
+
   getVoucherDescription(): string {
     const voucher = this.offer().voucherDetails;
     if (!voucher) return '';
@@ -1,4 +1,4 @@
-import { Offer } from "./offer.model";
+import { installationOption, Offer } from "./offer.model";
 
 export interface FilterOptions {
   provider?: string;
@@ -24,8 +24,14 @@ export function filterOffer(offer: Offer, filterOptions: FilterOptions): boolean
   if (filterOptions.provider && filterOptions.provider.trim() !== '' && offer.provider !== filterOptions.provider) {
     return false;
   }
-  if (filterOptions.installation !== undefined && offer.installationService !== filterOptions.installation) {
-    return false;
+  // offers with optional installation match both values, the price of the chosen option is compared below
+  let monthlyCostInCent = offer.monthlyCostInCent;
+  if (filterOptions.installation !== undefined) {
+    const option = installationOption(offer, filterOptions.installation);
+    if (!option) {
+      return false;
+    }
+    monthlyCostInCent = option.monthlyCostInCent;
   }
   if (filterOptions.speedMin !== undefined && offer.speed < filterOptions.speedMin) {
     return false;
@@ -33,7 +39,7 @@ export function filterOffer(offer: Offer, filterOptions: FilterOptions): boolean
   if (filterOptions.age !== undefined && offer.maxAgePerson && offer.maxAgePerson < filterOptions.age) {
     return false;
   }
-  if (filterOptions.costMax !== undefined && offer.monthlyCostInCent > filterOptions.costMax * 100) {
+  if (filterOptions.costMax !== undefined && monthlyCostInCent > filterOptions.costMax * 100) {
     return false;
   }
   if (filterOptions.connectionType && filterOptions.connectionType.trim() !== '' && offer.connectionType !== filterOptions.connectionType) {
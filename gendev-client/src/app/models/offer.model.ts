@@ -4,6 +4,14 @@ export interface VoucherDetails {
   voucherDescription?: string;
 }
 
+export interface InstallationOption {
+  installationService: boolean;
+  installationOptions?: InstallationOption[];
+  monthlyCostInCent: number;
+  monthlyCostInCentWithVoucher?: number;
+  afterTwoYearsMonthlyCost?: number;
+}
+
 export interface Offer {
   provider: string;
   productId?: number;
@@ -18,8 +26,17 @@ export interface Offer {
   afterTwoYearsMonthlyCost?: number;
   monthlyCostInCentWithVoucher?: number;
   installationService: boolean;
+  installationOptions?: InstallationOption[];
   voucherDetails?: VoucherDetails;
   
   isPreliminary: boolean;
   offerHash: string;
 }
+
+// returns the prices of the offer with or without installation service, offers without options only have one
+export function installationOption(offer: Offer, installation: boolean): InstallationOption | undefined {
+  if (!offer.installationOptions?.length) {
+    return offer.installationService === installation ? offer : undefined;
+  }
+  return offer.installationOptions.find((option) => option.installationService === installation);
+}
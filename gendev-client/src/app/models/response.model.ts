@@ -10,4 +10,27 @@ export interface OfferResponse {
   offer: Offer;
 }
 
-export type NdjsonResponse = QueryResponse | OfferResponse;
\ No newline at end of file
+// sent once a provider finished, NO_OFFERS and ERROR tell "no offers here" apart from a failed provider.
+// SKIPPED, CACHE_ONLY and NO_COVERAGE_CACHED providers were not queried, by the filter, their call budget or a
+// recent lookup without coverage
+export type ProviderStatusType =
+  'OK' | 'PARTIAL' | 'NO_OFFERS' | 'ERROR' | 'TIMEOUT' | 'SKIPPED' | 'CACHE_ONLY' | 'NO_COVERAGE_CACHED';
+
+// kind of the last provider error, ADDRESS_REJECTED also explains a NO_COVERAGE_CACHED status
+export type ProviderErrorKind = 'CLIENT_FAULT' | 'SERVER_FAULT' | 'UNEXPECTED_RESPONSE' | 'ADDRESS_REJECTED';
+
+export interface ProviderStatus {
+  provider: string;
+  status: ProviderStatusType;
+  offers: number;
+  errors?: number;
+  errorKind?: ProviderErrorKind;
+  // timeout the provider was given in this lookup
+  timeoutMs?: number;
+}
+
+export interface ProviderStatusResponse {
+  providerStatus: ProviderStatus;
+}
+
+export type NdjsonResponse = QueryResponse | OfferResponse | ProviderStatusResponse;
\ No newline at end of file
@@ -4,6 +4,8 @@ import {
   NdjsonResponse,
   QueryResponse,
   OfferResponse,
+  ProviderStatus,
+  ProviderStatusResponse,
 } from '../models/response.model';
 import { Query } from '../models/query.model';
 
@@ -14,10 +16,12 @@ export class State {
   // Signals for state management
   private readonly _query = signal<Query | null>(null);
   private readonly _sessionId = signal<string>(this.generateSessionId());
+  private readonly _providerStatuses = signal<Map<string, ProviderStatus>>(new Map());
 
   // Read-only computed signals
   readonly query = this._query.asReadonly();
   readonly sessionId = this._sessionId.asReadonly();
+  readonly providerStatuses = this._providerStatuses.asReadonly();
 
   // Computed values
   readonly offerCount = computed(() => this._query()?.offers?.size ?? 0);
@@ -47,6 +51,12 @@ export class State {
     });
   }
 
+  setProviderStatus(status: ProviderStatus) {
+    const updatedStatuses = new Map(this._providerStatuses());
+    updatedStatuses.set(status.provider, status);
+    this._providerStatuses.set(updatedStatuses);
+  }
+
   // Handle NDJSON response
   handleNdjsonResponse(response: NdjsonResponse) {
     if ('query' in response) {
@@ -57,11 +67,15 @@ export class State {
       // Handle individual offer response
       const offerResponse = response as OfferResponse;
       this.addOffer(offerResponse.offer);
+    } else if ('providerStatus' in response) {
+      const statusResponse = response as ProviderStatusResponse;
+      this.setProviderStatus(statusResponse.providerStatus);
     }
   }
 
   resetState() {
     this._query.set(null);
+    this._providerStatuses.set(new Map());
   }
 
   generateSessionId(): string {
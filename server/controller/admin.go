@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"server/service"
+	"server/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminAuthMiddleware protects the admin endpoints with the configured admin token. Without a token the
+// endpoints are unavailable, they expose provider internals and must never be open by accident
+func adminAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if utils.Cfg.Admin.Token == "" {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Admin endpoints are disabled, set ADMIN_TOKEN to enable them"})
+			return
+		}
+
+		expected := []byte("Bearer " + utils.Cfg.Admin.Token)
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), expected) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid admin token"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// FetchQuarantinedOffers returns the offers rejected by validation, optionally filtered by provider
+func FetchQuarantinedOffers(c *gin.Context) {
+	provider := c.Query("provider")
+
+	c.JSON(http.StatusOK, gin.H{
+		"counts": service.OfferQuarantineInstance.Counts(),
+		"offers": service.OfferQuarantineInstance.Entries(provider),
+	})
+}
+
+// FetchMetrics returns the current values of all counters
+func FetchMetrics(c *gin.Context) {
+	c.JSON(http.StatusOK, utils.MetricsSnapshot())
+}
+
+// FetchProviderHealth returns the health signals of all providers
+func FetchProviderHealth(c *gin.Context) {
+	c.JSON(http.StatusOK, service.ProviderHealthInstance.Snapshot())
+}
+
+// FetchOutboundScheduler returns the queue depth, running calls and wait times of the outbound scheduler
+func FetchOutboundScheduler(c *gin.Context) {
+	c.JSON(http.StatusOK, service.OutboundSchedulerStats())
+}
+
+// FetchProviderUsage returns the rate limits, call budgets and current calls of all providers
+func FetchProviderUsage(c *gin.Context) {
+	c.JSON(http.StatusOK, service.ProviderUsages(c.Request.Context()))
+}
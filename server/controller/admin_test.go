@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"server/utils"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/admin/ping", adminAuthMiddleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for _, tc := range []struct {
+		name          string
+		token         string
+		authorization string
+		want          int
+	}{
+		{name: "no token configured", token: "", authorization: "Bearer ", want: http.StatusServiceUnavailable},
+		{name: "missing header", token: "secret", authorization: "", want: http.StatusUnauthorized},
+		{name: "wrong token", token: "secret", authorization: "Bearer wrong", want: http.StatusUnauthorized},
+		{name: "token prefix", token: "secret", authorization: "Bearer secre", want: http.StatusUnauthorized},
+		{name: "valid token", token: "secret", authorization: "Bearer secret", want: http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			previous := utils.Cfg.Admin.Token
+			utils.Cfg.Admin.Token = tc.token
+			t.Cleanup(func() { utils.Cfg.Admin.Token = previous })
+
+			req := httptest.NewRequest(http.MethodGet, "/admin/ping", nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			if recorder.Code != tc.want {
+				t.Errorf("status = %d, want %d", recorder.Code, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"context"
+	"server/db"
+	"server/domain"
+	"server/pipeline"
+	"server/service"
+	"server/utils"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var liveLookupsCounter = utils.NewCounterVec("live_lookups_total")
+
+// labels of liveLookupsCounter
+const (
+	LOOKUP_ATTACHED = "attached"
+	LOOKUP_DETACHED = "detached"
+	// a request joined the detached lookup of another request for the same address
+	LOOKUP_JOINED = "joined"
+	// the detached lookups were at their cap, the lookup ran attached instead
+	LOOKUP_OVER_CAP = "over_cap"
+)
+
+// liveLookup is a running provider lookup for an address, requests subscribe to its offers and statuses
+type liveLookup struct {
+	offers   *utils.Broadcaster[domain.Offer]
+	statuses *utils.Broadcaster[domain.ProviderStatus]
+	// providers which were not queried, their cached offers are final
+	cacheOnly map[string]bool
+}
+
+// startLiveLookup fetches the offers of the address within the pipeline, errors are logged and complete lookups
+// are cached for the address together with the cached offers of providers which were not queried
+func startLiveLookup(p *pipeline.Pipeline, addressQuery domain.Query, cachedOffers []domain.Offer, filter domain.OfferFilter, options service.LookupOptions) liveLookup {
+	stream := offerService.FetchOffersStream(p.Context(), addressQuery.Address, filter, options)
+	pipeline.Sink(p, stream.Errors, logFetchError)
+
+	// statuses are broadcast as well, so requests joining a detached lookup receive them
+	statuses := utils.NewBroadcaster[domain.ProviderStatus](utils.BroadcasterOptions{
+		Overflow: utils.OVERFLOW_BLOCK,
+		Replay:   utils.REPLAY_ALL,
+	})
+	p.Go(func(context.Context) error {
+		// the service closes the statuses once all providers returned, which they do when the pipeline is cancelled
+		defer statuses.Close()
+		for status := range stream.Statuses {
+			statuses.Publish(status)
+		}
+		return nil
+	})
+
+	// save all live offers in address cache so that if multiple users with different filters request the same address, they can use cached offers.
+	// A filtered or fast fetch does not contain all offers of the address and must not replace the cached ones
+	if stream.Complete {
+		addressQuery.Offers = make(map[string]domain.Offer)
+		for _, offer := range cachedOffers {
+			if stream.CacheOnly[offer.Provider] {
+				offer, _ = withOfferHash(offer)
+				addressQuery.Offers[offer.HelperOfferHash] = offer
+			}
+		}
+		pipeline.Drain(p, cacheOffers(p, &addressQuery, stream.Offers.Subscribe(p.Context()).C, db.OfferCacheInstance.CacheQuery))
+	} else {
+		log.Debug("Filtered or fast fetch, offers are not cached for the address")
+	}
+
+	return liveLookup{
+		offers:    stream.Offers,
+		statuses:  statuses,
+		cacheOnly: stream.CacheOnly,
+	}
+}
+
+// detachedLookup is the map slot of a detached lookup, requests joining it wait until it started
+type detachedLookup struct {
+	started chan struct{}
+	lookup  liveLookup
+}
+
+var (
+	detachedLookupsMu sync.Mutex
+	// running detached lookups by address hash
+	detachedLookups = make(map[string]*detachedLookup)
+)
+
+// lookupOffers starts the live lookup of a request. In detach mode complete lookups run under a server owned context,
+// so they finish and fill the address cache even if the client disconnects, and concurrent requests for the same
+// address share them. Filtered and fast lookups may not contain all offers of the address, they are not cached and
+// therefore stay attached to the request
+func lookupOffers(p *pipeline.Pipeline, addressQuery domain.Query, cachedOffers []domain.Offer, filter domain.OfferFilter, options service.LookupOptions) liveLookup {
+	if !utils.Cfg.Server.DetachLookups || !filter.IsEmpty() || options.Fast {
+		liveLookupsCounter.Inc(LOOKUP_ATTACHED)
+		return startLiveLookup(p, addressQuery, cachedOffers, filter, options)
+	}
+
+	key := addressQuery.HelperAddressHash
+
+	// the slot is reserved under the lock, the lookup is started outside of it as it goes to Redis and the providers
+	detachedLookupsMu.Lock()
+	if running, ok := detachedLookups[key]; ok {
+		detachedLookupsMu.Unlock()
+		liveLookupsCounter.Inc(LOOKUP_JOINED)
+		<-running.started
+		return running.lookup
+	}
+	if len(detachedLookups) >= utils.Cfg.Server.MaxDetachedLookups {
+		detachedLookupsMu.Unlock()
+		log.WithField("max", utils.Cfg.Server.MaxDetachedLookups).Warn("Too many detached lookups, the lookup is cancelled if the client disconnects")
+		liveLookupsCounter.Inc(LOOKUP_OVER_CAP)
+		return startLiveLookup(p, addressQuery, cachedOffers, filter, options)
+	}
+	slot := &detachedLookup{started: make(chan struct{})}
+	detachedLookups[key] = slot
+	detachedLookupsMu.Unlock()
+
+	// the service applies the API timeout, the context only keeps the values of the request
+	detached := pipeline.New(context.WithoutCancel(p.Context()))
+	slot.lookup = startLiveLookup(detached, addressQuery, cachedOffers, filter, options)
+	close(slot.started)
+	liveLookupsCounter.Inc(LOOKUP_DETACHED)
+
+	go func() {
+		if err := detached.Wait(); err != nil {
+			log.WithError(err).Warn("Detached lookup failed")
+		}
+
+		detachedLookupsMu.Lock()
+		delete(detachedLookups, key)
+		detachedLookupsMu.Unlock()
+	}()
+
+	return slot.lookup
+}
@@ -0,0 +1,217 @@
+package controller
+
+import (
+	"context"
+	"server/domain"
+	"server/pipeline"
+	"server/service"
+	"server/utils"
+	"sync"
+	"testing"
+	"time"
+)
+
+// time a lookup gets to start or end before the test gives up
+const lookupTimeout = time.Second
+
+// stubLookups answers every fetch with a lookup which runs until the test finishes it
+type stubLookups struct {
+	mu      sync.Mutex
+	fetches map[string]int
+	// closing the statuses finishes the last lookup of the address
+	statuses map[string]chan domain.ProviderStatus
+	// the fetch of an address blocks until its channel is closed
+	blocked map[string]chan struct{}
+}
+
+func (s *stubLookups) FetchOffersStream(_ context.Context, address domain.Address, _ domain.OfferFilter, _ service.LookupOptions) service.OfferStream {
+	statuses := make(chan domain.ProviderStatus)
+	s.mu.Lock()
+	s.fetches[address.Street]++
+	s.statuses[address.Street] = statuses
+	blocked := s.blocked[address.Street]
+	s.mu.Unlock()
+
+	if blocked != nil {
+		<-blocked
+	}
+
+	errors := make(chan error)
+	close(errors)
+	// the lookups are not complete, so they are not cached
+	return service.OfferStream{
+		Offers:   utils.NewBroadcaster[domain.Offer](utils.BroadcasterOptions{}),
+		Errors:   errors,
+		Statuses: statuses,
+	}
+}
+
+func (s *stubLookups) fetched(street string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches[street]
+}
+
+func (s *stubLookups) finish(street string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(s.statuses[street])
+}
+
+// withStubLookups detaches the lookups of the test and answers them with a stub
+func withStubLookups(t *testing.T, maxDetached int) *stubLookups {
+	stub := &stubLookups{
+		fetches:  make(map[string]int),
+		statuses: make(map[string]chan domain.ProviderStatus),
+		blocked:  make(map[string]chan struct{}),
+	}
+
+	previous, server := offerService, utils.Cfg.Server
+	offerService = stub
+	utils.Cfg.Server.DetachLookups = true
+	utils.Cfg.Server.MaxDetachedLookups = maxDetached
+	t.Cleanup(func() {
+		offerService = previous
+		utils.Cfg.Server = server
+	})
+
+	return stub
+}
+
+func lookupQuery(street string) domain.Query {
+	return domain.Query{Address: domain.Address{Street: street}, HelperAddressHash: street}
+}
+
+func startLookup(p *pipeline.Pipeline, street string) liveLookup {
+	return lookupOffers(p, lookupQuery(street), nil, domain.OfferFilter{}, service.LookupOptions{})
+}
+
+// waitDetached waits until n detached lookups are running
+func waitDetached(t *testing.T, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(lookupTimeout)
+	for {
+		detachedLookupsMu.Lock()
+		running := len(detachedLookups)
+		detachedLookupsMu.Unlock()
+		if running == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d detached lookups are running, want %d", running, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDetachedLookupIsJoined(t *testing.T) {
+	stub := withStubLookups(t, 2)
+	p := pipeline.New(context.Background())
+	joined := liveLookupsCounter.Get(LOOKUP_JOINED)
+
+	first := startLookup(p, "a")
+	second := startLookup(p, "a")
+	if first.offers != second.offers {
+		t.Error("the second request did not receive the offers of the running lookup")
+	}
+	if got := stub.fetched("a"); got != 1 {
+		t.Errorf("the address was fetched %d times, want once", got)
+	}
+	if got := liveLookupsCounter.Get(LOOKUP_JOINED) - joined; got != 1 {
+		t.Errorf("%d requests joined, want 1", got)
+	}
+
+	stub.finish("a")
+	waitDetached(t, 0)
+}
+
+func TestDetachedLookupIsRemovedOnceFinished(t *testing.T) {
+	stub := withStubLookups(t, 2)
+	p := pipeline.New(context.Background())
+
+	first := startLookup(p, "a")
+	waitDetached(t, 1)
+	stub.finish("a")
+	waitDetached(t, 0)
+
+	// a finished lookup is not joined, the address is fetched again
+	second := startLookup(p, "a")
+	if first.offers == second.offers || stub.fetched("a") != 2 {
+		t.Errorf("the address was fetched %d times, want a new lookup after the first one finished", stub.fetched("a"))
+	}
+
+	stub.finish("a")
+	waitDetached(t, 0)
+}
+
+func TestDetachedLookupsOverCapStayAttached(t *testing.T) {
+	stub := withStubLookups(t, 1)
+	overCap := liveLookupsCounter.Get(LOOKUP_OVER_CAP)
+
+	startLookup(pipeline.New(context.Background()), "a")
+	p := pipeline.New(context.Background())
+	startLookup(p, "b")
+
+	if got := liveLookupsCounter.Get(LOOKUP_OVER_CAP) - overCap; got != 1 {
+		t.Errorf("%d lookups were over the cap, want 1", got)
+	}
+	detachedLookupsMu.Lock()
+	_, detached := detachedLookups["b"]
+	detachedLookupsMu.Unlock()
+	if detached {
+		t.Error("the lookup over the cap was detached")
+	}
+
+	// the attached lookup runs within the pipeline of the request
+	stub.finish("b")
+	if err := p.Wait(); err != nil {
+		t.Errorf("attached lookup failed with %v", err)
+	}
+	stub.finish("a")
+	waitDetached(t, 0)
+}
+
+func TestDetachedLookupStartsOutsideLock(t *testing.T) {
+	stub := withStubLookups(t, 2)
+	release := make(chan struct{})
+	stub.blocked["a"] = release
+	p := pipeline.New(context.Background())
+
+	started := make(chan liveLookup, 2)
+	go func() { started <- startLookup(p, "a") }()
+	deadline := time.Now().Add(lookupTimeout)
+	for stub.fetched("a") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the lookup of a was not fetched")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// another address starts while the first lookup is still starting
+	other := make(chan liveLookup, 1)
+	go func() { other <- startLookup(p, "b") }()
+	select {
+	case <-other:
+	case <-time.After(lookupTimeout):
+		t.Fatal("the lookup of another address waited for the first lookup to start")
+	}
+
+	// a request for the same address waits for the lookup it joins
+	go func() { started <- startLookup(p, "a") }()
+	select {
+	case <-started:
+		t.Fatal("a lookup returned before it started")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	first, second := <-started, <-started
+	if first.offers != second.offers || stub.fetched("a") != 1 {
+		t.Errorf("the address was fetched %d times, want the second request to join", stub.fetched("a"))
+	}
+
+	stub.finish("a")
+	stub.finish("b")
+	waitDetached(t, 0)
+}
@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"server/domain"
+	"server/pipeline"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// stages of the offers pipeline, see FetchOffersByAddress
+
+// responseLine is one line of the NDJSON response, e.g. {"offer": {...}}
+type responseLine struct {
+	key   string
+	value any
+}
+
+func offerLine(offer domain.Offer) (responseLine, error) {
+	return responseLine{key: "offer", value: offer}, nil
+}
+
+func statusLine(status domain.ProviderStatus) (responseLine, error) {
+	return responseLine{key: "providerStatus", value: status}, nil
+}
+
+// writeResponseLine writes and flushes a line, lines which can not be marshalled are skipped
+func writeResponseLine(writer io.Writer, flusher http.Flusher) func(responseLine) error {
+	return func(line responseLine) error {
+		valueJSON, err := json.Marshal(line.value)
+		if err != nil {
+			log.WithError(err).Warnf("Failed to marshal %s", line.key)
+			return nil
+		}
+
+		if _, err := fmt.Fprintf(writer, "{\"%s\": %s}\n", line.key, valueJSON); err != nil {
+			return fmt.Errorf("failed to write %s: %w", line.key, err)
+		}
+		flusher.Flush()
+		return nil
+	}
+}
+
+func logFetchError(err error) error {
+	log.WithError(err).Warn("Error while fetching offers")
+	return nil
+}
+
+// markPreliminary flags cached offers as preliminary if live offers are requested, the live ones replace them.
+// Offers of cache only providers are never replaced and stay final
+func markPreliminary(preliminary bool, cacheOnly map[string]bool) func(domain.Offer) (domain.Offer, error) {
+	return func(offer domain.Offer) (domain.Offer, error) {
+		offer.HelperIsPreliminary = preliminary && !cacheOnly[offer.Provider]
+		return offer, nil
+	}
+}
+
+func withOfferHash(offer domain.Offer) (domain.Offer, error) {
+	if offer.HelperOfferHash == "" {
+		offer.GenerateHash()
+	}
+	return offer, nil
+}
+
+func offerHash(offer domain.Offer) string {
+	return offer.HelperOfferHash
+}
+
+// replacesPreliminary lets a live offer replace the cached one with the same hash
+func replacesPreliminary(seen domain.Offer, _ domain.Offer) bool {
+	return seen.HelperIsPreliminary
+}
+
+// cacheOffers passes new offers and offers replacing preliminary ones, once all offers passed the query is cached
+// with them. Nothing is cached if the pipeline is cancelled before
+func cacheOffers(p *pipeline.Pipeline, query *domain.Query, offers <-chan domain.Offer, cacheFunc func(ctx context.Context, query domain.Query) error) <-chan domain.Offer {
+	unique := pipeline.Dedupe(p, pipeline.Map(p, offers, withOfferHash), offerHash, replacesPreliminary)
+	recorded := pipeline.Tap(p, unique, func(offer domain.Offer) {
+		query.Offers[offer.HelperOfferHash] = offer
+	})
+
+	return pipeline.OnComplete(p, recorded, func(ctx context.Context) error {
+		log.Debugf("Caching %d offers", len(query.Offers))
+		if err := cacheFunc(ctx, *query); err != nil {
+			log.WithError(err).Error("Failed to cache offers")
+		}
+		return nil
+	})
+}
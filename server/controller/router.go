@@ -4,12 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
+	"maps"
 	"net/http"
 	"server/db"
 	"server/domain"
+	"server/pipeline"
 	"server/service"
 	"server/utils"
+	"slices"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -26,6 +28,13 @@ func SetupRouter() *gin.Engine {
 	r.GET("/offers/shared/:shareId", FetchSharedOffers)
 	r.POST("/offers/shared/:queryHash", ShareOffer)
 
+	admin := r.Group("/admin", adminAuthMiddleware())
+	admin.GET("/quarantine", FetchQuarantinedOffers)
+	admin.GET("/metrics", FetchMetrics)
+	admin.GET("/health", FetchProviderHealth)
+	admin.GET("/usage", FetchProviderUsage)
+	admin.GET("/scheduler", FetchOutboundScheduler)
+
 	return r
 }
 
@@ -48,7 +57,12 @@ func corsMiddleware() gin.HandlerFunc {
 	}
 }
 
-var offerService = service.OfferServiceImpl{}
+// offerFetcher starts the provider lookups of an address
+type offerFetcher interface {
+	FetchOffersStream(ctx context.Context, address domain.Address, filter domain.OfferFilter, options service.LookupOptions) service.OfferStream
+}
+
+var offerService offerFetcher = service.OfferServiceImpl{}
 
 type FetchOffersQueryParameters struct {
 	Street      string `form:"street"`
@@ -56,68 +70,8 @@ type FetchOffersQueryParameters struct {
 	City        string `form:"city"`
 	ZipCode     string `form:"plz"`
 	SessionId   string `form:"sessionId"`
-}
-
-type FilterOptionParams struct {
-	Provider       *string                `form:"provider"`
-	Installation   *bool                  `form:"installation"`
-	SpeedMin       *int                   `form:"speedMin"`
-	Age            *int                   `form:"age"`
-	CostMax        *int                   `form:"costMax"`
-	ConnectionType *domain.ConnectionType `form:"connectionType"`
-}
-
-type OfferFilter func(domain.Offer) bool
-
-func (filter FilterOptionParams) standardFilter(offer domain.Offer) bool {
-	if filter.Provider != nil && *filter.Provider != "" && offer.Provider != *filter.Provider {
-		return false
-	}
-	if filter.Installation != nil && offer.InstallationService != *filter.Installation {
-		return false
-	}
-	if filter.SpeedMin != nil && offer.Speed < *filter.SpeedMin {
-		return false
-	}
-	if filter.Age != nil && offer.MaxAgePerson < *filter.Age {
-		return false
-	}
-	if filter.CostMax != nil && offer.MonthlyCostInCent > *filter.CostMax {
-		return false
-	}
-	if filter.ConnectionType != nil && *filter.ConnectionType != "" && offer.ConnectionType != *filter.ConnectionType {
-		return false
-	}
-	return true
-}
-
-func (filter FilterOptionParams) isEmpty() bool {
-	return (filter.Provider == nil || *filter.Provider == "") && filter.Installation == nil && filter.SpeedMin == nil &&
-		filter.Age == nil && filter.CostMax == nil && (filter.ConnectionType == nil || *filter.ConnectionType == "")
-}
-
-func (filter FilterOptionParams) hash() string {
-	agg := make([]byte, 0)
-	if filter.Provider != nil {
-		agg = fmt.Appendf(agg, "%s", *filter.Provider)
-	}
-	if filter.Installation != nil {
-		agg = fmt.Appendf(agg, "%t", *filter.Installation)
-	}
-	if filter.SpeedMin != nil {
-		agg = fmt.Appendf(agg, "%d", *filter.SpeedMin)
-	}
-	if filter.Age != nil {
-		agg = fmt.Appendf(agg, "%d", *filter.Age)
-	}
-	if filter.CostMax != nil {
-		agg = fmt.Appendf(agg, "%d", *filter.CostMax)
-	}
-	if filter.ConnectionType != nil {
-		agg = fmt.Appendf(agg, "%s", (*filter.ConnectionType).String())
-	}
-
-	return string(utils.Hash(agg))
+	// accept partial results after a short deadline
+	Fast bool `form:"fast"`
 }
 
 func FetchOffersByAddress(c *gin.Context) {
@@ -164,6 +118,14 @@ func FetchOffersByAddress(c *gin.Context) {
 		return
 	}
 
+	// filter options are optional, providers evaluate what they support upstream and the rest is filtered here
+	var filter domain.OfferFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		log.WithError(err).Warn("Failed to parse filter query parameters")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filter query parameters"})
+		return
+	}
+
 	// Create address object
 	userQuery.Address = domain.Address{
 		Street:      params.Street,
@@ -193,108 +155,50 @@ func FetchOffersByAddress(c *gin.Context) {
 	}
 
 	ctx := c.Request.Context()
-	combinedOfferChannel := make(chan domain.Offer)
-	shouldApiRequest := true
+	p := pipeline.New(ctx)
 
 	// retrieve cached offers for address
-	cachedOffersInStream := make(chan struct{})
+	shouldApiRequest := true
+	var cachedOffers []domain.Offer
 	if cachedQuery, _ := db.OfferCacheInstance.GetCachedQuery(ctx, addressQuery); cachedQuery != nil {
 		log.Debugf("Found cached query for address %s", addressQuery.Address)
 		shouldApiRequest = now-cachedQuery.Timestamp > utils.Cfg.Server.FreshnessWindowSec
-
-		go func() {
-			for _, offer := range cachedQuery.Offers {
-				// if a new request gonna happen, set preliminary flag to true to indicate that these are cached and not live from api
-				offer.HelperIsPreliminary = shouldApiRequest
-				combinedOfferChannel <- offer
-			}
-			close(cachedOffersInStream)
-		}()
-	} else {
-		close(cachedOffersInStream)
+		cachedOffers = slices.Collect(maps.Values(cachedQuery.Offers))
 	}
 
-	var offersStreamingDone <-chan struct{}
+	var offerSources []<-chan domain.Offer
+	var lines []<-chan responseLine
+	var cacheOnly map[string]bool
+
 	if shouldApiRequest {
-		// Start the streaming service
-		liveOffersPubSubChannel, errChannel := offerService.FetchOffersStream(ctx, addressQuery.Address)
-		// Process errors
-		go func() {
-			for {
-				select {
-				case err, ok := <-errChannel:
-					if !ok {
-						return
-					}
-					log.WithError(err).Warn("Error while fetching offers")
-				case <-ctx.Done():
-					// Context cancelled, stop processing
-					return
-				}
-			}
-		}()
-		// save all live offers in address cache so that if multiple users with different filters request the same address, they can use cached offers
-		dumpChan, addressCacheDone := cacheOffers(ctx, &addressQuery, liveOffersPubSubChannel.Subscribe(), db.OfferCacheInstance.CacheQuery)
-		utils.DumpChannel(dumpChan)
-
-		// put live offers into combined stream to stream to output
-		liveOffersInStream := make(chan struct{})
-		go func(liveOffersChannel <-chan domain.Offer) {
-			for {
-				select {
-				case offer, ok := <-liveOffersChannel:
-					if !ok {
-						// all offers are processed, close the channel to signal all live offers are in streaming channel
-						close(liveOffersInStream)
-						return
-					}
-
-					combinedOfferChannel <- offer
-				case <-ctx.Done():
-					// Context cancelled, stop processing
-					close(liveOffersInStream)
-					return
-				}
-			}
-		}(liveOffersPubSubChannel.Subscribe())
-
-		// cache offers for user which are preliminary and live to ensure share links with both contained
-		userCachedOfferChannel, _ := cacheOffers(ctx, &userQuery, combinedOfferChannel, db.UserOfferCacheInstance.CacheQuery)
-
-		// stream everything that is cached for later sharing to the user
-		offersStreamingDone = handleOfferStreaming(ctx, c.Writer, flusher, userCachedOfferChannel)
-
-		// wait until all cached offers are in streaming channel
-		<-cachedOffersInStream
-		log.Debug("Cached offers in combined stream")
-		// wait until all live offers are in streaming channel
-		<-liveOffersInStream
-		log.Debug("Live offers in combined stream")
-
-		close(combinedOfferChannel)
-
-		// wait until all offers cached for address before closing request
-		<-addressCacheDone
-		log.Debug("caching offers for address done")
+		liveOffers := lookupOffers(p, addressQuery, cachedOffers, filter, service.LookupOptions{Fast: params.Fast})
+		cacheOnly = liveOffers.cacheOnly
+
+		// providers only evaluate parts of the filter upstream
+		offerSources = append(offerSources, pipeline.Filter(p, liveOffers.offers.Subscribe(p.Context()).C, filter.Matches))
+		lines = append(lines, pipeline.Map(p, liveOffers.statuses.Subscribe(p.Context()).C, statusLine))
 	} else {
 		log.Debug("Using cached offers for address, no new API request will be made")
-
-		// offers by cache are counted as valid as no new api request is made
-		// therefore they need to be saved in the user cache
-		cachedOffers, _ := cacheOffers(ctx, &userQuery, combinedOfferChannel, db.UserOfferCacheInstance.CacheQuery)
-		offersStreamingDone = handleOfferStreaming(ctx, c.Writer, flusher, cachedOffers)
-
-		// wait until cached offers are all in streaming channel
-		<-cachedOffersInStream
-		log.Debug("Cached offers in combined stream")
-		close(combinedOfferChannel)
 	}
 
-	// Wait for the streaming
-	<-offersStreamingDone
+	// if a new request gonna happen, cached offers are preliminary to indicate that they are not live from api,
+	// unless their provider is not queried
+	offerSources = append(offerSources,
+		pipeline.Map(p, pipeline.Filter(p, pipeline.FromSlice(p, cachedOffers), filter.Matches), markPreliminary(shouldApiRequest, cacheOnly)),
+	)
+
+	// offers streamed to the user are cached for sharing, cached ones as well as they are counted as valid
+	// if no new api request is made
+	userOffers := cacheOffers(p, &userQuery, pipeline.FanIn(p, offerSources...), db.UserOfferCacheInstance.CacheQuery)
+	lines = append(lines, pipeline.Map(p, userOffers, offerLine))
+	pipeline.Sink(p, pipeline.FanIn(p, lines...), writeResponseLine(c.Writer, flusher))
 
-	// write query related information to the response
-	log.Debug("Stream successfully closed\n")
+	// the pipeline ends once all offers are streamed and cached
+	if err := p.Wait(); err != nil {
+		log.WithError(err).Debug("Offer stream ended early")
+		return
+	}
+	log.Debug("Stream successfully closed")
 }
 
 func ShareOffer(c *gin.Context) {
@@ -312,7 +216,7 @@ func ShareOffer(c *gin.Context) {
 	}
 
 	// as we filter client side, but want to display the same offers in the share link, we need to filter the cached offers now before creating the snapshot
-	var filterParams FilterOptionParams
+	var filterParams domain.OfferFilter
 	if err := c.ShouldBind(&filterParams); err != nil {
 		log.WithError(err).Warn("Failed to parse filter query parameters")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filter query parameters"})
@@ -328,14 +232,14 @@ func ShareOffer(c *gin.Context) {
 	}
 
 	// filter offers based on the provided filter parameters
-	isFilterEmpty := filterParams.isEmpty()
+	isFilterEmpty := filterParams.IsEmpty()
 	filteredOffers := make(map[string]domain.Offer)
 
 	// create shareId by hashing of offer hashes, filterParams and queryHash
 	idAgg := make([]byte, 0)
-	idAgg = fmt.Appendf(idAgg, "%s%s", queryHash, filterParams.hash())
+	idAgg = fmt.Appendf(idAgg, "%s%s", queryHash, filterParams.Hash())
 	for _, offer := range query.Offers {
-		if isFilterEmpty || filterParams.standardFilter(offer) {
+		if isFilterEmpty || filterParams.Matches(offer) {
 			filteredOffers[offer.HelperOfferHash] = offer
 			idAgg = fmt.Appendf(idAgg, "%s%t", offer.HelperOfferHash, offer.HelperIsPreliminary)
 		}
@@ -428,78 +332,3 @@ func FetchSharedOffers(c *gin.Context) {
 		}
 	}
 }
-
-func cacheOffers(ctx context.Context, query *domain.Query, offersChannel <-chan domain.Offer, cacheFunc func(ctx context.Context, query domain.Query) error) (<-chan domain.Offer, <-chan struct{}) {
-	done := make(chan struct{})
-	cachedOffersChannel := make(chan domain.Offer)
-
-	go func() {
-		for {
-			select {
-			case offer, ok := <-offersChannel:
-				if !ok {
-					// Cache the offers for the address
-					log.Debugf("Caching %d offers", len(query.Offers))
-					if err := cacheFunc(ctx, *query); err != nil {
-						log.WithError(err).Error("Failed to cache offers for address")
-					}
-					close(cachedOffersChannel)
-					close(done)
-					return
-				}
-				if offer.HelperOfferHash == "" {
-					// Generate hash for the offer if not already set
-					offer.GenerateHash()
-				}
-
-				offerInQuery, exists := query.Offers[offer.HelperOfferHash]
-				if !exists || offerInQuery.HelperIsPreliminary {
-					// Send the offer to the fanout channel
-					cachedOffersChannel <- offer
-
-					// Also append the offer to the address query for caching
-					query.Offers[offer.HelperOfferHash] = offer
-				}
-			case <-ctx.Done():
-				// Context cancelled, stop processing
-				log.Debug("Context cancelled, stopping offer caching")
-				close(cachedOffersChannel)
-				close(done)
-				return
-			}
-		}
-	}()
-
-	return cachedOffersChannel, done
-}
-
-func handleOfferStreaming(c context.Context, writer io.Writer, flusher http.Flusher, offersChannel <-chan domain.Offer) (done chan struct{}) {
-	done = make(chan struct{})
-
-	go func() {
-		for {
-			select {
-			case offer, ok := <-offersChannel:
-				if !ok {
-					close(done)
-					return
-				}
-
-				if offerJSON, err := json.Marshal(offer); err == nil {
-					fmt.Fprintf(writer, "{\"offer\": %s}\n", offerJSON)
-					flusher.Flush()
-				} else {
-					log.WithError(err).Warn("Failed to marshal offer")
-				}
-
-			case <-c.Done():
-				// Context cancelled, stop processing
-				log.Debug("Context cancelled, stopping offer streaming")
-				close(done)
-				return
-			}
-		}
-	}()
-
-	return done
-}
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// negativeCache remembers per address which providers had no offers or rejected it, in the offer cache Redis
+type negativeCache struct {
+	redisClient *redis.Client
+}
+
+var (
+	NegativeCacheInstance negativeCache
+)
+
+// InitNegativeCache shares the Redis client of the offer cache, call InitOfferCache first
+func InitNegativeCache() {
+	NegativeCacheInstance = negativeCache{redisClient: OfferCacheInstance.redisClient}
+}
+
+func (cache negativeCache) cacheKey(addressHash string, provider string) string {
+	return "negative:" + provider + ":" + addressHash
+}
+
+// Get returns the cached reasons of the providers for the address, providers without an entry are left out
+func (cache negativeCache) Get(ctx context.Context, addressHash string, providers []string) (map[string]string, error) {
+	keys := make([]string, 0, len(providers))
+	for _, provider := range providers {
+		keys = append(keys, cache.cacheKey(addressHash, provider))
+	}
+
+	values, err := cache.redisClient.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get negative cache entries: %w", err)
+	}
+
+	reasons := make(map[string]string)
+	for i, provider := range providers {
+		if reason, ok := values[i].(string); ok {
+			reasons[provider] = reason
+		}
+	}
+
+	return reasons, nil
+}
+
+// Set stores the reason why the provider has no offers for the address until the TTL expires
+func (cache negativeCache) Set(ctx context.Context, addressHash string, provider string, reason string, ttl time.Duration) error {
+	if err := cache.redisClient.Set(ctx, cache.cacheKey(addressHash, provider), reason, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to store negative cache entry: %w", err)
+	}
+
+	return nil
+}
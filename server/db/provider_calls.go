@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"server/domain"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	DAY_LAYOUT   = "2006-01-02"
+	MONTH_LAYOUT = "2006-01"
+)
+
+// providerCalls counts the upstream calls of every provider in the offer cache Redis, so all replicas share the
+// counts and the budgets of the providers
+type providerCalls struct {
+	redisClient *redis.Client
+}
+
+var (
+	ProviderCallsInstance providerCalls
+)
+
+// InitProviderCalls shares the Redis client of the offer cache, call InitOfferCache first
+func InitProviderCalls() {
+	ProviderCallsInstance = providerCalls{redisClient: OfferCacheInstance.redisClient}
+}
+
+func (calls providerCalls) dailyKey(provider string, now time.Time) string {
+	return "provider_calls:" + provider + ":" + now.Format(DAY_LAYOUT)
+}
+
+func (calls providerCalls) monthlyKey(provider string, now time.Time) string {
+	return "provider_calls:" + provider + ":" + now.Format(MONTH_LAYOUT)
+}
+
+// recordCall counts a call unless the daily (KEYS[1]) or monthly (KEYS[2]) count reached its budget (ARGV[1] and
+// ARGV[2], 0 is unlimited). It returns both counts and 1 if the call was counted, calls over budget are not sent and
+// must not use up the budget
+var recordCall = redis.NewScript(`
+local daily = tonumber(redis.call('GET', KEYS[1]) or '0')
+local monthly = tonumber(redis.call('GET', KEYS[2]) or '0')
+local dailyBudget, monthlyBudget = tonumber(ARGV[1]), tonumber(ARGV[2])
+if (dailyBudget > 0 and daily >= dailyBudget) or (monthlyBudget > 0 and monthly >= monthlyBudget) then
+	return {daily, monthly, 0}
+end
+daily = redis.call('INCR', KEYS[1])
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+monthly = redis.call('INCR', KEYS[2])
+redis.call('EXPIRE', KEYS[2], ARGV[4])
+return {daily, monthly, 1}
+`)
+
+// Record counts a call of the provider unless it goes beyond the budget, a zero budget is unlimited. It returns the
+// counts including the call, or the current counts and false if the call is over budget and was not counted
+func (calls providerCalls) Record(ctx context.Context, provider string, budget domain.CallCount) (domain.CallCount, bool, error) {
+	now := time.Now().UTC()
+
+	// the keys outlive their period a little, so the counts of the last period can still be looked at
+	keys := []string{calls.dailyKey(provider, now), calls.monthlyKey(provider, now)}
+	result, err := recordCall.Run(ctx, calls.redisClient, keys,
+		budget.Daily, budget.Monthly, int64((48 * time.Hour).Seconds()), int64((62 * 24 * time.Hour).Seconds())).Int64Slice()
+	if err != nil {
+		return domain.CallCount{}, false, fmt.Errorf("failed to count call of %s: %w", provider, err)
+	}
+	if len(result) != 3 {
+		return domain.CallCount{}, false, fmt.Errorf("failed to count call of %s: unexpected result %v", provider, result)
+	}
+
+	return domain.CallCount{Daily: result[0], Monthly: result[1]}, result[2] == 1, nil
+}
+
+// Counts returns the counts of the current day and month by provider, providers without calls are counted with zero
+func (calls providerCalls) Counts(ctx context.Context, providers []string) (map[string]domain.CallCount, error) {
+	now := time.Now().UTC()
+
+	keys := make([]string, 0, 2*len(providers))
+	for _, provider := range providers {
+		keys = append(keys, calls.dailyKey(provider, now), calls.monthlyKey(provider, now))
+	}
+
+	values, err := calls.redisClient.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get provider calls: %w", err)
+	}
+
+	counts := make(map[string]domain.CallCount, len(providers))
+	for i, provider := range providers {
+		counts[provider] = domain.CallCount{
+			Daily:   parseCount(values[2*i]),
+			Monthly: parseCount(values[2*i+1]),
+		}
+	}
+
+	return counts, nil
+}
+
+// parseCount reads a counter value of MGET, missing keys are nil
+func parseCount(value any) int64 {
+	s, _ := value.(string)
+	count, _ := strconv.ParseInt(s, 10, 64)
+	return count
+}
@@ -0,0 +1,74 @@
+package domain
+
+import (
+	"fmt"
+	"server/utils"
+)
+
+// OfferFilter holds the filter options of a user, nil fields do not filter
+type OfferFilter struct {
+	Provider       *string         `form:"provider" json:"provider,omitempty"`
+	Installation   *bool           `form:"installation" json:"installation,omitempty"`
+	SpeedMin       *int            `form:"speedMin" json:"speedMin,omitempty"`
+	Age            *int            `form:"age" json:"age,omitempty"`
+	CostMax        *int            `form:"costMax" json:"costMax,omitempty"`
+	ConnectionType *ConnectionType `form:"connectionType" json:"connectionType,omitempty"`
+}
+
+// Matches reports whether the offer passes all filter options
+func (filter OfferFilter) Matches(offer Offer) bool {
+	if filter.Provider != nil && *filter.Provider != "" && offer.Provider != *filter.Provider {
+		return false
+	}
+	// offers with optional installation service match both values, the price of the chosen option is compared below
+	monthlyCostInCent := offer.MonthlyCostInCent
+	if filter.Installation != nil {
+		option, ok := offer.InstallationOption(*filter.Installation)
+		if !ok {
+			return false
+		}
+		monthlyCostInCent = option.MonthlyCostInCent
+	}
+	if filter.SpeedMin != nil && offer.Speed < *filter.SpeedMin {
+		return false
+	}
+	if filter.Age != nil && offer.MaxAgePerson < *filter.Age {
+		return false
+	}
+	if filter.CostMax != nil && monthlyCostInCent > *filter.CostMax {
+		return false
+	}
+	if filter.ConnectionType != nil && *filter.ConnectionType != "" && offer.ConnectionType != *filter.ConnectionType {
+		return false
+	}
+	return true
+}
+
+func (filter OfferFilter) IsEmpty() bool {
+	return (filter.Provider == nil || *filter.Provider == "") && filter.Installation == nil && filter.SpeedMin == nil &&
+		filter.Age == nil && filter.CostMax == nil && (filter.ConnectionType == nil || *filter.ConnectionType == "")
+}
+
+func (filter OfferFilter) Hash() string {
+	agg := make([]byte, 0)
+	if filter.Provider != nil {
+		agg = fmt.Appendf(agg, "%s", *filter.Provider)
+	}
+	if filter.Installation != nil {
+		agg = fmt.Appendf(agg, "%t", *filter.Installation)
+	}
+	if filter.SpeedMin != nil {
+		agg = fmt.Appendf(agg, "%d", *filter.SpeedMin)
+	}
+	if filter.Age != nil {
+		agg = fmt.Appendf(agg, "%d", *filter.Age)
+	}
+	if filter.CostMax != nil {
+		agg = fmt.Appendf(agg, "%d", *filter.CostMax)
+	}
+	if filter.ConnectionType != nil {
+		agg = fmt.Appendf(agg, "%s", (*filter.ConnectionType).String())
+	}
+
+	return string(utils.Hash(agg))
+}
@@ -0,0 +1,100 @@
+package domain
+
+import (
+	"fmt"
+	"slices"
+)
+
+// InstallationOption is one way to order an offer whose installation service is optional
+type InstallationOption struct {
+	InstallationService          bool `json:"installationService"`
+	MonthlyCostInCent            int  `json:"monthlyCostInCent"`
+	MonthlyCostInCentWithVoucher int  `json:"monthlyCostInCentWithVoucher,omitzero"`
+	AfterTwoYearsMonthlyCost     int  `json:"afterTwoYearsMonthlyCost,omitzero"`
+}
+
+func (option InstallationOption) getHash() string {
+	return fmt.Sprintf("%t%d%d%d", option.InstallationService, option.MonthlyCostInCent, option.MonthlyCostInCentWithVoucher, option.AfterTwoYearsMonthlyCost)
+}
+
+// IsInstallationOptional reports whether the offer can be ordered with and without installation service
+func (o *Offer) IsInstallationOptional() bool {
+	return len(o.InstallationOptions) > 1
+}
+
+// InstallationOption returns the prices of the offer with or without installation service.
+// Offers without options only have the option given by InstallationService
+func (o *Offer) InstallationOption(installation bool) (InstallationOption, bool) {
+	if len(o.InstallationOptions) == 0 {
+		if o.InstallationService != installation {
+			return InstallationOption{}, false
+		}
+		return InstallationOption{
+			InstallationService:          o.InstallationService,
+			MonthlyCostInCent:            o.MonthlyCostInCent,
+			MonthlyCostInCentWithVoucher: o.MonthlyCostInCentWithVoucher,
+			AfterTwoYearsMonthlyCost:     o.AfterTwoYearsMonthlyCost,
+		}, true
+	}
+
+	index := slices.IndexFunc(o.InstallationOptions, func(option InstallationOption) bool {
+		return option.InstallationService == installation
+	})
+	if index < 0 {
+		return InstallationOption{}, false
+	}
+
+	return o.InstallationOptions[index], true
+}
+
+// InstallationPriceDifferenceInCent returns how much more the offer costs per month with installation service,
+// it is zero if the installation service is not optional
+func (o *Offer) InstallationPriceDifferenceInCent() int {
+	with, okWith := o.InstallationOption(true)
+	without, okWithout := o.InstallationOption(false)
+	if !okWith || !okWithout {
+		return 0
+	}
+
+	return with.MonthlyCostInCent - without.MonthlyCostInCent
+}
+
+// MergeInstallationOptions combines the variants of the same product with and without installation service into one offer.
+// The merged offer shows the prices without installation service and lists both options. Variants differing in anything
+// but installation service and prices are different products and are not merged
+func MergeInstallationOptions(withInstallation Offer, withoutInstallation Offer) (Offer, bool) {
+	if installationIndependentHash(withInstallation) != installationIndependentHash(withoutInstallation) {
+		return Offer{}, false
+	}
+
+	merged := withoutInstallation
+	merged.InstallationService = false
+	merged.InstallationOptions = []InstallationOption{
+		{
+			InstallationService:          false,
+			MonthlyCostInCent:            withoutInstallation.MonthlyCostInCent,
+			MonthlyCostInCentWithVoucher: withoutInstallation.MonthlyCostInCentWithVoucher,
+			AfterTwoYearsMonthlyCost:     withoutInstallation.AfterTwoYearsMonthlyCost,
+		},
+		{
+			InstallationService:          true,
+			MonthlyCostInCent:            withInstallation.MonthlyCostInCent,
+			MonthlyCostInCentWithVoucher: withInstallation.MonthlyCostInCentWithVoucher,
+			AfterTwoYearsMonthlyCost:     withInstallation.AfterTwoYearsMonthlyCost,
+		},
+	}
+
+	return merged, true
+}
+
+// installationIndependentHash hashes the offer without the fields which differ between its installation variants
+func installationIndependentHash(o Offer) string {
+	o.InstallationService = false
+	o.InstallationOptions = nil
+	o.MonthlyCostInCent = 0
+	o.MonthlyCostInCentWithVoucher = 0
+	o.AfterTwoYearsMonthlyCost = 0
+	o.GenerateHash()
+
+	return o.HelperOfferHash
+}
@@ -3,27 +3,29 @@ package domain
 import (
 	"fmt"
 	"server/utils"
+	"slices"
 	"strings"
 )
 
 type Offer struct {
 	// product details
 
-	Provider                     string            `json:"provider"`
-	ProductID                    int               `json:"productId,omitzero"`
-	ProductName                  string            `json:"productName"`
-	Speed                        int               `json:"speed"`
-	ContractDurationInMonths     int               `json:"contractDurationInMonths"`
-	ConnectionType               ConnectionType    `json:"connectionType"`
-	Tv                           string            `json:"tv,omitzero"`
-	LimitInGb                    int               `json:"limitInGb,omitzero"`
-	MaxAgePerson                 int               `json:"maxAgePerson,omitzero"`
-	MonthlyCostInCent            int               `json:"monthlyCostInCent"`
-	MonthlyCostInCentWithVoucher int               `json:"monthlyCostInCentWithVoucher,omitzero"`
-	AfterTwoYearsMonthlyCost     int               `json:"afterTwoYearsMonthlyCost,omitzero"`
-	InstallationService          bool              `json:"installationService"`
-	VoucherDetails               VoucherDetails    `json:"voucherDetails,omitzero"`
-	ExtraProperties              map[string]string `json:"extraProperties,omitzero"`
+	Provider                     string               `json:"provider"`
+	ProductID                    int                  `json:"productId,omitzero"`
+	ProductName                  string               `json:"productName"`
+	Speed                        int                  `json:"speed"`
+	ContractDurationInMonths     int                  `json:"contractDurationInMonths"`
+	ConnectionType               ConnectionType       `json:"connectionType"`
+	Tv                           string               `json:"tv,omitzero"`
+	LimitInGb                    int                  `json:"limitInGb,omitzero"`
+	MaxAgePerson                 int                  `json:"maxAgePerson,omitzero"`
+	MonthlyCostInCent            int                  `json:"monthlyCostInCent"`
+	MonthlyCostInCentWithVoucher int                  `json:"monthlyCostInCentWithVoucher,omitzero"`
+	AfterTwoYearsMonthlyCost     int                  `json:"afterTwoYearsMonthlyCost,omitzero"`
+	InstallationService          bool                 `json:"installationService"`
+	InstallationOptions          []InstallationOption `json:"installationOptions,omitzero"` // only set if the installation service is optional
+	VoucherDetails               VoucherDetails       `json:"voucherDetails,omitzero"`
+	ExtraProperties              map[string]string    `json:"extraProperties,omitzero"`
 
 	// helper fields
 
@@ -33,10 +35,44 @@ type Offer struct {
 }
 
 func (o *Offer) GenerateHash() {
-	o.HelperOfferHash = utils.HashURLEncoded(fmt.Appendf(nil, "%s%d%s%d%d%s%s%d%d%d%d%d%t%s%v", o.Provider, o.ProductID, o.ProductName,
+	o.HelperOfferHash = utils.HashURLEncoded(fmt.Appendf(nil, "%s%d%s%d%d%s%s%d%d%d%d%d%t%s%s%s", o.Provider, o.ProductID, o.ProductName,
 		o.Speed, o.ContractDurationInMonths, o.ConnectionType, o.Tv, o.LimitInGb, o.MaxAgePerson,
 		o.MonthlyCostInCent, o.AfterTwoYearsMonthlyCost, o.MonthlyCostInCentWithVoucher, o.InstallationService,
-		o.VoucherDetails.GetHash(), o.ExtraProperties))
+		o.VoucherDetails.GetHash(), o.extraPropertiesHash(), o.installationOptionsHash()))
+}
+
+// extraPropertiesHash serializes the extra properties sorted by key so the hash does not depend on map ordering
+func (o *Offer) extraPropertiesHash() string {
+	keys := make([]string, 0, len(o.ExtraProperties))
+	for key := range o.ExtraProperties {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	agg := make([]byte, 0)
+	for _, key := range keys {
+		agg = fmt.Appendf(agg, "%q=%q;", key, o.ExtraProperties[key])
+	}
+
+	return string(utils.Hash(agg))
+}
+
+func (o *Offer) installationOptionsHash() string {
+	agg := make([]byte, 0)
+	for _, option := range o.InstallationOptions {
+		agg = fmt.Appendf(agg, "%s;", option.getHash())
+	}
+
+	return string(agg)
+}
+
+// SetExtraProperty stores a provider specific value which has no field in the offer.
+// Keys are namespaced by the provider, e.g. "byteme.someColumn"
+func (o *Offer) SetExtraProperty(key string, value string) {
+	if o.ExtraProperties == nil {
+		o.ExtraProperties = make(map[string]string)
+	}
+	o.ExtraProperties[key] = value
 }
 
 // ConnectionType represents the type of internet connection
@@ -0,0 +1,75 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+)
+
+// plausibility bounds for offer values, anything outside is treated as a parsing error on our or the provider side
+const (
+	MaxPlausibleSpeed            = 100000 // Mbit/s
+	MaxPlausibleContractDuration = 60     // months
+)
+
+func (c ConnectionType) IsValid() bool {
+	switch c {
+	case DSL, CABLE, FIBER, MOBILE:
+		return true
+	default:
+		return false
+	}
+}
+
+// Validate checks the invariants every offer has to fulfill before it is handed out to users.
+// All violated invariants are joined into the returned error
+func (o Offer) Validate() error {
+	var errs []error
+
+	if o.MonthlyCostInCent <= 0 {
+		errs = append(errs, fmt.Errorf("monthly cost must be positive, got %d", o.MonthlyCostInCent))
+	}
+	if !o.ConnectionType.IsValid() {
+		errs = append(errs, fmt.Errorf("unknown connection type %q", o.ConnectionType))
+	}
+	if o.Speed <= 0 || o.Speed > MaxPlausibleSpeed {
+		errs = append(errs, fmt.Errorf("implausible speed %d Mbit/s", o.Speed))
+	}
+	if o.ContractDurationInMonths <= 0 || o.ContractDurationInMonths > MaxPlausibleContractDuration {
+		errs = append(errs, fmt.Errorf("implausible contract duration %d months", o.ContractDurationInMonths))
+	}
+	if err := o.validateVoucher(); err != nil {
+		errs = append(errs, err)
+	}
+	for _, option := range o.InstallationOptions {
+		if option.MonthlyCostInCent <= 0 {
+			errs = append(errs, fmt.Errorf("monthly cost of installation option %t must be positive, got %d", option.InstallationService, option.MonthlyCostInCent))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateVoucher checks that the voucher never exceeds the price of the offer
+func (o Offer) validateVoucher() error {
+	if o.VoucherDetails.Value < 0 {
+		return fmt.Errorf("negative voucher value %d", o.VoucherDetails.Value)
+	}
+
+	switch o.VoucherDetails.Type {
+	case PERCENTAGE:
+		if o.VoucherDetails.Value > 100 {
+			return fmt.Errorf("percentage voucher of %d%% exceeds the price", o.VoucherDetails.Value)
+		}
+	case ABSOLUTE:
+		if o.ContractDurationInMonths > 0 && o.VoucherDetails.Value > o.MonthlyCostInCent*o.ContractDurationInMonths {
+			return fmt.Errorf("absolute voucher of %d cent exceeds the contract price of %d cent",
+				o.VoucherDetails.Value, o.MonthlyCostInCent*o.ContractDurationInMonths)
+		}
+	}
+
+	if o.MonthlyCostInCentWithVoucher < 0 {
+		return fmt.Errorf("monthly cost with voucher is negative: %d", o.MonthlyCostInCentWithVoucher)
+	}
+
+	return nil
+}
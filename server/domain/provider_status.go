@@ -0,0 +1,34 @@
+package domain
+
+type ProviderStatusType string
+
+const (
+	// all requests succeeded and offers were found
+	PROVIDER_OK ProviderStatusType = "OK"
+	// offers were found but some requests failed, there may be offers missing
+	PROVIDER_PARTIAL ProviderStatusType = "PARTIAL"
+	// all requests succeeded but the provider has no offers for the address
+	PROVIDER_NO_OFFERS ProviderStatusType = "NO_OFFERS"
+	// no offers were found as the provider failed
+	PROVIDER_ERROR ProviderStatusType = "ERROR"
+	// the provider did not finish within its timeout
+	PROVIDER_TIMEOUT ProviderStatusType = "TIMEOUT"
+	// the provider was not queried as the filter excludes all of its offers
+	PROVIDER_SKIPPED ProviderStatusType = "SKIPPED"
+	// the provider was not queried as its call budget is nearly used up, only its cached offers are shown
+	PROVIDER_CACHE_ONLY ProviderStatusType = "CACHE_ONLY"
+	// the provider was not queried as it recently had no offers for the address or rejected it, see ErrorKind
+	PROVIDER_NO_COVERAGE_CACHED ProviderStatusType = "NO_COVERAGE_CACHED"
+)
+
+// ProviderStatus is streamed once a provider finished, so users can tell "no offers here" apart from "provider failed"
+type ProviderStatus struct {
+	Provider string             `json:"provider"`
+	Status   ProviderStatusType `json:"status"`
+	Offers   int                `json:"offers"`
+	Errors   int                `json:"errors,omitzero"`
+	// kind of the last typed provider error, e.g. CLIENT_FAULT
+	ErrorKind string `json:"errorKind,omitzero"`
+	// timeout the provider was given in this lookup
+	TimeoutMs int64 `json:"timeoutMs,omitzero"`
+}
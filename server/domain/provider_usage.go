@@ -0,0 +1,7 @@
+package domain
+
+// CallCount is the number of upstream calls of a provider in the current day and month, both in UTC
+type CallCount struct {
+	Daily   int64 `json:"daily"`
+	Monthly int64 `json:"monthly"`
+}
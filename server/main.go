@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"server/controller"
 	"server/db"
+	"server/service"
 	"server/utils"
 
 	"github.com/gin-gonic/gin"
@@ -15,10 +17,21 @@ func main() {
 	cfg := utils.LoadConfig()
 	log.Infof("Loaded configuration: %+v", cfg.Server)
 
+	// Pick up rotated provider credentials
+	go utils.WatchCredentials(context.Background())
+
 	// Initialize Redis client
 	db.InitOfferCache()
 	db.InitUserOfferCache()
 
+	// Count provider calls for the call budgets
+	db.InitProviderCalls()
+	service.CallCounterInstance = db.ProviderCallsInstance
+
+	// Skip providers without coverage for an address
+	db.InitNegativeCache()
+	service.NegativeCacheInstance = db.NegativeCacheInstance
+
 	// Initialize share database
 	db.InitShareDb()
 
@@ -0,0 +1,113 @@
+// Package pipeline connects typed stream stages with channels. Every stage runs in its own goroutine owned by a
+// Pipeline, stops once the context of the pipeline is done and closes its output when it returns. The first error
+// of a stage cancels the whole pipeline and is returned by Wait
+package pipeline
+
+import (
+	"context"
+	"sync"
+)
+
+type Pipeline struct {
+	parent context.Context
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	wg     sync.WaitGroup
+
+	mu  sync.Mutex
+	err error
+}
+
+func New(ctx context.Context) *Pipeline {
+	pipelineCtx, cancel := context.WithCancelCause(ctx)
+
+	return &Pipeline{
+		parent: ctx,
+		ctx:    pipelineCtx,
+		cancel: cancel,
+	}
+}
+
+// Context is done once the parent context is done or a stage failed, stages and the work they start should use it
+func (p *Pipeline) Context() context.Context {
+	return p.ctx
+}
+
+// Go runs a stage, an error cancels the pipeline
+func (p *Pipeline) Go(stage func(ctx context.Context) error) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		if err := stage(p.ctx); err != nil {
+			p.fail(err)
+		}
+	}()
+}
+
+func (p *Pipeline) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err == nil {
+		p.err = err
+		p.cancel(err)
+	}
+}
+
+// Wait blocks until all stages returned. It returns the first error of a stage or the error of the parent context
+// if the pipeline was cancelled from outside
+func (p *Pipeline) Wait() error {
+	p.wg.Wait()
+	defer p.cancel(nil)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return p.err
+	}
+	return p.parent.Err()
+}
+
+// send reports false if the context ended before the value was taken
+func send[T any](ctx context.Context, out chan<- T, value T) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case out <- value:
+		return true
+	}
+}
+
+// each calls fn for every value until the input is closed, the context ends or fn fails
+func each[T any](ctx context.Context, in <-chan T, fn func(T) error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case value, ok := <-in:
+			if !ok {
+				return nil
+			}
+			if err := fn(value); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// stage runs a stage whose output is closed when it returns. A failure cancels the pipeline before the output is
+// closed, so later stages never mistake a failed input for a complete one
+func stage[T any](p *Pipeline, run func(ctx context.Context, out chan<- T) error) <-chan T {
+	out := make(chan T)
+	p.Go(func(ctx context.Context) error {
+		if err := run(ctx, out); err != nil {
+			p.fail(err)
+		}
+		close(out)
+		return nil
+	})
+
+	return out
+}
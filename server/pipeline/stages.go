@@ -0,0 +1,162 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+)
+
+// Source emits the values produced by fn, emit reports false once the pipeline is cancelled
+func Source[T any](p *Pipeline, fn func(ctx context.Context, emit func(T) bool) error) <-chan T {
+	return stage(p, func(ctx context.Context, out chan<- T) error {
+		return fn(ctx, func(value T) bool { return send(ctx, out, value) })
+	})
+}
+
+func FromSlice[T any](p *Pipeline, values []T) <-chan T {
+	return Source(p, func(_ context.Context, emit func(T) bool) error {
+		for _, value := range values {
+			if !emit(value) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// Map transforms every value, an error of fn fails the pipeline
+func Map[In any, Out any](p *Pipeline, in <-chan In, fn func(In) (Out, error)) <-chan Out {
+	return stage(p, func(ctx context.Context, out chan<- Out) error {
+		return each(ctx, in, func(value In) error {
+			mapped, err := fn(value)
+			if err != nil {
+				return err
+			}
+			send(ctx, out, mapped)
+			return nil
+		})
+	})
+}
+
+// Filter passes the values keep returns true for
+func Filter[T any](p *Pipeline, in <-chan T, keep func(T) bool) <-chan T {
+	return stage(p, func(ctx context.Context, out chan<- T) error {
+		return each(ctx, in, func(value T) error {
+			if keep(value) {
+				send(ctx, out, value)
+			}
+			return nil
+		})
+	})
+}
+
+// Dedupe passes the first value of every key. A later value of a key is passed as well if supersedes reports that
+// it replaces the value seen last, supersedes may be nil
+func Dedupe[T any, K comparable](p *Pipeline, in <-chan T, key func(T) K, supersedes func(seen T, next T) bool) <-chan T {
+	return stage(p, func(ctx context.Context, out chan<- T) error {
+		seen := make(map[K]T)
+		return each(ctx, in, func(value T) error {
+			k := key(value)
+			if previous, ok := seen[k]; ok && (supersedes == nil || !supersedes(previous, value)) {
+				return nil
+			}
+
+			seen[k] = value
+			send(ctx, out, value)
+			return nil
+		})
+	})
+}
+
+// Tap calls fn for every value before passing it on
+func Tap[T any](p *Pipeline, in <-chan T, fn func(T)) <-chan T {
+	return stage(p, func(ctx context.Context, out chan<- T) error {
+		return each(ctx, in, func(value T) error {
+			fn(value)
+			send(ctx, out, value)
+			return nil
+		})
+	})
+}
+
+// OnComplete passes all values and calls fn once the input is closed, fn is not called if the pipeline is
+// cancelled before. The output is closed after fn returned
+func OnComplete[T any](p *Pipeline, in <-chan T, fn func(ctx context.Context) error) <-chan T {
+	return stage(p, func(ctx context.Context, out chan<- T) error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case value, ok := <-in:
+				if !ok {
+					// the input is closed as well if an earlier stage failed
+					if ctx.Err() != nil {
+						return nil
+					}
+					return fn(ctx)
+				}
+				send(ctx, out, value)
+			}
+		}
+	})
+}
+
+// FanIn merges the inputs, the order between inputs is not defined. The output is closed once all inputs are closed
+func FanIn[T any](p *Pipeline, ins ...<-chan T) <-chan T {
+	return stage(p, func(ctx context.Context, out chan<- T) error {
+		var wg sync.WaitGroup
+		for _, in := range ins {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				each(ctx, in, func(value T) error {
+					send(ctx, out, value)
+					return nil
+				})
+			}()
+		}
+
+		wg.Wait()
+		return nil
+	})
+}
+
+// FanOut passes every value to all n outputs, the slowest output sets the pace
+func FanOut[T any](p *Pipeline, in <-chan T, n int) []<-chan T {
+	outs := make([]chan T, n)
+	results := make([]<-chan T, n)
+	for i := range outs {
+		outs[i] = make(chan T)
+		results[i] = outs[i]
+	}
+
+	p.Go(func(ctx context.Context) error {
+		defer func() {
+			for _, out := range outs {
+				close(out)
+			}
+		}()
+
+		return each(ctx, in, func(value T) error {
+			for _, out := range outs {
+				if !send(ctx, out, value) {
+					return nil
+				}
+			}
+			return nil
+		})
+	})
+
+	return results
+}
+
+// Sink consumes the input, an error of fn fails the pipeline
+func Sink[T any](p *Pipeline, in <-chan T, fn func(T) error) {
+	p.Go(func(ctx context.Context) error {
+		return each(ctx, in, fn)
+	})
+}
+
+// Drain consumes the input and discards its values
+func Drain[T any](p *Pipeline, in <-chan T) {
+	Sink(p, in, func(T) error { return nil })
+}
@@ -0,0 +1,272 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// time a stage has to close its output once its input closed or the pipeline ended
+const closeTimeout = time.Second
+
+var errStage = errors.New("stage failed")
+
+// stageCase builds the stage under test on top of in, stages with several outputs return all of them
+type stageCase struct {
+	name  string
+	build func(p *Pipeline, in <-chan int) []<-chan int
+	// outputs for the input 1, 1, 2, 3
+	want [][]int
+}
+
+var stageCases = []stageCase{
+	{
+		name: "Map",
+		build: func(p *Pipeline, in <-chan int) []<-chan int {
+			return []<-chan int{Map(p, in, func(value int) (int, error) { return value * 10, nil })}
+		},
+		want: [][]int{{10, 10, 20, 30}},
+	},
+	{
+		name: "Filter",
+		build: func(p *Pipeline, in <-chan int) []<-chan int {
+			return []<-chan int{Filter(p, in, func(value int) bool { return value%2 == 1 })}
+		},
+		want: [][]int{{1, 1, 3}},
+	},
+	{
+		name: "Dedupe",
+		build: func(p *Pipeline, in <-chan int) []<-chan int {
+			return []<-chan int{Dedupe(p, in, func(value int) int { return value }, nil)}
+		},
+		want: [][]int{{1, 2, 3}},
+	},
+	{
+		name: "Tap",
+		build: func(p *Pipeline, in <-chan int) []<-chan int {
+			return []<-chan int{Tap(p, in, func(int) {})}
+		},
+		want: [][]int{{1, 1, 2, 3}},
+	},
+	{
+		name: "OnComplete",
+		build: func(p *Pipeline, in <-chan int) []<-chan int {
+			return []<-chan int{OnComplete(p, in, func(context.Context) error { return nil })}
+		},
+		want: [][]int{{1, 1, 2, 3}},
+	},
+	{
+		name: "FanIn",
+		build: func(p *Pipeline, in <-chan int) []<-chan int {
+			return []<-chan int{FanIn(p, in, FromSlice(p, []int{4}))}
+		},
+		want: [][]int{{1, 1, 2, 3, 4}},
+	},
+	{
+		name: "FanOut",
+		build: func(p *Pipeline, in <-chan int) []<-chan int {
+			return FanOut(p, in, 2)
+		},
+		want: [][]int{{1, 1, 2, 3}, {1, 1, 2, 3}},
+	},
+}
+
+func TestStagesCloseOnUpstreamClose(t *testing.T) {
+	for _, tc := range stageCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := New(context.Background())
+			outs := tc.build(p, FromSlice(p, []int{1, 1, 2, 3}))
+
+			got := collect(t, outs)
+			if err := waitFor(t, p); err != nil {
+				t.Fatalf("Wait returned %v", err)
+			}
+			for i := range got {
+				// FanIn does not keep the order between its inputs
+				slices.Sort(got[i])
+				if !slices.Equal(got[i], tc.want[i]) {
+					t.Errorf("output %d is %v, want %v", i, got[i], tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestStagesStopOnCancellation(t *testing.T) {
+	for _, tc := range stageCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			p := New(ctx)
+			// never closed, only the cancellation ends the stage
+			in := make(chan int)
+			outs := tc.build(p, in)
+
+			in <- 1
+			cancel()
+
+			collect(t, outs)
+			if err := waitFor(t, p); !errors.Is(err, context.Canceled) {
+				t.Errorf("Wait returned %v, want %v", err, context.Canceled)
+			}
+		})
+	}
+}
+
+func TestStagesForwardUpstreamErrors(t *testing.T) {
+	for _, tc := range stageCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := New(context.Background())
+			in := Source(p, func(_ context.Context, emit func(int) bool) error {
+				emit(1)
+				return errStage
+			})
+			outs := tc.build(p, in)
+
+			collect(t, outs)
+			if err := waitFor(t, p); !errors.Is(err, errStage) {
+				t.Errorf("Wait returned %v, want %v", err, errStage)
+			}
+			if !errors.Is(context.Cause(p.Context()), errStage) {
+				t.Errorf("pipeline context ended with %v, want %v", context.Cause(p.Context()), errStage)
+			}
+		})
+	}
+}
+
+func TestMapFailsPipeline(t *testing.T) {
+	p := New(context.Background())
+	out := Map(p, FromSlice(p, []int{1, 2, 3}), func(value int) (int, error) {
+		if value == 2 {
+			return 0, errStage
+		}
+		return value, nil
+	})
+
+	got := collect(t, []<-chan int{out})
+	if err := waitFor(t, p); !errors.Is(err, errStage) {
+		t.Errorf("Wait returned %v, want %v", err, errStage)
+	}
+	if !slices.Equal(got[0], []int{1}) {
+		t.Errorf("output is %v, want the values before the failure", got[0])
+	}
+}
+
+func TestSinkFailsPipeline(t *testing.T) {
+	p := New(context.Background())
+	Sink(p, FromSlice(p, []int{1, 2, 3}), func(value int) error {
+		if value == 2 {
+			return errStage
+		}
+		return nil
+	})
+
+	if err := waitFor(t, p); !errors.Is(err, errStage) {
+		t.Errorf("Wait returned %v, want %v", err, errStage)
+	}
+}
+
+func TestOnCompleteSkippedAfterFailure(t *testing.T) {
+	p := New(context.Background())
+	in := Source(p, func(_ context.Context, emit func(int) bool) error {
+		return errStage
+	})
+	var called atomic.Bool
+	out := OnComplete(p, in, func(context.Context) error {
+		called.Store(true)
+		return nil
+	})
+
+	collect(t, []<-chan int{out})
+	if err := waitFor(t, p); !errors.Is(err, errStage) {
+		t.Errorf("Wait returned %v, want %v", err, errStage)
+	}
+	if called.Load() {
+		t.Errorf("OnComplete called fn although the input failed")
+	}
+}
+
+func TestOnCompleteFailsPipeline(t *testing.T) {
+	p := New(context.Background())
+	out := OnComplete(p, FromSlice(p, []int{1}), func(context.Context) error { return errStage })
+
+	collect(t, []<-chan int{out})
+	if err := waitFor(t, p); !errors.Is(err, errStage) {
+		t.Errorf("Wait returned %v, want %v", err, errStage)
+	}
+}
+
+func TestDedupeSupersedes(t *testing.T) {
+	type versioned struct {
+		key     string
+		version int
+	}
+
+	p := New(context.Background())
+	in := FromSlice(p, []versioned{{"a", 1}, {"a", 0}, {"a", 2}, {"b", 1}})
+	out := Dedupe(p, in, func(value versioned) string { return value.key }, func(seen versioned, next versioned) bool {
+		return next.version > seen.version
+	})
+
+	var got []versioned
+	Sink(p, out, func(value versioned) error {
+		got = append(got, value)
+		return nil
+	})
+	if err := waitFor(t, p); err != nil {
+		t.Fatalf("Wait returned %v", err)
+	}
+
+	want := []versioned{{"a", 1}, {"a", 2}, {"b", 1}}
+	if !slices.Equal(got, want) {
+		t.Errorf("output is %v, want %v", got, want)
+	}
+}
+
+// collect reads all outputs until they are closed, it fails the test if an output stays open
+func collect(t *testing.T, outs []<-chan int) [][]int {
+	t.Helper()
+
+	got := make([][]int, len(outs))
+	var wg sync.WaitGroup
+	for i, out := range outs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for value := range out {
+				got[i] = append(got[i], value)
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(closeTimeout):
+		t.Fatalf("outputs were not closed within %s", closeTimeout)
+	}
+
+	return got
+}
+
+// waitFor returns the result of Wait, it fails the test if a stage does not return
+func waitFor(t *testing.T, p *Pipeline) error {
+	t.Helper()
+
+	result := make(chan error, 1)
+	go func() { result <- p.Wait() }()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(closeTimeout):
+		t.Fatalf("stages did not return within %s", closeTimeout)
+		return nil
+	}
+}
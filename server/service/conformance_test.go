@@ -0,0 +1,449 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"server/domain"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// the conformance kit checks the contract of InternetProviderAPI for every registered provider against a local stub,
+// which answers with the payloads of the golden corpus:
+//   - published offers have the provider set, are valid and repeated only as often as the responses repeat them
+//   - GetOffersStream returns only after all offers and errors are sent
+//   - cancelling the context stops the adapter, even if nobody reads the error channel
+//   - no goroutine outlives the call
+
+const (
+	// upper bound of a run against the scripted stub
+	conformanceTimeout = 10 * time.Second
+	// time an adapter has to return once its context is done
+	conformanceReturnGrace = 2 * time.Second
+	// time goroutines of a finished run have to exit
+	conformanceSettle = 2 * time.Second
+	// cancelled runs are cancelled while their requests are in flight
+	conformanceCancelAfter = 100 * time.Millisecond
+)
+
+// conformanceAddress is the address all conformance runs look up, the scripts do not depend on it
+var conformanceAddress = domain.Address{
+	Street:      "Teststraße",
+	HouseNumber: "1",
+	City:        "München",
+	ZipCode:     "80331",
+}
+
+func TestConformance(t *testing.T) {
+	scripts := conformanceScripts(t, goldenDir)
+
+	for _, provider := range registeredProviders {
+		t.Run(provider.GetProviderName(), func(t *testing.T) {
+			script, ok := scripts[provider.GetProviderName()]
+			if !ok {
+				t.Fatalf("no conformance script for provider %s", provider.GetProviderName())
+			}
+
+			stub := newConformanceStub(script.handler)
+			defer stub.close()
+			provider := provider.withTransport(stub)
+
+			for _, check := range []struct {
+				name string
+				run  func(*testing.T, InternetProviderAPI, conformanceScript, *conformanceStub)
+			}{
+				{"offers", checkConformanceOffers},
+				{"cancellation", checkConformanceCancellation},
+				{"blocked-errors", checkConformanceBlockedErrors},
+			} {
+				t.Run(check.name, func(t *testing.T) {
+					before := goroutineStacks()
+					check.run(t, provider, script, stub)
+
+					stub.transport.CloseIdleConnections()
+					if leaked := leakedGoroutines(before); len(leaked) > 0 {
+						t.Errorf("%d goroutines leaked:\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+					}
+				})
+			}
+		})
+	}
+}
+
+// checkConformanceOffers runs a complete lookup and checks the published offers and errors
+func checkConformanceOffers(t *testing.T, provider InternetProviderAPI, script conformanceScript, stub *conformanceStub) {
+	stub.mode(STUB_SCRIPTED)
+
+	ctx, cancel := context.WithTimeout(context.Background(), conformanceTimeout)
+	defer cancel()
+
+	publisher := &conformancePublisher{}
+	errChannel := make(chan error)
+	lateErrors := make(chan int)
+	go func() {
+		late := 0
+		for range errChannel {
+			if publisher.hasReturned() {
+				late++
+			}
+		}
+		lateErrors <- late
+	}()
+
+	if !runWithDeadline(func() {
+		provider.GetOffersStream(ctx, conformanceAddress, domain.OfferFilter{}, publisher, errChannel)
+	}, conformanceTimeout+conformanceReturnGrace) {
+		t.Fatalf("GetOffersStream did not return within %s", conformanceTimeout+conformanceReturnGrace)
+	}
+	publisher.markReturned()
+	// give goroutines which are still publishing the chance to show up
+	time.Sleep(100 * time.Millisecond)
+	close(errChannel)
+
+	if late := <-lateErrors; late > 0 {
+		t.Errorf("%d errors were sent after GetOffersStream returned", late)
+	}
+	if late := publisher.latePublications(); late > 0 {
+		t.Errorf("%d offers were published after GetOffersStream returned", late)
+	}
+
+	offers := publisher.published()
+	if len(offers) == 0 {
+		t.Errorf("no offers were published for the scripted responses")
+	}
+	seen := make(map[string]bool)
+	repeated := 0
+	for _, offer := range offers {
+		if offer.Provider != provider.GetProviderName() {
+			t.Errorf("offer %q has provider %q instead of %q", offer.ProductName, offer.Provider, provider.GetProviderName())
+		}
+		if err := offer.Validate(); err != nil {
+			t.Errorf("offer %q is invalid: %v", offer.ProductName, err)
+		}
+
+		offer.GenerateHash()
+		if seen[offer.HelperOfferHash] {
+			repeated++
+		}
+		seen[offer.HelperOfferHash] = true
+	}
+	// adapters pass repeated rows on, the lookup pipeline drops them by hash
+	if repeated != script.repeated {
+		t.Errorf("%d offers were published repeatedly, the scripted responses repeat %d", repeated, script.repeated)
+	}
+}
+
+// checkConformanceCancellation cancels the lookup while all requests hang and expects the adapter to return
+func checkConformanceCancellation(t *testing.T, provider InternetProviderAPI, _ conformanceScript, stub *conformanceStub) {
+	stub.mode(STUB_HANGING)
+	defer stub.mode(STUB_SCRIPTED)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errChannel := make(chan error)
+	go func() {
+		for range errChannel {
+		}
+	}()
+	defer close(errChannel)
+
+	time.AfterFunc(conformanceCancelAfter, cancel)
+	if !runWithDeadline(func() {
+		provider.GetOffersStream(ctx, conformanceAddress, domain.OfferFilter{}, &conformancePublisher{}, errChannel)
+	}, conformanceCancelAfter+conformanceReturnGrace) {
+		t.Errorf("GetOffersStream did not return within %s after the context was cancelled", conformanceReturnGrace)
+	}
+}
+
+// checkConformanceBlockedErrors lets every request fail while nobody reads the error channel, once the context ends
+// the adapter has to return instead of blocking on the channel
+func checkConformanceBlockedErrors(t *testing.T, provider InternetProviderAPI, _ conformanceScript, stub *conformanceStub) {
+	stub.mode(STUB_FAILING)
+	defer stub.mode(STUB_SCRIPTED)
+
+	ctx, cancel := context.WithTimeout(context.Background(), conformanceCancelAfter)
+	defer cancel()
+
+	// never read
+	errChannel := make(chan error)
+	if !runWithDeadline(func() {
+		provider.GetOffersStream(ctx, conformanceAddress, domain.OfferFilter{}, &conformancePublisher{}, errChannel)
+	}, conformanceCancelAfter+conformanceReturnGrace) {
+		t.Errorf("GetOffersStream blocked on the error channel after the context ended")
+	}
+}
+
+type conformanceScript struct {
+	handler http.Handler
+	// offers the scripted responses repeat
+	repeated int
+}
+
+// conformanceScripts returns the stub script of every provider, they answer with the payloads of the golden corpus in dir
+func conformanceScripts(t *testing.T, dir string) map[string]conformanceScript {
+	fixture := func(provider string, name string) []byte {
+		data, err := os.ReadFile(filepath.Join(dir, provider, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	byteMeProducts := fixture("byteme", "products.csv")
+	pingPerfectProducts := fixture("pingperfect", "products.json")
+	webWunderDSL := fixture("webwunder", "dsl.xml")
+	webWunderNoOffers := fixture("webwunder", "no-offers.xml")
+	servusSpeedDetails := map[string][]byte{
+		"dsl":   fixture("servusspeed", "product-details-dsl.json"),
+		"fiber": fixture("servusspeed", "product-details-fiber.json"),
+	}
+	verbynDichPages := fixture("verbyndich", "pages.jsonl")
+
+	return map[string]conformanceScript{
+		// the fiber product is listed twice
+		byteMeProvider.GetProviderName():      {handler: staticScript("text/csv", byteMeProducts), repeated: 1},
+		pingPerfectProvider.GetProviderName(): {handler: staticScript("application/json", pingPerfectProducts)},
+		// only DSL has offers, otherwise every connection type would return the same products
+		(&WebWunderApi{}).GetProviderName(): {handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "text/xml")
+			if bytes.Contains(body, []byte(">DSL<")) {
+				w.Write(webWunderDSL)
+				return
+			}
+			w.Write(webWunderNoOffers)
+		})},
+		(&ServusSpeedApi{}).GetProviderName(): {handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if strings.HasSuffix(r.URL.Path, "/available-products") {
+				fmt.Fprint(w, `{"availableProducts": ["dsl", "fiber"]}`)
+				return
+			}
+			details, ok := servusSpeedDetails[filepath.Base(r.URL.Path)]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(details)
+		})},
+		(&VerbyndichAPI{}).GetProviderName(): {handler: pagedScript(verbynDichPages)},
+	}
+}
+
+func staticScript(contentType string, payload []byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Write(payload)
+	})
+}
+
+// pagedScript answers the page query parameter with the line of the payload, pages after the last line are invalid
+func pagedScript(payload []byte) http.Handler {
+	var pages [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(payload))
+	for scanner.Scan() {
+		pages = append(pages, bytes.Clone(scanner.Bytes()))
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		page, err := strconv.Atoi(r.URL.Query().Get("page"))
+		if err != nil || page < 0 {
+			http.Error(w, "invalid page", http.StatusBadRequest)
+			return
+		}
+		if page >= len(pages) {
+			fmt.Fprint(w, `{"product": "", "description": "", "last": true, "valid": false}`)
+			return
+		}
+		w.Write(pages[page])
+	})
+}
+
+// runWithDeadline reports whether fn returned in time, fn keeps running otherwise
+func runWithDeadline(fn func(), deadline time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(deadline):
+		return false
+	}
+}
+
+// conformancePublisher records the offers and the publications after GetOffersStream returned
+type conformancePublisher struct {
+	mu       sync.Mutex
+	offers   []domain.Offer
+	returned bool
+	late     int
+}
+
+func (p *conformancePublisher) Publish(offer domain.Offer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.returned {
+		p.late++
+		return
+	}
+	p.offers = append(p.offers, offer)
+}
+
+func (p *conformancePublisher) markReturned() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.returned = true
+}
+
+func (p *conformancePublisher) hasReturned() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.returned
+}
+
+func (p *conformancePublisher) latePublications() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.late
+}
+
+func (p *conformancePublisher) published() []domain.Offer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]domain.Offer{}, p.offers...)
+}
+
+type stubMode int
+
+const (
+	// requests are answered by the script
+	STUB_SCRIPTED stubMode = iota
+	// requests are never answered until the client gives up
+	STUB_HANGING
+	// requests fail with status 500
+	STUB_FAILING
+)
+
+// conformanceStub is a local HTTP server, it is the transport of the adapter under test
+type conformanceStub struct {
+	server    *httptest.Server
+	transport *http.Transport
+
+	mu      sync.Mutex
+	current stubMode
+	// closed when the stub shuts down, releases hanging requests
+	closing chan struct{}
+}
+
+func newConformanceStub(script http.Handler) *conformanceStub {
+	stub := &conformanceStub{closing: make(chan struct{})}
+	stub.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch stub.currentMode() {
+		case STUB_HANGING:
+			// the server only notices a closed connection once the body is read
+			io.Copy(io.Discard, r.Body)
+			select {
+			case <-r.Context().Done():
+			case <-stub.closing:
+			}
+		case STUB_FAILING:
+			http.Error(w, "scripted failure", http.StatusInternalServerError)
+		default:
+			script.ServeHTTP(w, r)
+		}
+	}))
+
+	stub.transport = &http.Transport{}
+
+	return stub
+}
+
+func (s *conformanceStub) mode(mode stubMode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.current = mode
+}
+
+func (s *conformanceStub) currentMode() stubMode {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.current
+}
+
+func (s *conformanceStub) close() {
+	close(s.closing)
+	s.transport.CloseIdleConnections()
+	s.server.Close()
+}
+
+// RoundTrip sends provider requests to the stub as plain HTTP, the original host is kept in the X-Forwarded-Host header
+func (s *conformanceStub) RoundTrip(req *http.Request) (*http.Response, error) {
+	stubReq := req.Clone(req.Context())
+	stubReq.Header.Set("X-Forwarded-Host", req.URL.Hostname())
+	stubReq.URL.Scheme = "http"
+	stubReq.URL.Host = s.server.Listener.Addr().String()
+
+	return s.transport.RoundTrip(stubReq)
+}
+
+// goroutineStacks returns the stacks of all goroutines by their header, e.g. "goroutine 12 [select]:"
+func goroutineStacks() map[string]string {
+	buffer := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buffer, true)
+		if n < len(buffer) {
+			buffer = buffer[:n]
+			break
+		}
+		buffer = make([]byte, 2*len(buffer))
+	}
+
+	stacks := make(map[string]string)
+	for _, stack := range bytes.Split(buffer, []byte("\n\n")) {
+		header, _, _ := strings.Cut(string(stack), "\n")
+		// the state in brackets changes, the id identifies the goroutine
+		id, _, _ := strings.Cut(header, " [")
+		stacks[id] = string(stack)
+	}
+
+	return stacks
+}
+
+// leakedGoroutines waits for goroutines started after before to exit and returns the stacks of those which did not
+func leakedGoroutines(before map[string]string) []string {
+	deadline := time.Now().Add(conformanceSettle)
+	for {
+		var leaked []string
+		for id, stack := range goroutineStacks() {
+			if _, ok := before[id]; !ok && !strings.Contains(stack, "service.goroutineStacks") {
+				leaked = append(leaked, stack)
+			}
+		}
+		if len(leaked) == 0 || time.Now().After(deadline) {
+			return leaked
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
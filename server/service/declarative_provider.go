@@ -0,0 +1,731 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"server/domain"
+	"server/utils"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// providerDefinitions holds the definitions of all declarative providers, adding a provider means adding a file here
+//
+//go:embed providers/*.yaml
+var providerDefinitions embed.FS
+
+var (
+	byteMeProvider      = mustLoadProvider("byteme.yaml")
+	pingPerfectProvider = mustLoadProvider("pingperfect.yaml")
+)
+
+type AuthScheme string
+
+const (
+	HEADER_KEY_AUTH     AuthScheme = "header_key"
+	QUERY_KEY_AUTH      AuthScheme = "query_key"
+	BASIC_AUTH          AuthScheme = "basic"
+	HMAC_SIGNATURE_AUTH AuthScheme = "hmac_signature"
+)
+
+type ResponseFormat string
+
+const (
+	JSON_FORMAT ResponseFormat = "json"
+	CSV_FORMAT  ResponseFormat = "csv"
+	XML_FORMAT  ResponseFormat = "xml"
+)
+
+type PaginationStyle string
+
+const (
+	// one request returns all offers, they are published while the response is decoded
+	SINGLE_CALL PaginationStyle = "single"
+	// pages are requested one after another until a page is marked as the last one or has no records
+	PAGE_UNTIL_LAST PaginationStyle = "page_until_last"
+	// a list request returns the IDs of the products, every product is requested on its own
+	LIST_THEN_DETAIL PaginationStyle = "list_then_detail"
+)
+
+// ProviderDefinition describes a provider API declaratively, it is read from YAML or JSON
+type ProviderDefinition struct {
+	Name         string                 `yaml:"name"`
+	Request      RequestDefinition      `yaml:"request"`
+	Auth         AuthDefinition         `yaml:"auth"`
+	Response     ResponseDefinition     `yaml:"response"`
+	Fields       []FieldMapping         `yaml:"fields"`
+	Voucher      *VoucherMapping        `yaml:"voucher"`
+	Pagination   PaginationDefinition   `yaml:"pagination"`
+	Capabilities CapabilitiesDefinition `yaml:"capabilities"`
+}
+
+// RequestDefinition is templated with text/template, see requestTemplateData for the available values
+type RequestDefinition struct {
+	Method  string            `yaml:"method"`
+	URL     string            `yaml:"url"`
+	Query   map[string]string `yaml:"query"`
+	Headers map[string]string `yaml:"headers"`
+	Body    string            `yaml:"body"`
+	// send a duplicate if the request is slower than usual, see withHedging. Signed requests are never hedged
+	Hedge bool `yaml:"hedge"`
+	// the request only reads, required to hedge methods which are not idempotent
+	ReadOnly bool `yaml:"readOnly"`
+	// status codes the provider documents to answer addresses it does not know with. Only documented statuses may be
+	// listed, a rejected address is cached for a day and other client errors are as often caused by our request
+	AddressRejectedStatus []int `yaml:"addressRejectedStatus"`
+}
+
+type AuthDefinition struct {
+	Scheme AuthScheme `yaml:"scheme"`
+	// header or query parameter of a key
+	Name string `yaml:"name"`
+	// credential of the key, the password or the signature secret, this is the one which is rotated
+	Credential string `yaml:"credential"`
+	// credential of the username for basic auth or the client id for signatures
+	Identity string `yaml:"identity"`
+}
+
+type ResponseDefinition struct {
+	Format ResponseFormat `yaml:"format"`
+	// dotted path of the record array in JSON, the record element in XML. Empty for a top level JSON array,
+	// for list then detail it is the path of the product in the detail response
+	Records string `yaml:"records"`
+	// name of the response in schema drift reports
+	Name string `yaml:"name"`
+	// namespace of the extra properties which keep unmapped fields
+	ExtraPrefix string `yaml:"extraPrefix"`
+}
+
+// FieldMapping maps a field of a record onto a field of domain.Offer
+type FieldMapping struct {
+	// dotted path in JSON and XML records, the column in CSV
+	Source string `yaml:"source"`
+	// JSON name of the domain.Offer field
+	Target string `yaml:"target"`
+	// kind of the source for schema drift reports, derived from the target if empty
+	Kind fieldKind `yaml:"kind"`
+	// a missing field is reported as schema drift
+	Required bool `yaml:"required"`
+	// records without a value are skipped and reported as error
+	RejectEmpty bool `yaml:"rejectEmpty"`
+	// values which are true for a boolean target, all others are false. If empty true/yes/1 and false/no/0 are accepted
+	TrueValues []string `yaml:"trueValues"`
+}
+
+// VoucherMapping maps a voucher given as type and value, absolute vouchers are spread over the contract duration
+type VoucherMapping struct {
+	Type       string `yaml:"type"`
+	Value      string `yaml:"value"`
+	Percentage string `yaml:"percentage"`
+	Absolute   string `yaml:"absolute"`
+}
+
+type PaginationDefinition struct {
+	Style PaginationStyle `yaml:"style"`
+	// page until last
+	FirstPage int    `yaml:"firstPage"`
+	LastField string `yaml:"lastField"`
+	MaxPages  int    `yaml:"maxPages"`
+	// list then detail
+	List           *RequestDefinition `yaml:"list"`
+	ListRecords    string             `yaml:"listRecords"`
+	IdField        string             `yaml:"idField"`
+	MaxConcurrency int                `yaml:"maxConcurrency"`
+}
+
+type CapabilitiesDefinition struct {
+	ConnectionTypes []domain.ConnectionType `yaml:"connectionTypes"`
+	Installation    bool                    `yaml:"installation"`
+	// connection types the provider has offers of, the provider is skipped for other types. Unset if it may have any
+	OfferedConnectionTypes []domain.ConnectionType `yaml:"offeredConnectionTypes"`
+}
+
+// requestTemplateData is available in all request templates, e.g. {{.Address.ZipCode}} or {{.Page}}
+type requestTemplateData struct {
+	Address domain.Address
+	Filter  domain.OfferFilter
+	Page    int
+	ID      string
+}
+
+var requestTemplateFuncs = template.FuncMap{
+	// json encodes a value, e.g. {"city":{{json .Address.City}}}
+	"json": func(value any) (string, error) {
+		encoded, err := json.Marshal(value)
+		return string(encoded), err
+	},
+	// wantsConnectionType reports whether the upstream filter restricts the query to the connection type
+	"wantsConnectionType": func(filter domain.OfferFilter, connectionType string) bool {
+		return filter.ConnectionType != nil && *filter.ConnectionType == domain.ConnectionType(connectionType)
+	},
+}
+
+const (
+	defaultMaxPages       = 100
+	defaultMaxConcurrency = 5
+)
+
+// DeclarativeProvider is an InternetProviderAPI driven by a ProviderDefinition
+type DeclarativeProvider struct {
+	definition ProviderDefinition
+	auth       providerAuth
+	clock      *serverClock
+	schema     responseSchema
+	mappers    []fieldMapper
+	// sends the requests upstream, nil for http.DefaultTransport
+	transport http.RoundTripper
+}
+
+// mustLoadProvider loads an embedded definition, definitions are part of the binary so an invalid one is a bug
+func mustLoadProvider(name string) *DeclarativeProvider {
+	data, err := providerDefinitions.ReadFile(path.Join("providers", name))
+	if err != nil {
+		panic(err)
+	}
+	provider, err := NewDeclarativeProvider(data)
+	if err != nil {
+		panic(fmt.Sprintf("provider definition %s: %v", name, err))
+	}
+
+	return provider
+}
+
+// NewDeclarativeProvider parses a YAML or JSON definition, its credentials are registered and loaded by utils.LoadConfig
+func NewDeclarativeProvider(data []byte) (*DeclarativeProvider, error) {
+	var definition ProviderDefinition
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&definition); err != nil {
+		return nil, fmt.Errorf("failed to parse provider definition: %w", err)
+	}
+	if err := definition.validate(); err != nil {
+		return nil, err
+	}
+
+	provider := &DeclarativeProvider{definition: definition}
+	credential := utils.NewCredential(definition.Auth.Credential, true)
+	switch definition.Auth.Scheme {
+	case HEADER_KEY_AUTH:
+		provider.auth = headerKeyAuth{header: definition.Auth.Name, credential: credential}
+	case QUERY_KEY_AUTH:
+		provider.auth = queryKeyAuth{param: definition.Auth.Name, credential: credential}
+	case BASIC_AUTH:
+		provider.auth = basicAuth{username: utils.NewCredential(definition.Auth.Identity, true), password: credential}
+	case HMAC_SIGNATURE_AUTH:
+		provider.clock = &serverClock{provider: definition.Name}
+		provider.auth = signatureAuth{clientId: utils.NewCredential(definition.Auth.Identity, true), secret: credential, clock: provider.clock}
+	}
+
+	var err error
+	if provider.mappers, err = newFieldMappers(definition.Fields); err != nil {
+		return nil, err
+	}
+	provider.schema = definition.responseSchema()
+
+	return provider, nil
+}
+
+func (d ProviderDefinition) validate() error {
+	var errs []error
+	if d.Name == "" {
+		errs = append(errs, errors.New("name is required"))
+	}
+
+	requests := []RequestDefinition{d.Request}
+	if d.Pagination.List != nil {
+		requests = append(requests, *d.Pagination.List)
+	}
+	for _, request := range requests {
+		if request.Method == "" || request.URL == "" {
+			errs = append(errs, errors.New("request method and url are required"))
+		}
+		for _, text := range request.templates() {
+			if _, err := template.New("").Funcs(requestTemplateFuncs).Parse(text); err != nil {
+				errs = append(errs, fmt.Errorf("invalid request template %q: %w", text, err))
+			}
+		}
+		if request.Hedge && !request.ReadOnly && request.Method != http.MethodGet && request.Method != http.MethodHead {
+			errs = append(errs, fmt.Errorf("request %s %s is hedged but not read only", request.Method, request.URL))
+		}
+		// the duplicate would carry the same timestamp and signature, a provider may reject it as replayed
+		if request.Hedge && d.Auth.Scheme == HMAC_SIGNATURE_AUTH {
+			errs = append(errs, fmt.Errorf("request %s %s is signed and can not be hedged", request.Method, request.URL))
+		}
+		for _, status := range request.AddressRejectedStatus {
+			// rejected credentials must never be mistaken for a rejected address
+			if !isAddressRejectedStatus(status) {
+				errs = append(errs, fmt.Errorf("request %s %s rejects addresses with status %d, only client errors other than auth rejections, timeouts and rate limits are allowed", request.Method, request.URL, status))
+			}
+		}
+	}
+
+	switch d.Auth.Scheme {
+	case HEADER_KEY_AUTH, QUERY_KEY_AUTH:
+		if d.Auth.Name == "" {
+			errs = append(errs, fmt.Errorf("auth scheme %s requires a name", d.Auth.Scheme))
+		}
+	case BASIC_AUTH, HMAC_SIGNATURE_AUTH:
+		if d.Auth.Identity == "" {
+			errs = append(errs, fmt.Errorf("auth scheme %s requires an identity", d.Auth.Scheme))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown auth scheme %q", d.Auth.Scheme))
+	}
+	if d.Auth.Credential == "" {
+		errs = append(errs, errors.New("auth credential is required"))
+	}
+
+	switch d.Response.Format {
+	case JSON_FORMAT, CSV_FORMAT:
+	case XML_FORMAT:
+		if d.Response.Records == "" {
+			errs = append(errs, errors.New("xml responses require the records element"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown response format %q", d.Response.Format))
+	}
+
+	switch d.Pagination.Style {
+	case SINGLE_CALL, "":
+	case PAGE_UNTIL_LAST:
+		if d.Response.Format != JSON_FORMAT || d.Pagination.LastField == "" {
+			errs = append(errs, errors.New("page until last requires a json response and a last field"))
+		}
+	case LIST_THEN_DETAIL:
+		if d.Response.Format != JSON_FORMAT || d.Pagination.List == nil || d.Pagination.IdField == "" {
+			errs = append(errs, errors.New("list then detail requires a json response, a list request and an id field"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown pagination style %q", d.Pagination.Style))
+	}
+
+	if len(d.Fields) == 0 {
+		errs = append(errs, errors.New("at least one field mapping is required"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// templates returns all templated parts of the request
+func (r RequestDefinition) templates() []string {
+	templates := []string{r.URL, r.Body}
+	for _, value := range r.Query {
+		templates = append(templates, value)
+	}
+	for _, value := range r.Headers {
+		templates = append(templates, value)
+	}
+
+	return templates
+}
+
+// responseSchema derives the expected fields from the mappings, parents of nested fields are objects
+func (d ProviderDefinition) responseSchema() responseSchema {
+	schema := make(responseSchema)
+	add := func(source string, kind fieldKind, required bool) {
+		schema[source] = schemaField{Kind: kind, Required: schema[source].Required || required}
+		if d.Response.Format == CSV_FORMAT {
+			return
+		}
+		for parent, _, ok := cutLast(source); ok; parent, _, ok = cutLast(parent) {
+			schema[parent] = schemaField{Kind: OBJECT_FIELD, Required: schema[parent].Required || required}
+		}
+	}
+
+	for _, field := range d.Fields {
+		add(field.Source, field.schemaKind(), field.Required || field.RejectEmpty)
+	}
+	if d.Voucher != nil {
+		add(d.Voucher.Type, STRING_FIELD, false)
+		add(d.Voucher.Value, NUMBER_FIELD, false)
+	}
+
+	return schema
+}
+
+func cutLast(source string) (string, string, bool) {
+	index := strings.LastIndex(source, ".")
+	if index < 0 {
+		return "", source, false
+	}
+
+	return source[:index], source[index+1:], true
+}
+
+func (p *DeclarativeProvider) withTransport(transport http.RoundTripper) InternetProviderAPI {
+	clone := *p
+	clone.transport = transport
+	return &clone
+}
+
+func (p *DeclarativeProvider) GetProviderName() string {
+	return p.definition.Name
+}
+
+func (p *DeclarativeProvider) GetFilterCapabilities() FilterCapabilities {
+	return FilterCapabilities{
+		ConnectionTypes:        p.definition.Capabilities.ConnectionTypes,
+		Installation:           p.definition.Capabilities.Installation,
+		OfferedConnectionTypes: p.definition.Capabilities.OfferedConnectionTypes,
+	}
+}
+
+func (p *DeclarativeProvider) GetOffersStream(ctx context.Context, address domain.Address, filter domain.OfferFilter, offersChannel OfferPublisher, errChannel chan<- error) {
+	data := requestTemplateData{Address: address, Filter: filter}
+
+	var err error
+	switch p.definition.Pagination.Style {
+	case PAGE_UNTIL_LAST:
+		err = p.fetchPages(ctx, data, offersChannel, errChannel)
+	case LIST_THEN_DETAIL:
+		err = p.fetchListThenDetails(ctx, data, offersChannel, errChannel)
+	default:
+		err = p.fetchSingle(ctx, data, offersChannel, errChannel)
+	}
+	if kind := providerErrorKind(err); kind != "" {
+		providerErrorsCounter.Inc(p.GetProviderName() + ":" + string(kind))
+	}
+	if err != nil {
+		select {
+		case <-ctx.Done():
+		case errChannel <- err:
+		}
+	}
+}
+
+// fetchSingle streams the records of a single response, only establishing the response is retried as offers are
+// published while the body is decoded
+func (p *DeclarativeProvider) fetchSingle(ctx context.Context, data requestTemplateData, offersChannel OfferPublisher, errChannel chan<- error) error {
+	resp, err := utils.RetryWrapper(ctx, func() (*http.Response, error) {
+		return p.sendRequest(ctx, p.definition.Request, data)
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := p.streamRecords(ctx, resp.Body, offersChannel, errChannel); err != nil {
+		return fmt.Errorf("%s: failed to decode %s response: %w", p.GetProviderName(), p.definition.Response.Format, err)
+	}
+
+	return nil
+}
+
+// fetchPages requests one page after another, every page is retried on its own
+func (p *DeclarativeProvider) fetchPages(ctx context.Context, data requestTemplateData, offersChannel OfferPublisher, errChannel chan<- error) error {
+	maxPages := p.definition.Pagination.MaxPages
+	if maxPages <= 0 {
+		maxPages = defaultMaxPages
+	}
+
+	schemaChecker := newSchemaDriftChecker(ctx, p.GetProviderName(), p.responseName(), p.schema)
+	defer schemaChecker.report()
+
+	for page := p.definition.Pagination.FirstPage; page < p.definition.Pagination.FirstPage+maxPages; page++ {
+		data.Page = page
+		body, err := p.fetchBody(ctx, p.definition.Request, data)
+		if err != nil {
+			return err
+		}
+
+		document, err := decodeJSONDocument(body)
+		if err != nil {
+			return fmt.Errorf("%s: failed to decode page %d: %w", p.GetProviderName(), page, err)
+		}
+		records, err := jsonRecordsAt(document, p.definition.Response.Records)
+		if err != nil {
+			return fmt.Errorf("%s: page %d: %w", p.GetProviderName(), page, err)
+		}
+		for _, record := range records {
+			if !p.publishRecord(ctx, record, schemaChecker, offersChannel, errChannel) {
+				return nil
+			}
+		}
+
+		last, _ := jsonValueAt(document, p.definition.Pagination.LastField)
+		if isLast, _ := last.(bool); isLast || len(records) == 0 {
+			return nil
+		}
+	}
+
+	log.WithField("provider", p.GetProviderName()).Warn("Stopped fetching pages, the maximum number of pages was reached")
+	return nil
+}
+
+// fetchListThenDetails requests the product list and then the details of every product with bounded concurrency
+func (p *DeclarativeProvider) fetchListThenDetails(ctx context.Context, data requestTemplateData, offersChannel OfferPublisher, errChannel chan<- error) error {
+	body, err := p.fetchBody(ctx, *p.definition.Pagination.List, data)
+	if err != nil {
+		return err
+	}
+	document, err := decodeJSONDocument(body)
+	if err != nil {
+		return fmt.Errorf("%s: failed to decode product list: %w", p.GetProviderName(), err)
+	}
+	items, err := jsonRecordsAt(document, p.definition.Pagination.ListRecords)
+	if err != nil {
+		return fmt.Errorf("%s: product list: %w", p.GetProviderName(), err)
+	}
+
+	maxConcurrency := p.definition.Pagination.MaxConcurrency
+	if maxConcurrency <= 0 {
+		maxConcurrency = defaultMaxConcurrency
+	}
+	slots := make(chan struct{}, maxConcurrency)
+
+	schemaChecker := newSchemaDriftChecker(ctx, p.GetProviderName(), p.responseName(), p.schema)
+	defer schemaChecker.report()
+
+	var wg sync.WaitGroup
+	for _, item := range items {
+		id, ok := item.values[p.definition.Pagination.IdField]
+		if !ok || id == "" {
+			p.reportError(ctx, errChannel, fmt.Errorf("%s: product without %s in list", p.GetProviderName(), p.definition.Pagination.IdField))
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return nil
+		case slots <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(data requestTemplateData) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			body, err := p.fetchBody(ctx, p.definition.Request, data)
+			if err != nil {
+				p.reportError(ctx, errChannel, fmt.Errorf("%s: product %s: %w", p.GetProviderName(), data.ID, err))
+				return
+			}
+			document, err := decodeJSONDocument(body)
+			if err != nil {
+				p.reportError(ctx, errChannel, fmt.Errorf("%s: failed to decode product %s: %w", p.GetProviderName(), data.ID, err))
+				return
+			}
+			value, ok := jsonValueAt(document, p.definition.Response.Records)
+			if !ok {
+				p.reportError(ctx, errChannel, fmt.Errorf("%s: product %s has no %s", p.GetProviderName(), data.ID, p.definition.Response.Records))
+				return
+			}
+			p.publishRecord(ctx, newJSONRecord(value), schemaChecker, offersChannel, errChannel)
+		}(requestTemplateData{Address: data.Address, Filter: data.Filter, ID: id})
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// fetchBody sends the request with retries and reads the whole response
+func (p *DeclarativeProvider) fetchBody(ctx context.Context, request RequestDefinition, data requestTemplateData) ([]byte, error) {
+	return utils.RetryWrapper(ctx, func() ([]byte, error) {
+		resp, err := p.sendRequest(ctx, request, data)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		return io.ReadAll(resp.Body)
+	})
+}
+
+// sendRequest sends a single authenticated request and checks the response status.
+// Rejected signatures are returned as *ProviderError and only repeated once if the response showed that our clock is off
+func (p *DeclarativeProvider) sendRequest(ctx context.Context, request RequestDefinition, data requestTemplateData) (*http.Response, error) {
+	client := newProviderClient(p.GetProviderName(), p.transport)
+	// recorded Date headers are outdated, they must not move the clock
+	if p.clock != nil && utils.Cfg.ProviderTraffic.Mode != TRAFFIC_REPLAY {
+		client.Transport = p.clock.observing(client.Transport)
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := p.newRequest(ctx, request, data)
+		if err != nil {
+			return nil, utils.NonRetryable(fmt.Errorf("%s: failed to create request: %w", p.GetProviderName(), err))
+		}
+
+		var offset time.Duration
+		if p.clock != nil {
+			offset = p.clock.Offset()
+		}
+		resp, err := doAuthenticated(client, req, p.auth)
+		if err != nil {
+			return nil, err
+		}
+
+		if p.clock != nil && isAuthRejected(resp.StatusCode) {
+			body := readErrorBody(resp)
+			resp.Body.Close()
+			// the rejection corrected the clock offset, the signature with the new timestamp may be accepted
+			if attempt == 0 && (p.clock.Offset()-offset).Abs() >= minClockOffset {
+				continue
+			}
+			return nil, p.signatureRejected(resp.StatusCode, body)
+		}
+
+		if slices.Contains(request.AddressRejectedStatus, resp.StatusCode) {
+			defer resp.Body.Close()
+			return nil, &ProviderError{
+				Provider: p.GetProviderName(),
+				Kind:     ADDRESS_REJECTED,
+				Code:     strconv.Itoa(resp.StatusCode),
+				Message:  readErrorBody(resp),
+			}
+		}
+		if resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			return nil, fmt.Errorf("%s: received non-200 response: %d with body %s", p.GetProviderName(), resp.StatusCode, readErrorBody(resp))
+		}
+
+		if p.clock != nil {
+			ProviderHealthInstance.SetHealthy(p.GetProviderName(), SIGNATURE_SIGNAL)
+		}
+		return resp, nil
+	}
+}
+
+// newRequest renders the templates of the request, credentials are added per attempt by the auth scheme
+func (p *DeclarativeProvider) newRequest(ctx context.Context, request RequestDefinition, data requestTemplateData) (*http.Request, error) {
+	rawURL, err := renderRequestTemplate(request.URL, data)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if len(request.Query) > 0 {
+		q := u.Query()
+		for name, value := range request.Query {
+			rendered, err := renderRequestTemplate(value, data)
+			if err != nil {
+				return nil, err
+			}
+			q.Set(name, rendered)
+		}
+		u.RawQuery = q.Encode()
+	}
+
+	var body io.Reader
+	if request.Body != "" {
+		rendered, err := renderRequestTemplate(request.Body, data)
+		if err != nil {
+			return nil, err
+		}
+		body = strings.NewReader(strings.TrimSpace(rendered))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, request.Method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range request.Headers {
+		rendered, err := renderRequestTemplate(value, data)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(name, rendered)
+	}
+	if request.Hedge {
+		req = withHedging(req, p.GetProviderName()+":"+p.responseName(), request.ReadOnly)
+	}
+
+	return req, nil
+}
+
+func renderRequestTemplate(text string, data requestTemplateData) (string, error) {
+	tmpl, err := template.New("").Funcs(requestTemplateFuncs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", err
+	}
+
+	return rendered.String(), nil
+}
+
+// signatureRejected raises the signature health signal, repeating the request with the same secret and clock does not help
+func (p *DeclarativeProvider) signatureRejected(statusCode int, body string) error {
+	offset := p.clock.Offset().Round(time.Second)
+	message := fmt.Sprintf("signature rejected with status %d, clock offset %s, check client id and signature secret", statusCode, offset)
+	if body != "" {
+		message += ": " + body
+	}
+	ProviderHealthInstance.SetDegraded(p.GetProviderName(), SIGNATURE_SIGNAL, message)
+	log.WithFields(log.Fields{
+		"provider":    p.GetProviderName(),
+		"status":      statusCode,
+		"clockOffset": offset.String(),
+	}).Error("Provider rejected the request signature")
+
+	return &ProviderError{
+		Provider: p.GetProviderName(),
+		Kind:     CLIENT_FAULT,
+		Code:     "SIGNATURE_REJECTED",
+		Message:  message,
+	}
+}
+
+func (p *DeclarativeProvider) responseName() string {
+	if p.definition.Response.Name != "" {
+		return p.definition.Response.Name
+	}
+
+	return "response"
+}
+
+func (p *DeclarativeProvider) reportError(ctx context.Context, errChannel chan<- error, err error) {
+	select {
+	case <-ctx.Done():
+	case errChannel <- err:
+	}
+}
+
+// recordChecker is the part of the schema drift checker the records are checked with
+type recordChecker interface {
+	checkRecord(observed map[string]fieldKind, sample []byte)
+}
+
+// publishRecord maps a record and publishes the offer, records which can not be mapped are reported and skipped.
+// It returns false once the context is done
+func (p *DeclarativeProvider) publishRecord(ctx context.Context, record providerRecord, checker recordChecker, offersChannel OfferPublisher, errChannel chan<- error) bool {
+	checker.checkRecord(record.observed, record.sample)
+
+	offer, err := p.mapRecord(record)
+	if err != nil {
+		select {
+		case <-ctx.Done():
+			return false
+		case errChannel <- fmt.Errorf("%s: %w", p.GetProviderName(), err):
+		}
+		return true
+	}
+
+	offersChannel.Publish(offer)
+	select {
+	case <-ctx.Done():
+		return false
+	default:
+		return true
+	}
+}
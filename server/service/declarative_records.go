@@ -0,0 +1,443 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"server/domain"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// providerRecord is a single product of a response independent of its format
+type providerRecord struct {
+	// position in the response, counted from 0
+	index int
+	// values by dotted path or column, objects are flattened and arrays are kept as JSON
+	values   map[string]string
+	observed map[string]fieldKind
+	sample   []byte
+}
+
+// streamRecords decodes the records of a response while it is read and publishes each offer as soon as it is mapped
+func (p *DeclarativeProvider) streamRecords(ctx context.Context, body io.Reader, offersChannel OfferPublisher, errChannel chan<- error) error {
+	// Report changes of the response before they silently turn into zero values
+	schemaChecker := newSchemaDriftChecker(ctx, p.GetProviderName(), p.responseName(), p.schema)
+	defer schemaChecker.report()
+
+	publish := func(record providerRecord) bool {
+		return p.publishRecord(ctx, record, schemaChecker, offersChannel, errChannel)
+	}
+
+	switch p.definition.Response.Format {
+	case CSV_FORMAT:
+		return streamCSVRecords(body, publish)
+	case XML_FORMAT:
+		return streamXMLRecords(body, p.definition.Response.Records, publish)
+	default:
+		return streamJSONRecords(body, p.definition.Response.Records, publish)
+	}
+}
+
+func streamCSVRecords(body io.Reader, publish func(providerRecord) bool) error {
+	reader := csv.NewReader(body)
+	// missing cells are reported per field instead of failing the whole record
+	reader.FieldsPerRecord = -1
+
+	headers, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read CSV headers: %w", err)
+	}
+	for i, header := range headers {
+		headers[i] = strings.TrimSpace(header)
+	}
+
+	for index := 0; ; index++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		record := providerRecord{
+			index:    index,
+			values:   make(map[string]string, len(headers)),
+			observed: csvObservedKinds(headers, row),
+			sample:   []byte(strings.Join(row, ",")),
+		}
+		for i, header := range headers {
+			if i < len(row) {
+				record.values[header] = strings.TrimSpace(row[i])
+			} else {
+				record.values[header] = ""
+			}
+		}
+		if !publish(record) {
+			return nil
+		}
+	}
+}
+
+// streamJSONRecords decodes a top level array element by element, records nested in an object are decoded at once
+func streamJSONRecords(body io.Reader, recordsPath string, publish func(providerRecord) bool) error {
+	if recordsPath != "" {
+		payload, err := io.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		document, err := decodeJSONDocument(payload)
+		if err != nil {
+			return err
+		}
+		records, err := jsonRecordsAt(document, recordsPath)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			if !publish(record) {
+				return nil
+			}
+		}
+		return nil
+	}
+
+	decoder := json.NewDecoder(body)
+	decoder.UseNumber()
+	if token, err := decoder.Token(); err != nil {
+		return err
+	} else if token != json.Delim('[') {
+		return fmt.Errorf("expected array of records, got %v", token)
+	}
+
+	for index := 0; decoder.More(); index++ {
+		var value any
+		if err := decoder.Decode(&value); err != nil {
+			return err
+		}
+		record := newJSONRecord(value)
+		record.index = index
+		if !publish(record) {
+			return nil
+		}
+	}
+
+	_, err := decoder.Token()
+	return err
+}
+
+func decodeJSONDocument(payload []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+
+	var document any
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+
+	return document, nil
+}
+
+// jsonValueAt returns the value at the dotted path, an empty path is the value itself
+func jsonValueAt(value any, path string) (any, bool) {
+	if path == "" {
+		return value, true
+	}
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+func jsonRecordsAt(document any, path string) ([]providerRecord, error) {
+	value, ok := jsonValueAt(document, path)
+	if !ok {
+		return nil, fmt.Errorf("no records at %q", path)
+	}
+	elements, ok := value.([]any)
+	if !ok {
+		return nil, fmt.Errorf("expected array of records at %q", path)
+	}
+
+	records := make([]providerRecord, 0, len(elements))
+	for index, element := range elements {
+		record := newJSONRecord(element)
+		record.index = index
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+func newJSONRecord(value any) providerRecord {
+	sample, _ := json.Marshal(value)
+
+	leaves := make(map[string]any)
+	flattenJSONLeaves("", value, leaves)
+	values := make(map[string]string, len(leaves))
+	for path, leaf := range leaves {
+		values[path] = formatExtraValue(leaf)
+	}
+
+	observed := make(map[string]fieldKind)
+	flattenJSON("", value, observed)
+
+	return providerRecord{values: values, observed: observed, sample: sample}
+}
+
+// streamXMLRecords decodes every element with the record name, values are the texts of the nested elements by their dotted path
+func streamXMLRecords(body io.Reader, recordElement string, publish func(providerRecord) bool) error {
+	decoder := xml.NewDecoder(body)
+	for index := 0; ; {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != recordElement {
+			continue
+		}
+
+		var element struct {
+			Inner []byte `xml:",innerxml"`
+		}
+		if err := decoder.DecodeElement(&element, &start); err != nil {
+			return err
+		}
+		sample := []byte("<" + recordElement + ">" + string(element.Inner) + "</" + recordElement + ">")
+
+		record := providerRecord{
+			index:    index,
+			values:   xmlRecordValues(sample),
+			observed: xmlObservedKinds(sample),
+			sample:   sample,
+		}
+		index++
+		if !publish(record) {
+			return nil
+		}
+	}
+}
+
+func xmlRecordValues(record []byte) map[string]string {
+	values := make(map[string]string)
+	decoder := xml.NewDecoder(bytes.NewReader(record))
+
+	// path[0] is the record element itself
+	var path []string
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return values
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			path = append(path, t.Name.Local)
+			text.Reset()
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if len(path) > 1 {
+				if trimmed := strings.TrimSpace(text.String()); trimmed != "" {
+					values[strings.Join(path[1:], ".")] = trimmed
+				}
+			}
+			text.Reset()
+			if len(path) > 0 {
+				path = path[:len(path)-1]
+			}
+		}
+	}
+}
+
+// fieldMapper sets a single field of the offer from a record value
+type fieldMapper struct {
+	mapping FieldMapping
+	index   int
+}
+
+// offerFieldsByName maps the JSON names of the offer fields mappings can target to their index
+var offerFieldsByName = func() map[string]int {
+	fields := make(map[string]int)
+	offerType := reflect.TypeFor[domain.Offer]()
+	for i := 0; i < offerType.NumField(); i++ {
+		name, _, _ := strings.Cut(offerType.Field(i).Tag.Get("json"), ",")
+		switch offerType.Field(i).Type.Kind() {
+		case reflect.String, reflect.Int, reflect.Bool:
+			fields[name] = i
+		}
+	}
+	// set by the adapter and the voucher mapping
+	delete(fields, "provider")
+	delete(fields, "monthlyCostInCentWithVoucher")
+	delete(fields, "offerHash")
+	delete(fields, "isPreliminary")
+
+	return fields
+}()
+
+func newFieldMappers(mappings []FieldMapping) ([]fieldMapper, error) {
+	mappers := make([]fieldMapper, 0, len(mappings))
+	for _, mapping := range mappings {
+		index, ok := offerFieldsByName[mapping.Target]
+		if !ok {
+			return nil, fmt.Errorf("field %s: unknown offer field %q", mapping.Source, mapping.Target)
+		}
+		if mapping.Source == "" {
+			return nil, fmt.Errorf("field %s: source is required", mapping.Target)
+		}
+		mappers = append(mappers, fieldMapper{mapping: mapping, index: index})
+	}
+
+	return mappers, nil
+}
+
+// schemaKind is the kind of the source, derived from the offer field if the mapping does not declare it
+func (m FieldMapping) schemaKind() fieldKind {
+	if m.Kind != "" {
+		return m.Kind
+	}
+
+	index, ok := offerFieldsByName[m.Target]
+	if !ok {
+		return STRING_FIELD
+	}
+	field := reflect.TypeFor[domain.Offer]().Field(index)
+	switch {
+	case field.Type == reflect.TypeFor[domain.ConnectionType]():
+		return STRING_FIELD
+	case field.Type.Kind() == reflect.Int:
+		return NUMBER_FIELD
+	case field.Type.Kind() == reflect.Bool:
+		return BOOL_FIELD
+	default:
+		return STRING_FIELD
+	}
+}
+
+var errEmptyValue = errors.New("required value is empty")
+
+func (m fieldMapper) apply(offer *domain.Offer, value string) error {
+	field := reflect.ValueOf(offer).Elem().Field(m.index)
+	switch {
+	case field.Type() == reflect.TypeFor[domain.ConnectionType]():
+		field.Set(reflect.ValueOf(domain.FromStringToConnectionType(value)))
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	case field.Kind() == reflect.Int:
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("not an integer: %w", err)
+		}
+		field.SetInt(int64(number))
+	case field.Kind() == reflect.Bool:
+		if len(m.mapping.TrueValues) > 0 {
+			field.SetBool(slices.Contains(m.mapping.TrueValues, value))
+			return nil
+		}
+		switch strings.ToLower(value) {
+		case "true", "yes", "1":
+			field.SetBool(true)
+		case "false", "no", "0":
+			field.SetBool(false)
+		default:
+			return errors.New("not a boolean")
+		}
+	}
+
+	return nil
+}
+
+// mapRecord maps all fields, the voucher and the unmapped fields as extra properties
+func (p *DeclarativeProvider) mapRecord(record providerRecord) (domain.Offer, error) {
+	offer := domain.Offer{}
+
+	var messages []string
+	for _, mapper := range p.mappers {
+		value := record.values[mapper.mapping.Source]
+		if value == "" {
+			if mapper.mapping.RejectEmpty {
+				messages = append(messages, fmt.Sprintf("field %q: %v", mapper.mapping.Source, errEmptyValue))
+			}
+			continue
+		}
+		if err := mapper.apply(&offer, value); err != nil {
+			messages = append(messages, fmt.Sprintf("field %q, value %q: %v", mapper.mapping.Source, value, err))
+		}
+	}
+	if len(messages) > 0 {
+		return offer, fmt.Errorf("failed to map record %d: %s", record.index+1, strings.Join(messages, "; "))
+	}
+
+	if voucher := p.definition.Voucher; voucher != nil {
+		if err := applyVoucherMapping(&offer, *voucher, record); err != nil {
+			return offer, fmt.Errorf("failed to map record %d: %w", record.index+1, err)
+		}
+	}
+
+	// keep every field we do not map
+	for path, value := range record.values {
+		if _, mapped := p.schema[path]; !mapped {
+			offer.SetExtraProperty(p.definition.Response.ExtraPrefix+"."+path, value)
+		}
+	}
+
+	offer.Provider = p.GetProviderName()
+	offer.HelperIsPreliminary = false
+	return offer, nil
+}
+
+func applyVoucherMapping(offer *domain.Offer, voucher VoucherMapping, record providerRecord) error {
+	voucherType := record.values[voucher.Type]
+	if voucherType == "" {
+		return nil
+	}
+	value := 0
+	if text := record.values[voucher.Value]; text != "" {
+		var err error
+		if value, err = strconv.Atoi(text); err != nil {
+			return fmt.Errorf("field %q, value %q: not an integer: %w", voucher.Value, text, err)
+		}
+	}
+
+	switch voucherType {
+	case voucher.Percentage:
+		offer.MonthlyCostInCentWithVoucher = offer.MonthlyCostInCent - (offer.MonthlyCostInCent * value / 100)
+		offer.VoucherDetails = domain.VoucherDetails{
+			Type:  domain.PERCENTAGE,
+			Value: value,
+		}
+	case voucher.Absolute:
+		if offer.ContractDurationInMonths > 0 {
+			// calculate the voucher if applied to one contract length
+			offer.MonthlyCostInCentWithVoucher = offer.MonthlyCostInCent - value/offer.ContractDurationInMonths
+			offer.VoucherDetails = domain.VoucherDetails{
+				Type:  domain.ABSOLUTE,
+				Value: value,
+			}
+		}
+	}
+
+	return nil
+}
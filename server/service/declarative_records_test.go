@@ -0,0 +1,30 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"server/domain"
+	"testing"
+)
+
+type discardPublisher struct{}
+
+func (discardPublisher) Publish(domain.Offer) {}
+
+// FuzzByteMeCSV makes sure no CSV payload makes the ByteMe mapping panic
+func FuzzByteMeCSV(f *testing.F) {
+	for _, payload := range goldenPayloads(f, "byteme") {
+		f.Add(payload)
+	}
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		errChannel := make(chan error)
+		go func() {
+			for range errChannel {
+			}
+		}()
+		defer close(errChannel)
+
+		byteMeProvider.streamRecords(context.Background(), bytes.NewReader(payload), discardPublisher{}, errChannel)
+	})
+}
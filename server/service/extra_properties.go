@@ -0,0 +1,70 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// extraPropertiesFromJSON returns every leaf value of a JSON record which is not mapped by the schema,
+// keyed by its path prefixed with the namespace of the provider
+func extraPropertiesFromJSON(namespace string, record []byte, schema responseSchema) map[string]string {
+	var raw any
+	if err := json.Unmarshal(record, &raw); err != nil {
+		return nil
+	}
+
+	leaves := make(map[string]any)
+	flattenJSONLeaves("", raw, leaves)
+
+	var extras map[string]string
+	for path, value := range leaves {
+		if _, mapped := schema[path]; mapped {
+			continue
+		}
+		if extras == nil {
+			extras = make(map[string]string)
+		}
+		extras[namespace+"."+path] = formatExtraValue(value)
+	}
+
+	return extras
+}
+
+// flattenJSONLeaves collects all non object values by their dotted path, arrays are kept as a whole
+func flattenJSONLeaves(prefix string, value any, leaves map[string]any) {
+	object, ok := value.(map[string]any)
+	if !ok {
+		if value != nil {
+			leaves[prefix] = value
+		}
+		return
+	}
+
+	for key, child := range object {
+		flattenJSONLeaves(joinFieldPath(prefix, key), child, leaves)
+	}
+}
+
+func formatExtraValue(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []any:
+		encoded, _ := json.Marshal(v)
+		return string(encoded)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// mergeExtraProperties copies all properties into the map of the offer
+func mergeExtraProperties(extras map[string]string, into map[string]string) map[string]string {
+	for key, value := range extras {
+		if into == nil {
+			into = make(map[string]string, len(extras))
+		}
+		into[key] = value
+	}
+
+	return into
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"server/domain"
+	"strings"
+	"testing"
+)
+
+// the golden corpus holds recorded provider payloads in testdata/golden/<provider>/, each next to the golden file of
+// the offers its mapping produced. After an intended change the golden files are rewritten with
+//
+//	go test ./service -run Golden -update
+var updateGolden = flag.Bool("update", false, "rewrite the golden files instead of comparing them")
+
+const goldenDir = "testdata/golden"
+
+// goldenSuffix is appended to the name of a recorded payload to get the name of its golden file
+const goldenSuffix = ".golden.json"
+
+type goldenDecoder func(ctx context.Context, payload io.Reader, offersChannel OfferPublisher, errChannel chan<- error) error
+
+// goldenDecoders map the directory of a provider in the golden corpus to the mapping function of its adapter
+var goldenDecoders = map[string]goldenDecoder{
+	"byteme":      byteMeProvider.streamRecords,
+	"pingperfect": pingPerfectProvider.streamRecords,
+	"webwunder": func(ctx context.Context, payload io.Reader, offersChannel OfferPublisher, _ chan<- error) error {
+		return (&WebWunderApi{}).streamProducts(ctx, payload, false, offersChannel)
+	},
+	"servusspeed": func(_ context.Context, payload io.Reader, offersChannel OfferPublisher, _ chan<- error) error {
+		api := &ServusSpeedApi{}
+		body, err := io.ReadAll(payload)
+		if err != nil {
+			return err
+		}
+
+		var product ServusSpeedProductResponse
+		if err := json.Unmarshal(body, &product); err != nil {
+			return err
+		}
+		product.Raw = body
+
+		offer := api.convertToOffer(&product)
+		offer.Provider = api.GetProviderName()
+		offersChannel.Publish(offer)
+		return nil
+	},
+	// VerbynDich payloads hold one page per line
+	"verbyndich": func(_ context.Context, payload io.Reader, offersChannel OfferPublisher, errChannel chan<- error) error {
+		api := &VerbyndichAPI{}
+		scanner := bufio.NewScanner(payload)
+		for scanner.Scan() {
+			var response VerbyndichResponse
+			if err := json.Unmarshal(scanner.Bytes(), &response); err != nil {
+				return err
+			}
+			response.Raw = bytes.Clone(scanner.Bytes())
+
+			offer, result := api.responseToOffer(&response)
+			if !result.IsComplete() {
+				errChannel <- fmt.Errorf("%s: description of %s parsed only partially, missing %v", api.GetProviderName(), offer.ProductName, result.MissingFields)
+			}
+			offersChannel.Publish(offer)
+		}
+
+		return scanner.Err()
+	},
+}
+
+// goldenResult is what the mapping of a recorded payload produced, it is stored as golden file next to the payload
+type goldenResult struct {
+	Offers []domain.Offer `json:"offers"`
+	Errors []string       `json:"errors,omitempty"`
+}
+
+type goldenCollector struct {
+	offers []domain.Offer
+}
+
+func (c *goldenCollector) Publish(offer domain.Offer) {
+	offer.GenerateHash()
+	c.offers = append(c.offers, offer)
+}
+
+func TestGolden(t *testing.T) {
+	for provider, decode := range goldenDecoders {
+		for _, fixturePath := range goldenFixtures(t, provider) {
+			t.Run(provider+"/"+filepath.Base(fixturePath), func(t *testing.T) {
+				actual := runGoldenFixture(t, fixturePath, decode)
+
+				goldenPath := fixturePath + goldenSuffix
+				if *updateGolden {
+					if err := os.WriteFile(goldenPath, actual, 0o644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+
+				expected, err := os.ReadFile(goldenPath)
+				if err != nil {
+					t.Fatalf("%v, create it with -update", err)
+				}
+				if !bytes.Equal(expected, actual) {
+					t.Errorf("mapping differs from %s, rewrite it with -update if the change is intended\n%s", goldenPath, firstDifference(expected, actual))
+				}
+			})
+		}
+	}
+}
+
+// goldenFixtures returns the paths of the recorded payloads of the provider
+func goldenFixtures(tb testing.TB, provider string) []string {
+	entries, err := os.ReadDir(filepath.Join(goldenDir, provider))
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	var fixtures []string
+	for _, entry := range entries {
+		if !entry.IsDir() && !strings.HasSuffix(entry.Name(), goldenSuffix) {
+			fixtures = append(fixtures, filepath.Join(goldenDir, provider, entry.Name()))
+		}
+	}
+
+	return fixtures
+}
+
+// goldenPayloads returns the recorded payloads of the provider, e.g. as seeds of a fuzz target
+func goldenPayloads(tb testing.TB, provider string) [][]byte {
+	var payloads [][]byte
+	for _, fixturePath := range goldenFixtures(tb, provider) {
+		payload, err := os.ReadFile(fixturePath)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		payloads = append(payloads, payload)
+	}
+
+	return payloads
+}
+
+func runGoldenFixture(t *testing.T, fixturePath string, decode goldenDecoder) []byte {
+	payload, err := os.Open(fixturePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer payload.Close()
+
+	collector := &goldenCollector{}
+	result := goldenResult{}
+
+	errChannel := make(chan error)
+	errorsCollected := make(chan struct{})
+	go func() {
+		for err := range errChannel {
+			result.Errors = append(result.Errors, err.Error())
+		}
+		close(errorsCollected)
+	}()
+
+	decodeErr := decode(context.Background(), payload, collector, errChannel)
+	close(errChannel)
+	<-errorsCollected
+	if decodeErr != nil {
+		result.Errors = append(result.Errors, decodeErr.Error())
+	}
+	// an empty list instead of null, a response without offers is a valid result
+	result.Offers = append([]domain.Offer{}, collector.offers...)
+
+	golden, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return append(golden, '\n')
+}
+
+// firstDifference returns the first differing line of both golden files
+func firstDifference(expected []byte, actual []byte) string {
+	expectedLines := strings.Split(string(expected), "\n")
+	actualLines := strings.Split(string(actual), "\n")
+	for i := 0; i < max(len(expectedLines), len(actualLines)); i++ {
+		var expectedLine, actualLine string
+		if i < len(expectedLines) {
+			expectedLine = expectedLines[i]
+		}
+		if i < len(actualLines) {
+			actualLine = actualLines[i]
+		}
+		if expectedLine != actualLine {
+			return fmt.Sprintf("  line %d\n  expected: %s\n  actual:   %s", i+1, expectedLine, actualLine)
+		}
+	}
+
+	return ""
+}
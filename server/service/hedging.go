@@ -0,0 +1,221 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"server/utils"
+	"sync"
+	"time"
+)
+
+// outcomes of hedgesCounter, e.g. "ByteMe:products:won"
+const (
+	// a duplicate request was sent as the original was slower than usual
+	HEDGE_SENT = "sent"
+	// the duplicate answered first
+	HEDGE_WON = "won"
+	// a duplicate was due but the budget was exhausted
+	HEDGE_DENIED = "denied"
+)
+
+const (
+	// calls of a kind before they are hedged, the delay is unknown before
+	minHedgeSamples = 20
+	// hedges the budget saves up for bursts
+	maxHedgeTokens = 10
+)
+
+var hedgesCounter = utils.NewCounterVec("provider_hedges_total")
+
+type hedgeContextKey struct{}
+
+type hedgeSpec struct {
+	// kind of call the latency is tracked for, e.g. "WebWunder:offers"
+	call string
+	// the call only reads, so sending it twice is safe even though the method is not idempotent
+	readOnly bool
+}
+
+// withHedging marks a request as hedgeable, if it has not answered after the usual latency of the call a duplicate
+// is sent. Requests with a method which is not idempotent are only hedged if readOnly is set
+func withHedging(req *http.Request, call string, readOnly bool) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), hedgeContextKey{}, hedgeSpec{call: call, readOnly: readOnly}))
+}
+
+func (spec hedgeSpec) allows(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		if !spec.readOnly {
+			return false
+		}
+	}
+
+	// the duplicate needs its own copy of the body
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// hedgeBudget limits hedges to a share of the traffic, every hedgeable call earns a fraction of a hedge. The tokens are
+// counted in percent of a hedge, so the fractions add up without rounding errors
+type hedgeBudget struct {
+	mu     sync.Mutex
+	tokens float64
+}
+
+var hedgeBudgetInstance = &hedgeBudget{}
+
+func (b *hedgeBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens = min(b.tokens+utils.Cfg.Hedging.BudgetPercent, maxHedgeTokens*100)
+}
+
+func (b *hedgeBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.tokens < 100 {
+		return false
+	}
+	b.tokens -= 100
+	return true
+}
+
+var (
+	hedgeLatenciesMu sync.Mutex
+	// latency until the response headers arrived by call
+	hedgeLatencies = make(map[string]*utils.LatencyWindow)
+)
+
+func hedgeLatency(call string) *utils.LatencyWindow {
+	hedgeLatenciesMu.Lock()
+	defer hedgeLatenciesMu.Unlock()
+
+	window, ok := hedgeLatencies[call]
+	if !ok {
+		window = utils.NewLatencyWindow(latencyWindowSize)
+		hedgeLatencies[call] = window
+	}
+
+	return window
+}
+
+// hedgingTransport sends a duplicate of a hedgeable request once it took longer than the configured quantile of
+// its call. The first response wins, the other request is cancelled
+type hedgingTransport struct {
+	next http.RoundTripper
+}
+
+type hedgeAttempt struct {
+	resp  *http.Response
+	err   error
+	hedge bool
+}
+
+func (t *hedgingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	spec, ok := req.Context().Value(hedgeContextKey{}).(hedgeSpec)
+	if !ok || !spec.allows(req) {
+		return t.next.RoundTrip(req)
+	}
+
+	latency := hedgeLatency(spec.call)
+	start := time.Now()
+	if !utils.Cfg.Hedging.Enabled || latency.Count() < minHedgeSamples {
+		resp, err := t.next.RoundTrip(req)
+		if err == nil {
+			latency.Add(time.Since(start))
+		}
+		return resp, err
+	}
+	hedgeBudgetInstance.deposit()
+
+	// buffered, so the attempt which lost never blocks
+	attempts := make(chan hedgeAttempt, 2)
+	cancels := make(map[bool]context.CancelFunc, 2)
+	send := func(attemptReq *http.Request, hedge bool) {
+		ctx, cancel := context.WithCancel(req.Context())
+		cancels[hedge] = cancel
+		attemptReq = attemptReq.WithContext(ctx)
+		go func() {
+			resp, err := t.next.RoundTrip(attemptReq)
+			attempts <- hedgeAttempt{resp: resp, err: err, hedge: hedge}
+		}()
+	}
+	send(req, false)
+	pending := 1
+
+	delay := time.NewTimer(latency.Quantile(utils.Cfg.Hedging.Quantile))
+	defer delay.Stop()
+
+	var firstErr error
+	for {
+		select {
+		case <-delay.C:
+			if !hedgeBudgetInstance.withdraw() {
+				hedgesCounter.Inc(spec.call + ":" + HEDGE_DENIED)
+				continue
+			}
+
+			hedgeReq := req.Clone(req.Context())
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					continue
+				}
+				hedgeReq.Body = body
+			}
+			send(hedgeReq, true)
+			pending++
+			hedgesCounter.Inc(spec.call + ":" + HEDGE_SENT)
+		case attempt := <-attempts:
+			pending--
+			if attempt.err != nil {
+				cancels[attempt.hedge]()
+				if firstErr == nil {
+					firstErr = attempt.err
+				}
+				// the other request may still succeed, a hedge is not sent after the original failed
+				if pending > 0 {
+					continue
+				}
+				return nil, firstErr
+			}
+
+			latency.Add(time.Since(start))
+			if attempt.hedge {
+				hedgesCounter.Inc(spec.call + ":" + HEDGE_WON)
+			}
+			if pending > 0 {
+				cancels[!attempt.hedge]()
+				go discardLosers(attempts, pending)
+			}
+
+			// the context of the winner ends with its body
+			attempt.resp.Body = &cancelOnClose{ReadCloser: attempt.resp.Body, cancel: cancels[attempt.hedge]}
+			return attempt.resp, nil
+		}
+	}
+}
+
+// discardLosers closes the responses of the cancelled attempts
+func discardLosers(attempts <-chan hedgeAttempt, pending int) {
+	for ; pending > 0; pending-- {
+		attempt := <-attempts
+		if attempt.resp != nil {
+			attempt.resp.Body.Close()
+		}
+	}
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"path"
+	"server/utils"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// roundTripFunc answers requests without a server
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func okResponse(body string) *http.Response {
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}
+}
+
+// enableHedging turns hedging on with a full budget and the call hedged after a millisecond once it has samples
+func enableHedging(t *testing.T, call string, samples int) {
+	enabled := utils.Cfg.Hedging.Enabled
+	utils.Cfg.Hedging.Enabled = true
+	hedgeBudgetInstance.mu.Lock()
+	tokens := hedgeBudgetInstance.tokens
+	hedgeBudgetInstance.tokens = maxHedgeTokens * 100
+	hedgeBudgetInstance.mu.Unlock()
+	t.Cleanup(func() {
+		utils.Cfg.Hedging.Enabled = enabled
+		hedgeBudgetInstance.mu.Lock()
+		hedgeBudgetInstance.tokens = tokens
+		hedgeBudgetInstance.mu.Unlock()
+
+		hedgeLatenciesMu.Lock()
+		delete(hedgeLatencies, call)
+		hedgeLatenciesMu.Unlock()
+	})
+
+	for range samples {
+		hedgeLatency(call).Add(time.Millisecond)
+	}
+}
+
+func hedgedRequest(t *testing.T, call string) *http.Request {
+	req, err := http.NewRequest(http.MethodGet, "http://provider.test/offers", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return withHedging(req, call, false)
+}
+
+func TestHedgingNeedsSamples(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		samples int
+		want    int64
+	}{
+		{name: "too few samples", samples: minHedgeSamples - 1, want: 1},
+		{name: "enough samples", samples: minHedgeSamples, want: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			call := path.Join(t.Name(), "offers")
+			enableHedging(t, call, tc.samples)
+
+			var sent atomic.Int64
+			transport := &hedgingTransport{next: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				sent.Add(1)
+				time.Sleep(50 * time.Millisecond)
+				return okResponse("offers"), nil
+			})}
+			resp, err := transport.RoundTrip(hedgedRequest(t, call))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if got := sent.Load(); got != tc.want {
+				t.Errorf("sent %d requests, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHedgingCancelsLoser(t *testing.T) {
+	call := path.Join(t.Name(), "offers")
+	enableHedging(t, call, minHedgeSamples)
+	won := hedgesCounter.Get(call + ":" + HEDGE_WON)
+
+	// the original hangs until it is cancelled, the duplicate answers at once
+	var sent atomic.Int64
+	originalCancelled := make(chan error, 1)
+	transport := &hedgingTransport{next: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if sent.Add(1) > 1 {
+			return okResponse("hedge"), nil
+		}
+		select {
+		case <-req.Context().Done():
+			originalCancelled <- req.Context().Err()
+			return nil, req.Context().Err()
+		case <-time.After(time.Second):
+			originalCancelled <- nil
+			return okResponse("original"), nil
+		}
+	})}
+
+	resp, err := transport.RoundTrip(hedgedRequest(t, call))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "hedge" {
+		t.Errorf("response is %q, want the one of the duplicate", body)
+	}
+	if err := <-originalCancelled; err != context.Canceled {
+		t.Errorf("original ended with %v, want it cancelled", err)
+	}
+	if got := hedgesCounter.Get(call+":"+HEDGE_WON) - won; got != 1 {
+		t.Errorf("won hedges were counted %d times, want 1", got)
+	}
+}
+
+func TestHedgeBudget(t *testing.T) {
+	percent := utils.Cfg.Hedging.BudgetPercent
+	t.Cleanup(func() { utils.Cfg.Hedging.BudgetPercent = percent })
+
+	for _, tc := range []struct {
+		name     string
+		percent  float64
+		deposits int
+		// hedges the deposits pay for
+		want int
+	}{
+		{name: "less than one hedge", percent: 10, deposits: 9, want: 0},
+		{name: "one hedge", percent: 10, deposits: 10, want: 1},
+		{name: "share of the calls", percent: 25, deposits: 20, want: 5},
+		{name: "saved up for bursts", percent: 100, deposits: 50, want: maxHedgeTokens},
+		{name: "disabled", percent: 0, deposits: 100, want: 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			utils.Cfg.Hedging.BudgetPercent = tc.percent
+			budget := &hedgeBudget{}
+			for range tc.deposits {
+				budget.deposit()
+			}
+
+			hedges := 0
+			for budget.withdraw() {
+				hedges++
+			}
+			if hedges != tc.want {
+				t.Errorf("budget paid for %d hedges, want %d", hedges, tc.want)
+			}
+		})
+	}
+}
+
+func TestSignedRequestsAreNotHedged(t *testing.T) {
+	definition, err := providerDefinitions.ReadFile("providers/pingperfect.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hedged := bytes.Replace(definition, []byte("  readOnly: true\n"), []byte("  readOnly: true\n  hedge: true\n"), 1)
+
+	_, err = NewDeclarativeProvider(hedged)
+	if err == nil || !strings.Contains(err.Error(), "signed and can not be hedged") {
+		t.Errorf("hedged signed request was accepted with %v", err)
+	}
+}
@@ -2,11 +2,51 @@ package service
 
 import (
 	"context"
+	"net/http"
 	"server/domain"
-	"server/utils"
+	"slices"
 )
 
+// OfferPublisher is the sink provider adapters publish their parsed offers into
+type OfferPublisher interface {
+	Publish(offer domain.Offer)
+}
+
+// FilterCapabilities declares which filter options a provider can evaluate upstream
+type FilterCapabilities struct {
+	// connection types the query can be restricted to
+	ConnectionTypes []domain.ConnectionType
+	// the query can be restricted to offers with or without installation service
+	Installation bool
+	// connection types the provider has offers of, nil if it may have offers of any type
+	OfferedConnectionTypes []domain.ConnectionType
+}
+
+// excludes reports whether the provider cannot have offers matching the filter, it is not queried then
+func (capabilities FilterCapabilities) excludes(filter domain.OfferFilter) bool {
+	return filter.ConnectionType != nil && *filter.ConnectionType != "" && capabilities.OfferedConnectionTypes != nil &&
+		!slices.Contains(capabilities.OfferedConnectionTypes, *filter.ConnectionType)
+}
+
+// upstreamFilter returns the part of the filter the provider evaluates itself, the rest is filtered locally
+func (capabilities FilterCapabilities) upstreamFilter(filter domain.OfferFilter) domain.OfferFilter {
+	var upstream domain.OfferFilter
+	if filter.ConnectionType != nil && slices.Contains(capabilities.ConnectionTypes, *filter.ConnectionType) {
+		upstream.ConnectionType = filter.ConnectionType
+	}
+	if filter.Installation != nil && capabilities.Installation {
+		upstream.Installation = filter.Installation
+	}
+
+	return upstream
+}
+
 type InternetProviderAPI interface {
-	GetOffersStream(ctx context.Context, address domain.Address, offersChannel *utils.PubSubChannel[domain.Offer], errChannel chan<- error)
+	// GetOffersStream publishes the offers for the address. The filter only holds options the provider declared
+	// in its FilterCapabilities, offers not matching it may be left out
+	GetOffersStream(ctx context.Context, address domain.Address, filter domain.OfferFilter, offersChannel OfferPublisher, errChannel chan<- error)
 	GetProviderName() string
+	GetFilterCapabilities() FilterCapabilities
+	// withTransport returns a copy of the adapter which sends its requests with transport, e.g. to a local stub
+	withTransport(transport http.RoundTripper) InternetProviderAPI
 }
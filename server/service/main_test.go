@@ -0,0 +1,47 @@
+package service
+
+import (
+	"os"
+	"server/utils"
+	"testing"
+
+	"github.com/caarlos0/env/v11"
+	log "github.com/sirupsen/logrus"
+)
+
+// testCredentials are set for the tests, the stubs do not check them but the adapters refuse to send requests without them
+var testCredentials = []string{
+	"VERBYNDICH_API_KEY",
+	"WEBWUNDER_API_KEY",
+	"SERVUSSPEED_USERNAME",
+	"SERVUSSPEED_PASSWORD",
+	"BYTEME_API_KEY",
+	"PINGPERFECT_SIGNATURE_SECRET",
+	"PINGPERFECT_CLIENT_ID",
+}
+
+// TestMain loads the defaults of the settings the adapters use, the stores are not needed
+func TestMain(m *testing.M) {
+	// drift warnings and retries of failing stubs would drown the test output
+	log.SetLevel(log.FatalLevel)
+
+	for _, section := range []any{
+		&utils.Cfg.NegativeCache, &utils.Cfg.Server, &utils.Cfg.Timeouts, &utils.Cfg.Hedging, &utils.Cfg.Scheduler,
+		&utils.Cfg.ProviderLimits, &utils.Cfg.ProviderTraffic, &utils.Cfg.Secrets, &utils.Cfg.VerbynDich, &utils.Cfg.ServusSpeed,
+	} {
+		if err := env.Parse(section); err != nil {
+			log.WithError(err).Fatal("Failed to parse test config")
+		}
+	}
+	// retries of failing stubs must not outlast the tests
+	utils.Cfg.Server.RetryFrequencyMilli = []uint{10, 20}
+
+	for _, name := range testCredentials {
+		os.Setenv(name, "test-"+name)
+	}
+	if err := utils.LoadCredentials(); err != nil {
+		log.WithError(err).Fatal("Failed to load test credentials")
+	}
+
+	os.Exit(m.Run())
+}
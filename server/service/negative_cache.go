@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"server/domain"
+	"server/utils"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// outcomes of negativeCacheCounter, e.g. "ByteMe:hit"
+const (
+	// the provider was skipped as it recently had no offers for the address
+	NEGATIVE_HIT = "hit"
+	// a result without offers was cached
+	NEGATIVE_STORED = "stored"
+)
+
+var negativeCacheCounter = utils.NewCounterVec("negative_cache_total")
+
+// NegativeCache stores per provider and address why a lookup had no offers, entries expire with their TTL
+type NegativeCache interface {
+	// Get returns the reasons of the providers which have an entry for the address
+	Get(ctx context.Context, addressHash string, providers []string) (map[string]string, error)
+	Set(ctx context.Context, addressHash string, provider string, reason string, ttl time.Duration) error
+}
+
+// NegativeCacheInstance lets lookups skip providers without coverage, without it every lookup queries all providers
+var NegativeCacheInstance NegativeCache
+
+// negativeResults returns the providers without coverage for the address and the reason, if the entries are
+// unavailable all providers are queried
+func negativeResults(ctx context.Context, addressHash string, providerNames []string) map[string]string {
+	if NegativeCacheInstance == nil {
+		return nil
+	}
+
+	reasons, err := NegativeCacheInstance.Get(ctx, addressHash, providerNames)
+	if err != nil {
+		log.WithError(err).Warn("Failed to get negative cache entries, all providers are queried")
+		return nil
+	}
+
+	return reasons
+}
+
+// noCoverageStatus is the status of a provider skipped for a negative entry, a rejected address is reported as error kind
+func noCoverageStatus(provider string, reason string) domain.ProviderStatus {
+	status := domain.ProviderStatus{Provider: provider, Status: domain.PROVIDER_NO_COVERAGE_CACHED}
+	if reason == string(ADDRESS_REJECTED) {
+		status.ErrorKind = reason
+	}
+
+	return status
+}
+
+// negativeReason returns the reason and the TTL to cache a finished provider lookup with. Only complete lookups
+// without any offer are negative, offers which failed validation show the provider has coverage. A rejected address is
+// only cached if the provider rejected it with every request of the lookup
+func negativeReason(status domain.ProviderStatus, addressRejected bool, quarantined int64) (string, time.Duration) {
+	if status.Offers > 0 || quarantined > 0 {
+		return "", 0
+	}
+
+	switch {
+	case status.Status == domain.PROVIDER_NO_OFFERS:
+		return string(domain.PROVIDER_NO_OFFERS), time.Duration(utils.Cfg.NegativeCache.NoOffersTTL) * time.Second
+	case addressRejected:
+		return string(ADDRESS_REJECTED), time.Duration(utils.Cfg.NegativeCache.AddressRejectedTTL) * time.Second
+	default:
+		return "", 0
+	}
+}
+
+// storeNegativeResult caches the lookup of a provider if it had no offers, failures are only logged
+func storeNegativeResult(ctx context.Context, addressHash string, status domain.ProviderStatus, addressRejected bool, quarantined int64) {
+	reason, ttl := negativeReason(status, addressRejected, quarantined)
+	if NegativeCacheInstance == nil || reason == "" || ttl <= 0 {
+		return
+	}
+
+	if err := NegativeCacheInstance.Set(ctx, addressHash, status.Provider, reason, ttl); err != nil {
+		log.WithError(err).WithField("provider", status.Provider).Warn("Failed to cache result without offers")
+		return
+	}
+	negativeCacheCounter.Inc(status.Provider + ":" + NEGATIVE_STORED)
+}
@@ -0,0 +1,320 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"server/domain"
+	"server/utils"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type negativeEntry struct {
+	reason string
+	ttl    time.Duration
+}
+
+// memoryNegativeCache keeps the entries of NegativeCache in memory, the TTLs are recorded but never expire
+type memoryNegativeCache struct {
+	mu      sync.Mutex
+	entries map[string]negativeEntry
+}
+
+func (c *memoryNegativeCache) Get(_ context.Context, addressHash string, providers []string) (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	reasons := make(map[string]string)
+	for _, provider := range providers {
+		if entry, ok := c.entries[provider+":"+addressHash]; ok {
+			reasons[provider] = entry.reason
+		}
+	}
+	return reasons, nil
+}
+
+func (c *memoryNegativeCache) Set(_ context.Context, addressHash string, provider string, reason string, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[provider+":"+addressHash] = negativeEntry{reason: reason, ttl: ttl}
+	return nil
+}
+
+func TestNegativeCache(t *testing.T) {
+	webWunderFault := documentedWebWunderFault(t)
+	noOffersTTL := time.Duration(utils.Cfg.NegativeCache.NoOffersTTL) * time.Second
+	rejectedTTL := time.Duration(utils.Cfg.NegativeCache.AddressRejectedTTL) * time.Second
+
+	for _, tc := range []struct {
+		name     string
+		provider InternetProviderAPI
+		script   http.HandlerFunc
+		status   domain.ProviderStatusType
+		reason   string
+		ttl      time.Duration
+	}{
+		{
+			name:     "ByteMe without offers",
+			provider: byteMeProvider,
+			script: func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "text/csv")
+				fmt.Fprintln(w, "productId,providerName,speed,monthlyCostInCent,afterTwoYearsMonthlyCost,durationInMonths,connectionType,installationService,tv,limitFrom,maxAge,voucherType,voucherValue")
+			},
+			status: domain.PROVIDER_NO_OFFERS,
+			reason: string(domain.PROVIDER_NO_OFFERS),
+			ttl:    noOffersTTL,
+		},
+		{
+			name:     "documented status rejects the address",
+			provider: byteMeRejectingWith(http.StatusNotFound),
+			script:   func(w http.ResponseWriter, r *http.Request) { http.NotFound(w, r) },
+			status:   domain.PROVIDER_ERROR,
+			reason:   string(ADDRESS_REJECTED),
+			ttl:      rejectedTTL,
+		},
+		{
+			name:     "WebWunder rejects the address",
+			provider: &WebWunderApi{},
+			script: func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "text/xml")
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write(webWunderFault)
+			},
+			status: domain.PROVIDER_ERROR,
+			reason: string(ADDRESS_REJECTED),
+			ttl:    rejectedTTL,
+		},
+		{
+			name:     "VerbynDich rejects the address",
+			provider: &VerbyndichAPI{},
+			script: func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprint(w, `{"product": "", "description": "", "last": true, "valid": false}`)
+			},
+			status: domain.PROVIDER_ERROR,
+			reason: string(ADDRESS_REJECTED),
+			ttl:    rejectedTTL,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cache := &memoryNegativeCache{entries: make(map[string]negativeEntry)}
+			previous := NegativeCacheInstance
+			NegativeCacheInstance = cache
+			t.Cleanup(func() { NegativeCacheInstance = previous })
+
+			var requests atomic.Int64
+			stub := newConformanceStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				tc.script(w, r)
+			}))
+			defer stub.close()
+			service := OfferServiceImpl{providers: []InternetProviderAPI{tc.provider.withTransport(stub)}}
+			name := tc.provider.GetProviderName()
+
+			status := lookupStatus(t, service)
+			if status.Status != tc.status {
+				t.Errorf("first lookup has status %s, want %s", status.Status, tc.status)
+			}
+			entry, ok := cache.entries[name+":"+domain.GetHashByAddress(conformanceAddress)]
+			if !ok {
+				t.Fatalf("no negative entry was stored")
+			}
+			if entry.reason != tc.reason || entry.ttl != tc.ttl {
+				t.Errorf("stored %s for %s, want %s for %s", entry.reason, entry.ttl, tc.reason, tc.ttl)
+			}
+
+			sent := requests.Load()
+			status = lookupStatus(t, service)
+			if status.Status != domain.PROVIDER_NO_COVERAGE_CACHED {
+				t.Errorf("second lookup has status %s, want %s", status.Status, domain.PROVIDER_NO_COVERAGE_CACHED)
+			}
+			if rejected := tc.reason == string(ADDRESS_REJECTED); rejected != (status.ErrorKind == string(ADDRESS_REJECTED)) {
+				t.Errorf("second lookup has error kind %q for reason %s", status.ErrorKind, tc.reason)
+			}
+			if sent != requests.Load() {
+				t.Errorf("second lookup sent %d requests, the provider should have been skipped", requests.Load()-sent)
+			}
+		})
+	}
+}
+
+// lookupStatus runs a lookup of the only provider of the service and returns its status
+func lookupStatus(t *testing.T, service OfferServiceImpl) domain.ProviderStatus {
+	stream := service.FetchOffersStream(context.Background(), conformanceAddress, domain.OfferFilter{}, LookupOptions{})
+	go func() {
+		for range stream.Errors {
+		}
+	}()
+
+	var statuses []domain.ProviderStatus
+	for status := range stream.Statuses {
+		statuses = append(statuses, status)
+	}
+	if len(statuses) != 1 {
+		t.Fatalf("got %d statuses, want 1", len(statuses))
+	}
+
+	return statuses[0]
+}
+
+func TestNegativeCacheIgnoresUndocumentedStatus(t *testing.T) {
+	webWunderValidationFault, err := os.ReadFile(filepath.Join(goldenDir, "webwunder", "fault-plz.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		provider InternetProviderAPI
+		status   int
+		// plain text if nil
+		body []byte
+	}{
+		{name: "ByteMe 404", provider: byteMeProvider, status: http.StatusNotFound},
+		{name: "ByteMe with another documented status", provider: byteMeRejectingWith(http.StatusNotFound), status: http.StatusBadRequest},
+		{name: "PingPerfect 422", provider: pingPerfectProvider, status: http.StatusUnprocessableEntity},
+		{name: "ServusSpeed 400", provider: &ServusSpeedApi{}, status: http.StatusBadRequest},
+		{name: "ServusSpeed 404", provider: &ServusSpeedApi{}, status: http.StatusNotFound},
+		{name: "ServusSpeed 405", provider: &ServusSpeedApi{}, status: http.StatusMethodNotAllowed},
+		// a validation fault naming the zip code may as well be caused by our request
+		{name: "WebWunder validation fault", provider: &WebWunderApi{}, status: http.StatusInternalServerError, body: webWunderValidationFault},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cache := &memoryNegativeCache{entries: make(map[string]negativeEntry)}
+			previous := NegativeCacheInstance
+			NegativeCacheInstance = cache
+			t.Cleanup(func() { NegativeCacheInstance = previous })
+
+			stub := newConformanceStub(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				if tc.body == nil {
+					http.Error(w, "rejected", tc.status)
+					return
+				}
+				w.Header().Set("Content-Type", "text/xml")
+				w.WriteHeader(tc.status)
+				w.Write(tc.body)
+			}))
+			defer stub.close()
+			service := OfferServiceImpl{providers: []InternetProviderAPI{tc.provider.withTransport(stub)}}
+
+			status := lookupStatus(t, service)
+			if status.Status != domain.PROVIDER_ERROR || status.ErrorKind == string(ADDRESS_REJECTED) {
+				t.Errorf("lookup has status %s with error kind %q, want an error which is no rejected address", status.Status, status.ErrorKind)
+			}
+			if len(cache.entries) > 0 {
+				t.Errorf("stored %v, an undocumented status must not hide the provider", cache.entries)
+			}
+		})
+	}
+}
+
+// documentedWebWunderFault returns a fault body with a code WebWunder is assumed to document for unknown addresses
+func documentedWebWunderFault(t *testing.T) []byte {
+	previous := webWunderAddressFaultCodes
+	webWunderAddressFaultCodes = map[string]bool{"Client.UnknownAddress": true}
+	t.Cleanup(func() { webWunderAddressFaultCodes = previous })
+
+	return []byte(`<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://schemas.xmlsoap.org/soap/envelope/"><SOAP-ENV:Body><SOAP-ENV:Fault>` +
+		`<faultcode>SOAP-ENV:Client.UnknownAddress</faultcode><faultstring>unknown address</faultstring>` +
+		`</SOAP-ENV:Fault></SOAP-ENV:Body></SOAP-ENV:Envelope>`)
+}
+
+// byteMeRejectingWith returns ByteMe as if it documented to answer unknown addresses with the statuses
+func byteMeRejectingWith(statuses ...int) InternetProviderAPI {
+	provider := *byteMeProvider
+	provider.definition.Request.AddressRejectedStatus = statuses
+	return &provider
+}
+
+func TestNegativeCacheNeedsEveryRequestRejected(t *testing.T) {
+	webWunderFault := documentedWebWunderFault(t)
+	webWunderNoOffers, err := os.ReadFile(filepath.Join(goldenDir, "webwunder", "no-offers.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the fiber requests are rejected, the other connection types answer with others
+	for _, tc := range []struct {
+		name   string
+		others http.HandlerFunc
+	}{
+		{
+			name: "others fail",
+			others: func(w http.ResponseWriter, _ *http.Request) {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			},
+		},
+		{
+			name: "others have no offers",
+			others: func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "text/xml")
+				w.Write(webWunderNoOffers)
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cache := &memoryNegativeCache{entries: make(map[string]negativeEntry)}
+			previous := NegativeCacheInstance
+			NegativeCacheInstance = cache
+			t.Cleanup(func() { NegativeCacheInstance = previous })
+
+			stub := newConformanceStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				if !bytes.Contains(body, []byte(">FIBER<")) {
+					tc.others(w, r)
+					return
+				}
+				w.Header().Set("Content-Type", "text/xml")
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write(webWunderFault)
+			}))
+			defer stub.close()
+			service := OfferServiceImpl{providers: []InternetProviderAPI{(&WebWunderApi{}).withTransport(stub)}}
+
+			if status := lookupStatus(t, service); status.Status != domain.PROVIDER_ERROR {
+				t.Errorf("lookup has status %s, want %s", status.Status, domain.PROVIDER_ERROR)
+			}
+			if len(cache.entries) > 0 {
+				t.Errorf("stored %v, a single rejected request must not hide the provider", cache.entries)
+			}
+		})
+	}
+}
+
+func TestProviderStatusTrackerAddressRejected(t *testing.T) {
+	rejected := &ProviderError{Kind: ADDRESS_REJECTED}
+	serverFault := &ProviderError{Kind: SERVER_FAULT}
+
+	for _, tc := range []struct {
+		name     string
+		requests int64
+		errors   []error
+		want     bool
+	}{
+		{name: "every request rejected", requests: 2, errors: []error{rejected, rejected}, want: true},
+		{name: "one of several requests rejected", requests: 2, errors: []error{rejected}},
+		{name: "rejection last", requests: 2, errors: []error{serverFault, rejected}},
+		{name: "rejection first", requests: 2, errors: []error{rejected, serverFault}},
+		{name: "error without kind", requests: 2, errors: []error{rejected, context.DeadlineExceeded}},
+		{name: "no errors", requests: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tracker := newProviderStatusTracker("Provider", discardPublisher{})
+			tracker.requests.Store(tc.requests)
+			for _, err := range tc.errors {
+				tracker.recordError(err)
+			}
+			if got := tracker.addressRejected(); got != tc.want {
+				t.Errorf("addressRejected is %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
@@ -2,53 +2,166 @@ package service
 
 import (
 	"context"
+	"errors"
 	"server/domain"
 	"server/utils"
 	"sync"
 	"time"
 )
 
-type OfferServiceImpl struct{}
+type OfferServiceImpl struct {
+	// the providers to query, nil for all registered providers
+	providers []InternetProviderAPI
+}
 
-var providers = []InternetProviderAPI{
-	&ByteMeApi{},
-	&PingPerfectApi{},
+var registeredProviders = []InternetProviderAPI{
+	byteMeProvider,
+	pingPerfectProvider,
 	&ServusSpeedApi{},
 	&VerbyndichAPI{},
 	&WebWunderApi{},
 }
 
-func (service OfferServiceImpl) FetchOffersStream(ctx context.Context, address domain.Address) (*utils.PubSubChannel[domain.Offer], <-chan error) {
+// OfferStream is the result of a lookup, the status channel is closed together with the offers channel
+type OfferStream struct {
+	Offers   *utils.Broadcaster[domain.Offer]
+	Errors   <-chan error
+	Statuses <-chan domain.ProviderStatus
+	// false if providers were skipped or filters were evaluated upstream, the offers are then not all offers for the address
+	Complete bool
+	// providers which were not queried as their budget is nearly used up, their cached offers are still valid
+	CacheOnly map[string]bool
+}
+
+// LookupOptions tune a single lookup
+type LookupOptions struct {
+	// accept partial results after a short deadline instead of waiting for slow providers
+	Fast bool
+	// nobody waits for the lookup, e.g. cache warming, its calls give way to interactive lookups
+	Background bool
+}
+
+// FetchOffersStream queries all providers in parallel. Providers excluded by the filter or without offers of the
+// filtered connection type are skipped and filter options
+// a provider supports are passed upstream, the stream may therefore contain offers which do not match the filter.
+// Every provider gets a timeout derived from its recent latency, in fast mode at most the fast mode deadline.
+// Providers whose call budget is nearly used up are not queried, see OfferStream.CacheOnly, neither are providers
+// which recently had no offers for the address or rejected it. The upstream calls of all lookups take turns by
+// address in the outbound scheduler.
+// Besides the offers and errors, the status of every provider is sent once it finished
+func (service OfferServiceImpl) FetchOffersStream(ctx context.Context, address domain.Address, filter domain.OfferFilter, options LookupOptions) OfferStream {
 	// Create a parent context with the API timeout as a control mechanism
 	// We derive from the incoming context so that client disconnects are properly propagated
 	timeoutCtx, timeoutCancel := context.WithTimeout(ctx, time.Duration(utils.Cfg.Server.ApiTimeoutSec)*time.Second)
 
+	priority := utils.PRIORITY_INTERACTIVE
+	if options.Background {
+		priority = utils.PRIORITY_BACKGROUND
+	}
+	addressHash := domain.GetHashByAddress(address)
+	timeoutCtx = withSchedule(timeoutCtx, priority, addressHash)
+
 	// Create a done channel to signal completion
-	offersChannel := utils.NewPubSubChannel[domain.Offer]()
+	// consumers which subscribe late still receive all offers, slow consumers slow down the providers
+	offersChannel := utils.NewBroadcaster[domain.Offer](utils.BroadcasterOptions{
+		BufferSize: utils.Cfg.Server.OfferStreamBuffer,
+		Overflow:   utils.OVERFLOW_BLOCK,
+		Replay:     utils.REPLAY_ALL,
+	})
 	errChannel := make(chan error)
+	// buffered, so finished providers never wait for the status to be streamed
+	providers := service.providers
+	if providers == nil {
+		providers = registeredProviders
+	}
+	statusChannel := make(chan domain.ProviderStatus, len(providers))
 
 	var wg sync.WaitGroup
+	// fast lookups give up on slow providers, their offers are not all offers for the address
+	complete := !options.Fast
+
+	providerNames := make([]string, 0, len(providers))
+	for _, provider := range providers {
+		providerNames = append(providerNames, provider.GetProviderName())
+	}
+	noCoverage := negativeResults(ctx, addressHash, providerNames)
+	cacheOnly := cacheOnlyProviders(ctx, providerNames)
 
 	// Start goroutines for each provider
 	for _, provider := range providers {
+		excludedByFilter := filter.Provider != nil && *filter.Provider != "" && *filter.Provider != provider.GetProviderName()
+		if excludedByFilter || provider.GetFilterCapabilities().excludes(filter) {
+			complete = false
+			statusChannel <- domain.ProviderStatus{Provider: provider.GetProviderName(), Status: domain.PROVIDER_SKIPPED}
+			continue
+		}
+		// the provider has no offers to lose, the lookup stays complete
+		if reason, ok := noCoverage[provider.GetProviderName()]; ok {
+			negativeCacheCounter.Inc(provider.GetProviderName() + ":" + NEGATIVE_HIT)
+			statusChannel <- noCoverageStatus(provider.GetProviderName(), reason)
+			continue
+		}
+		if cacheOnly[provider.GetProviderName()] {
+			cacheOnlyCounter.Inc(provider.GetProviderName())
+			statusChannel <- domain.ProviderStatus{Provider: provider.GetProviderName(), Status: domain.PROVIDER_CACHE_ONLY}
+			continue
+		}
+
+		upstreamFilter := provider.GetFilterCapabilities().upstreamFilter(filter)
+		if !upstreamFilter.IsEmpty() {
+			complete = false
+		}
+
 		wg.Add(1)
 		go func(p InternetProviderAPI) {
 			defer wg.Done()
 
 			// Create a provider-specific context derived from the timeout context
 			// This ensures proper propagation of cancellation
-			providerCtx, providerCancel := context.WithCancel(timeoutCtx)
+			timeout := providerTimeoutsInstance.lookupTimeout(p.GetProviderName(), options.Fast)
+			providerCtx, providerCancel := context.WithTimeout(timeoutCtx, timeout)
 			defer providerCancel()
 
-			// Also monitor the original context for client disconnects
+			// errors are counted for the status of the provider before they are passed on
+			tracker := newProviderStatusTracker(p.GetProviderName(), offersChannel)
+			providerErrChannel := make(chan error)
+			errorsForwarded := make(chan struct{})
 			go func() {
-				<-ctx.Done()
-				// The original request context was canceled (e.g., client disconnected)
-				providerCancel()
+				defer close(errorsForwarded)
+				for err := range providerErrChannel {
+					tracker.recordError(err)
+					select {
+					case <-timeoutCtx.Done():
+					case errChannel <- err:
+					}
+				}
 			}()
 
 			// Call the streaming method for each provider
-			p.GetOffersStream(providerCtx, address, offersChannel, errChannel)
+			// offers are validated before they reach the stream, invalid ones end up in the quarantine
+			start := time.Now()
+			validator := newValidatingPublisher(p.GetProviderName(), tracker)
+			// the requests are counted to tell a rejected address apart from a single rejected request, the schema
+			// drift of all responses is reported once
+			lookupCtx, reportDrift := withSchemaDrift(tracker.countRequests(providerCtx))
+			p.GetOffersStream(lookupCtx, address, upstreamFilter, validator, providerErrChannel)
+			reportDrift()
+
+			// cancelled lookups and fast mode deadlines say nothing about the latency of the provider
+			ctxErr := providerCtx.Err()
+			if ctxErr == nil || (errors.Is(ctxErr, context.DeadlineExceeded) && !options.Fast) {
+				providerTimeoutsInstance.observe(p.GetProviderName(), time.Since(start))
+			}
+
+			close(providerErrChannel)
+			<-errorsForwarded
+			status := tracker.status(ctxErr)
+			status.TimeoutMs = timeout.Milliseconds()
+			// a filtered or interrupted lookup without offers does not show that the provider has none
+			if ctxErr == nil && upstreamFilter.IsEmpty() {
+				storeNegativeResult(providerCtx, addressHash, status, tracker.addressRejected(), validator.quarantined.Load())
+			}
+			statusChannel <- status
 		}(provider)
 	}
 
@@ -59,11 +172,18 @@ func (service OfferServiceImpl) FetchOffersStream(ctx context.Context, address d
 		// Signal that all providers are done
 		offersChannel.Close()
 		close(errChannel)
+		close(statusChannel)
 
 		// Cleanup
 		timeoutCancel()
 	}()
 
-	// Return the done channel so the caller can wait for completion
-	return offersChannel, errChannel
+	// Return the channels so the caller can wait for completion
+	return OfferStream{
+		Offers:    offersChannel,
+		Errors:    errChannel,
+		Statuses:  statusChannel,
+		Complete:  complete,
+		CacheOnly: cacheOnly,
+	}
 }
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"server/domain"
+	"slices"
+	"sync/atomic"
+	"testing"
+)
+
+// capabilityProvider has no offers, it only records whether it was queried
+type capabilityProvider struct {
+	name         string
+	capabilities FilterCapabilities
+	queried      *atomic.Bool
+}
+
+func (p *capabilityProvider) GetOffersStream(context.Context, domain.Address, domain.OfferFilter, OfferPublisher, chan<- error) {
+	p.queried.Store(true)
+}
+
+func (p *capabilityProvider) GetProviderName() string {
+	return p.name
+}
+
+func (p *capabilityProvider) GetFilterCapabilities() FilterCapabilities {
+	return p.capabilities
+}
+
+func (p *capabilityProvider) withTransport(http.RoundTripper) InternetProviderAPI {
+	return p
+}
+
+func TestFetchSkipsProvidersByCapabilities(t *testing.T) {
+	fiber := domain.FIBER
+	cable := domain.CABLE
+	empty := domain.ConnectionType("")
+
+	for _, tc := range []struct {
+		name           string
+		connectionType *domain.ConnectionType
+		// providers which are skipped, out of DSL only, DSL and fiber, and unknown
+		skipped  []string
+		complete bool
+	}{
+		{name: "no filter", complete: true},
+		{name: "empty connection type", connectionType: &empty, complete: true},
+		{name: "fiber", connectionType: &fiber, skipped: []string{"DSL only"}},
+		{name: "cable", connectionType: &cable, skipped: []string{"DSL only", "DSL and fiber"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// the providers have no offers, the negative results must not carry over to the next lookup
+			previous := NegativeCacheInstance
+			NegativeCacheInstance = &memoryNegativeCache{entries: make(map[string]negativeEntry)}
+			t.Cleanup(func() { NegativeCacheInstance = previous })
+
+			providers := []*capabilityProvider{
+				{name: "DSL only", capabilities: FilterCapabilities{OfferedConnectionTypes: []domain.ConnectionType{domain.DSL}}},
+				{name: "DSL and fiber", capabilities: FilterCapabilities{OfferedConnectionTypes: []domain.ConnectionType{domain.DSL, domain.FIBER}}},
+				{name: "unknown"},
+			}
+			service := OfferServiceImpl{}
+			for _, provider := range providers {
+				provider.queried = &atomic.Bool{}
+				service.providers = append(service.providers, provider)
+			}
+
+			stream := service.FetchOffersStream(context.Background(), conformanceAddress, domain.OfferFilter{ConnectionType: tc.connectionType}, LookupOptions{})
+			go func() {
+				for range stream.Errors {
+				}
+			}()
+			statuses := make(map[string]domain.ProviderStatusType)
+			for status := range stream.Statuses {
+				statuses[status.Provider] = status.Status
+			}
+
+			for _, provider := range providers {
+				skipped := slices.Contains(tc.skipped, provider.name)
+				if skipped != (statuses[provider.name] == domain.PROVIDER_SKIPPED) {
+					t.Errorf("%s has status %s", provider.name, statuses[provider.name])
+				}
+				if skipped == provider.queried.Load() {
+					t.Errorf("%s was queried: %t", provider.name, provider.queried.Load())
+				}
+			}
+			if stream.Complete != tc.complete {
+				t.Errorf("lookup is complete: %t, want %t", stream.Complete, tc.complete)
+			}
+		})
+	}
+}
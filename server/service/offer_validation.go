@@ -0,0 +1,108 @@
+package service
+
+import (
+	"server/domain"
+	"server/utils"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxQuarantinedOffersPerProvider bounds the memory used by the quarantine, older entries are dropped first
+const maxQuarantinedOffersPerProvider = 100
+
+type QuarantinedOffer struct {
+	Offer     domain.Offer `json:"offer"`
+	Reason    string       `json:"reason"`
+	Timestamp int64        `json:"timestamp"`
+}
+
+type offerQuarantine struct {
+	mu      sync.Mutex
+	entries map[string][]QuarantinedOffer
+	counts  map[string]int64
+}
+
+var (
+	OfferQuarantineInstance = &offerQuarantine{
+		entries: make(map[string][]QuarantinedOffer),
+		counts:  make(map[string]int64),
+	}
+)
+
+// Add stores an invalid offer together with the reason it was rejected
+func (q *offerQuarantine) Add(provider string, offer domain.Offer, reason error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entries := append(q.entries[provider], QuarantinedOffer{
+		Offer:     offer,
+		Reason:    reason.Error(),
+		Timestamp: time.Now().Unix(),
+	})
+	if len(entries) > maxQuarantinedOffersPerProvider {
+		entries = entries[len(entries)-maxQuarantinedOffersPerProvider:]
+	}
+	q.entries[provider] = entries
+	q.counts[provider]++
+}
+
+// Entries returns the most recent quarantined offers per provider, filtered by provider if it is not empty
+func (q *offerQuarantine) Entries(provider string) map[string][]QuarantinedOffer {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	result := make(map[string][]QuarantinedOffer)
+	for p, entries := range q.entries {
+		if provider != "" && p != provider {
+			continue
+		}
+		result[p] = append([]QuarantinedOffer(nil), entries...)
+	}
+
+	return result
+}
+
+// Counts returns the total number of quarantined offers per provider since startup
+func (q *offerQuarantine) Counts() map[string]int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	result := make(map[string]int64, len(q.counts))
+	for p, count := range q.counts {
+		result[p] = count
+	}
+
+	return result
+}
+
+var quarantinedOffersCounter = utils.NewCounterVec("offers_quarantined_total")
+
+// validatingPublisher sits between a provider adapter and the offer stream and only lets valid offers pass
+type validatingPublisher struct {
+	provider string
+	next     OfferPublisher
+	// offers rejected in this lookup
+	quarantined atomic.Int64
+}
+
+func newValidatingPublisher(provider string, next OfferPublisher) *validatingPublisher {
+	return &validatingPublisher{
+		provider: provider,
+		next:     next,
+	}
+}
+
+func (p *validatingPublisher) Publish(offer domain.Offer) {
+	if err := offer.Validate(); err != nil {
+		log.WithError(err).WithField("provider", p.provider).Warnf("Quarantined invalid offer %s", offer.ProductName)
+		OfferQuarantineInstance.Add(p.provider, offer, err)
+		quarantinedOffersCounter.Inc(p.provider)
+		p.quarantined.Add(1)
+		return
+	}
+
+	p.next.Publish(offer)
+}
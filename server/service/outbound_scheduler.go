@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"server/utils"
+	"sync"
+	"time"
+)
+
+// outboundScheduler runs all upstream provider calls, at most OUTBOUND_MAX_CONCURRENCY at once
+var outboundScheduler = sync.OnceValue(func() *utils.Scheduler {
+	return utils.NewScheduler(utils.Cfg.Scheduler.MaxConcurrency)
+})
+
+type scheduleContextKey struct{}
+
+type scheduleSpec struct {
+	priority utils.Priority
+	// calls of the same key queue behind each other, other keys take turns with them
+	key string
+}
+
+// withSchedule sets the priority and the fair queuing key of the provider calls made with the context
+func withSchedule(ctx context.Context, priority utils.Priority, key string) context.Context {
+	return context.WithValue(ctx, scheduleContextKey{}, scheduleSpec{priority: priority, key: key})
+}
+
+// OutboundSchedulerStats returns the queue depth, running calls and wait times by priority
+func OutboundSchedulerStats() utils.SchedulerStats {
+	return outboundScheduler().Stats()
+}
+
+// schedulingTransport waits for a slot of the outbound scheduler before a call is sent, the slot is held until the
+// response body is read to the end or closed, but at most OUTBOUND_MAX_SLOT_HOLD_MILLI. A slow reader, e.g. a stream
+// to a slow client, would otherwise keep the slot and starve the other calls. Calls without a schedule are
+// interactive and share one key
+type schedulingTransport struct {
+	next http.RoundTripper
+}
+
+func (t *schedulingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	spec, ok := req.Context().Value(scheduleContextKey{}).(scheduleSpec)
+	if !ok {
+		spec = scheduleSpec{priority: utils.PRIORITY_INTERACTIVE}
+	}
+
+	slot, err := outboundScheduler().Acquire(req.Context(), spec.priority, spec.key)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req.WithContext(slot.Context()))
+	if err != nil {
+		slot.Release()
+		if errors.Is(context.Cause(slot.Context()), utils.ErrPreempted) {
+			// retried once interactive calls left a slot
+			return nil, fmt.Errorf("%w: %w", utils.ErrPreempted, err)
+		}
+		return nil, err
+	}
+
+	body := &releaseOnClose{ReadCloser: resp.Body, slot: slot}
+	body.holdTimer = time.AfterFunc(time.Duration(utils.Cfg.Scheduler.MaxSlotHoldMilli)*time.Millisecond, slot.Detach)
+	resp.Body = body
+	return resp, nil
+}
+
+// releaseOnClose frees the slot once the body is read to the end, the context of the call ends when it is closed
+type releaseOnClose struct {
+	io.ReadCloser
+	slot      *utils.Slot
+	holdTimer *time.Timer
+}
+
+func (r *releaseOnClose) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil {
+		r.holdTimer.Stop()
+		r.slot.Detach()
+	}
+	return n, err
+}
+
+func (r *releaseOnClose) Close() error {
+	err := r.ReadCloser.Close()
+	r.holdTimer.Stop()
+	r.slot.Release()
+	return err
+}
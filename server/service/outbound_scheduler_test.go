@@ -0,0 +1,82 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"server/utils"
+	"testing"
+	"time"
+)
+
+func TestSchedulingTransportFreesSlot(t *testing.T) {
+	holdMilli := utils.Cfg.Scheduler.MaxSlotHoldMilli
+	utils.Cfg.Scheduler.MaxSlotHoldMilli = 50
+	t.Cleanup(func() { utils.Cfg.Scheduler.MaxSlotHoldMilli = holdMilli })
+
+	// the second half of the body is sent once the test allows it
+	resume := make(chan struct{})
+	stub := newConformanceStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "first")
+		if r.URL.Path == "/slow" {
+			w.(http.Flusher).Flush()
+			<-resume
+		}
+		fmt.Fprint(w, " second")
+	}))
+	defer stub.close()
+	transport := &schedulingTransport{next: stub}
+
+	for _, tc := range []struct {
+		name string
+		path string
+	}{
+		{name: "body read to the end", path: "/fast"},
+		{name: "slow reader", path: "/slow"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			before := outboundScheduler().Stats().Running
+			req, err := http.NewRequest(http.MethodGet, "http://provider.test"+tc.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if running := outboundScheduler().Stats().Running; running != before+1 {
+				t.Fatalf("%d calls are running, want %d", running, before+1)
+			}
+
+			if tc.path == "/slow" {
+				// the reader stalls longer than a slot may be held
+				waitRunning(t, before)
+				close(resume)
+			}
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("reading the body failed, the call must go on without its slot: %v", err)
+			}
+			if string(body) != "first second" {
+				t.Errorf("body is %q", body)
+			}
+			// the body is not closed yet
+			waitRunning(t, before)
+		})
+	}
+}
+
+// waitRunning waits until n calls hold a slot of the outbound scheduler
+func waitRunning(t *testing.T, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for outboundScheduler().Stats().Running != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d calls are running, want %d", outboundScheduler().Stats().Running, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,180 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"server/utils"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// provider credentials, loaded by utils.LoadConfig from env vars, *_FILE or SECRETS_DIR.
+// Credentials of declarative providers are registered by their definition
+var (
+	verbynDichApiKey          = utils.NewCredential("VERBYNDICH_API_KEY", true)
+	webWunderApiKey           = utils.NewCredential("WEBWUNDER_API_KEY", true)
+	servusSpeedUsername       = utils.NewCredential("SERVUSSPEED_USERNAME", true)
+	servusSpeedPassword       = utils.NewCredential("SERVUSSPEED_PASSWORD", true)
+	verbynDichAuth            = queryKeyAuth{param: "apiKey", credential: verbynDichApiKey}
+	webWunderAuth             = headerKeyAuth{header: "X-Api-Key", credential: webWunderApiKey}
+	servusSpeedAuth           = basicAuth{username: servusSpeedUsername, password: servusSpeedPassword}
+	credentialRotationCounter = utils.NewCounterVec("provider_credential_rotations_total")
+)
+
+// providerAuth authenticates a request with one key of a rotating credential
+type providerAuth interface {
+	rotatingCredential() *utils.Credential
+	apply(req *http.Request, key utils.Secret) error
+}
+
+// headerKeyAuth sends the key in a header
+type headerKeyAuth struct {
+	header     string
+	credential *utils.Credential
+}
+
+func (a headerKeyAuth) rotatingCredential() *utils.Credential {
+	return a.credential
+}
+
+func (a headerKeyAuth) apply(req *http.Request, key utils.Secret) error {
+	req.Header.Set(a.header, key.Reveal())
+	return nil
+}
+
+// queryKeyAuth sends the key as query parameter, it is added per attempt so the key never ends up in a stored URL
+type queryKeyAuth struct {
+	param      string
+	credential *utils.Credential
+}
+
+func (a queryKeyAuth) rotatingCredential() *utils.Credential {
+	return a.credential
+}
+
+func (a queryKeyAuth) apply(req *http.Request, key utils.Secret) error {
+	q := req.URL.Query()
+	q.Set(a.param, key.Reveal())
+	req.URL.RawQuery = q.Encode()
+	return nil
+}
+
+// basicAuth rotates the password, the username is expected to stay the same
+type basicAuth struct {
+	username *utils.Credential
+	password *utils.Credential
+}
+
+func (a basicAuth) rotatingCredential() *utils.Credential {
+	return a.password
+}
+
+func (a basicAuth) apply(req *http.Request, key utils.Secret) error {
+	req.SetBasicAuth(a.username.Current().Reveal(), key.Reveal())
+	return nil
+}
+
+// signatureAuth signs timestamp and body with HMAC-SHA256, the secret is rotated.
+// The timestamp is taken from the estimated clock of the provider
+type signatureAuth struct {
+	clientId *utils.Credential
+	secret   *utils.Credential
+	clock    *serverClock
+}
+
+func (a signatureAuth) rotatingCredential() *utils.Credential {
+	return a.secret
+}
+
+func (a signatureAuth) apply(req *http.Request, key utils.Secret) error {
+	var body []byte
+	if req.GetBody != nil {
+		reader, err := req.GetBody()
+		if err != nil {
+			return fmt.Errorf("failed to read request body for signature: %w", err)
+		}
+		body, err = io.ReadAll(reader)
+		if err != nil {
+			return fmt.Errorf("failed to read request body for signature: %w", err)
+		}
+	}
+
+	timestamp := strconv.FormatInt(a.clock.now().Unix(), 10)
+	req.Header.Set("X-Client-Id", a.clientId.Current().Reveal())
+	req.Header.Set("X-Timestamp", timestamp)
+	req.Header.Set("X-Signature", generateSignature(body, timestamp, key.Reveal()))
+	return nil
+}
+
+// generateSignature returns the hex encoded HMAC-SHA256 of timestamp and body separated by a colon
+func generateSignature(requestBody []byte, timestamp, signatureSecret string) string {
+	h := hmac.New(sha256.New, []byte(signatureSecret))
+	h.Write([]byte(timestamp + ":" + string(requestBody)))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// isAuthRejected reports whether the provider rejected the credential
+func isAuthRejected(statusCode int) bool {
+	return statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden
+}
+
+// doAuthenticated sends the request with the keys of the credential in order. During rotation a rejected key falls
+// back to the next one, the key which was accepted is tried first by later requests
+func doAuthenticated(client *http.Client, req *http.Request, auth providerAuth) (*http.Response, error) {
+	credential := auth.rotatingCredential()
+	keys := credential.Keys()
+	if len(keys) == 0 {
+		return nil, utils.NonRetryable(fmt.Errorf("credential %s is not set", credential.Name()))
+	}
+
+	for i, key := range keys {
+		attempt := req
+		if i > 0 {
+			attempt = req.Clone(req.Context())
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				attempt.Body = body
+			}
+		}
+		if err := auth.apply(attempt, key); err != nil {
+			return nil, err
+		}
+
+		resp, err := client.Do(attempt)
+		if err != nil {
+			return nil, err
+		}
+		if isAuthRejected(resp.StatusCode) && i < len(keys)-1 {
+			log.WithField("credential", credential.Name()).WithField("status", resp.StatusCode).Warn("Credential key rejected, trying the next key")
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			continue
+		}
+
+		if !isAuthRejected(resp.StatusCode) {
+			if i > 0 {
+				credentialRotationCounter.Inc(credential.Name())
+			}
+			credential.Accepted(key)
+		}
+		return resp, nil
+	}
+
+	// not reached, the last key always returns
+	return nil, fmt.Errorf("no key of credential %s was accepted", credential.Name())
+}
+
+// readErrorBody reads a non-200 response body for error messages, credential values echoed by the provider are removed
+func readErrorBody(resp *http.Response) string {
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	return utils.RedactSecrets(string(bodyBytes))
+}
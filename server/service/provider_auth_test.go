@@ -0,0 +1,127 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"server/utils"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// rotatingCredential registers a credential with a current and a next key
+func rotatingCredential(t *testing.T, name string) *utils.Credential {
+	t.Setenv(name, "old-key")
+	t.Setenv(name+"_NEXT", "new-key")
+	credential := utils.NewCredential(name, false)
+	if err := utils.LoadCredentials(); err != nil {
+		t.Fatal(err)
+	}
+	return credential
+}
+
+func TestDoAuthenticatedRotatesRejectedKeys(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// status by key, keys which are not listed are accepted
+		statuses map[string]int
+		// keys sent by the first and by a second request
+		wantFirst  []string
+		wantSecond []string
+		wantStatus int
+		rotated    int64
+	}{
+		{name: "current key accepted", wantFirst: []string{"old-key"}, wantSecond: []string{"old-key"}, wantStatus: http.StatusOK},
+		{
+			name:       "unauthorized",
+			statuses:   map[string]int{"old-key": http.StatusUnauthorized},
+			wantFirst:  []string{"old-key", "new-key"},
+			wantSecond: []string{"new-key"},
+			wantStatus: http.StatusOK,
+			rotated:    1,
+		},
+		{
+			name:       "forbidden",
+			statuses:   map[string]int{"old-key": http.StatusForbidden},
+			wantFirst:  []string{"old-key", "new-key"},
+			wantSecond: []string{"new-key"},
+			wantStatus: http.StatusOK,
+			rotated:    1,
+		},
+		{
+			name:       "every key rejected",
+			statuses:   map[string]int{"old-key": http.StatusUnauthorized, "new-key": http.StatusForbidden},
+			wantFirst:  []string{"old-key", "new-key"},
+			wantSecond: []string{"old-key", "new-key"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			// only a rejected credential is rotated, other failures are returned as they are
+			name:       "server error",
+			statuses:   map[string]int{"old-key": http.StatusInternalServerError},
+			wantFirst:  []string{"old-key"},
+			wantSecond: []string{"old-key"},
+			wantStatus: http.StatusInternalServerError,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			name := "AUTH_TEST_" + strings.ToUpper(strings.ReplaceAll(tc.name, " ", "_"))
+			auth := headerKeyAuth{header: "X-Api-Key", credential: rotatingCredential(t, name)}
+			rotations := credentialRotationCounter.Get(name)
+
+			var sent []string
+			client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				key := req.Header.Get("X-Api-Key")
+				sent = append(sent, key)
+				// every attempt carries the whole body
+				if body, _ := io.ReadAll(req.Body); string(body) != "query" {
+					t.Errorf("attempt with %s sent the body %q", key, body)
+				}
+				status := http.StatusOK
+				if s, ok := tc.statuses[key]; ok {
+					status = s
+				}
+				return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(""))}, nil
+			})}
+
+			for _, want := range [][]string{tc.wantFirst, tc.wantSecond} {
+				sent = nil
+				req, err := http.NewRequest(http.MethodPost, "http://provider.test/offers", strings.NewReader("query"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp, err := doAuthenticated(client, req, auth)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+
+				if !slices.Equal(sent, want) {
+					t.Errorf("keys sent are %q, want %q", sent, want)
+				}
+				if resp.StatusCode != tc.wantStatus {
+					t.Errorf("status is %d, want %d", resp.StatusCode, tc.wantStatus)
+				}
+			}
+			if got := credentialRotationCounter.Get(name) - rotations; got != tc.rotated {
+				t.Errorf("rotations were counted %d times, want %d", got, tc.rotated)
+			}
+		})
+	}
+}
+
+func TestDoAuthenticatedWithoutKeys(t *testing.T) {
+	auth := headerKeyAuth{header: "X-Api-Key", credential: utils.NewCredential("AUTH_TEST_NOT_SET", false)}
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		t.Error("a request without a key was sent")
+		return okResponse(""), nil
+	})}
+
+	req, err := http.NewRequest(http.MethodGet, "http://provider.test/offers", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := doAuthenticated(client, req, auth); err == nil || utils.IsRetryable(err) {
+		t.Errorf("request without a key failed with %v, want a non retryable error", err)
+	}
+}
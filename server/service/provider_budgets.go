@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"server/domain"
+	"server/utils"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// outcomes of providerCallsCounter, e.g. "VerbynDich:throttled"
+const (
+	// the call was sent upstream
+	CALL_SENT = "sent"
+	// the call waited for the rate limit of the provider
+	CALL_THROTTLED = "throttled"
+	// the call was refused as the budget of the provider is used up
+	CALL_REJECTED = "rejected"
+)
+
+var (
+	providerCallsCounter = utils.NewCounterVec("provider_calls_total")
+	// lookups which did not query a provider as its budget is nearly used up, by provider
+	cacheOnlyCounter = utils.NewCounterVec("provider_cache_only_total")
+)
+
+// ErrCallBudgetExhausted is returned for calls after the daily or monthly budget of a provider is used up
+var ErrCallBudgetExhausted = errors.New("call budget exhausted")
+
+// CallCounter counts the upstream calls of every provider, all replicas have to share the counts
+type CallCounter interface {
+	// Record counts a call unless the counts of the current day or month reached their budget, a zero budget is
+	// unlimited. It returns the counts including the call and whether the call was counted
+	Record(ctx context.Context, provider string, budget domain.CallCount) (domain.CallCount, bool, error)
+	Counts(ctx context.Context, providers []string) (map[string]domain.CallCount, error)
+}
+
+// CallCounterInstance enforces the budgets of PROVIDER_DAILY_BUDGETS and PROVIDER_MONTHLY_BUDGETS, without it the
+// calls are not counted and the budgets are ignored
+var CallCounterInstance CallCounter
+
+// ProviderUsage is the outbound traffic of a provider against its limits, a zero limit or budget means unlimited
+type ProviderUsage struct {
+	Provider      string           `json:"provider"`
+	RatePerSec    float64          `json:"ratePerSec"`
+	Burst         int              `json:"burst"`
+	Calls         domain.CallCount `json:"calls"`
+	DailyBudget   int64            `json:"dailyBudget"`
+	MonthlyBudget int64            `json:"monthlyBudget"`
+	// lookups serve the provider from cache until the budget resets
+	CacheOnly bool `json:"cacheOnly"`
+}
+
+var (
+	rateLimitersMu sync.Mutex
+	// token buckets by provider, nil for providers without a rate limit
+	rateLimiters = make(map[string]*utils.TokenBucket)
+)
+
+func rateLimiter(provider string) *utils.TokenBucket {
+	rateLimitersMu.Lock()
+	defer rateLimitersMu.Unlock()
+
+	limiter, ok := rateLimiters[provider]
+	if !ok {
+		if rate := utils.Cfg.ProviderLimits.RatePerSec[provider]; rate > 0 {
+			burst, ok := utils.Cfg.ProviderLimits.Burst[provider]
+			if !ok {
+				burst = int(math.Ceil(rate))
+			}
+			limiter = utils.NewTokenBucket(rate, burst)
+		}
+		rateLimiters[provider] = limiter
+	}
+
+	return limiter
+}
+
+func hasBudget(provider string) bool {
+	return utils.Cfg.ProviderLimits.DailyBudget[provider] > 0 || utils.Cfg.ProviderLimits.MonthlyBudget[provider] > 0
+}
+
+// inReserve reports whether the count reached the budget minus its reserve
+func inReserve(count int64, budget int64) bool {
+	return budget > 0 && float64(count) >= float64(budget)*(1-utils.Cfg.ProviderLimits.BudgetReservePercent/100)
+}
+
+// nearlyExhausted reports whether only the reserve of a budget of the provider is left
+func nearlyExhausted(provider string, count domain.CallCount) bool {
+	return inReserve(count.Daily, utils.Cfg.ProviderLimits.DailyBudget[provider]) ||
+		inReserve(count.Monthly, utils.Cfg.ProviderLimits.MonthlyBudget[provider])
+}
+
+// providerBudget returns the daily and monthly budget of the provider, zero for unlimited
+func providerBudget(provider string) domain.CallCount {
+	return domain.CallCount{
+		Daily:   utils.Cfg.ProviderLimits.DailyBudget[provider],
+		Monthly: utils.Cfg.ProviderLimits.MonthlyBudget[provider],
+	}
+}
+
+// budgetedCounts returns the call counts of the providers with a budget. Without a counter or if the counts are
+// unavailable no counts are returned, the providers are then queried as usual
+func budgetedCounts(ctx context.Context, providerNames []string) map[string]domain.CallCount {
+	var budgeted []string
+	for _, provider := range providerNames {
+		if hasBudget(provider) {
+			budgeted = append(budgeted, provider)
+		}
+	}
+	if CallCounterInstance == nil || len(budgeted) == 0 {
+		return nil
+	}
+
+	counts, err := CallCounterInstance.Counts(ctx, budgeted)
+	if err != nil {
+		log.WithError(err).Warn("Failed to get provider call counts, budgets are not checked")
+		return nil
+	}
+
+	return counts
+}
+
+// cacheOnlyProviders returns the providers whose budget is nearly used up, lookups only show their cached offers
+func cacheOnlyProviders(ctx context.Context, providerNames []string) map[string]bool {
+	cacheOnly := make(map[string]bool)
+	for provider, count := range budgetedCounts(ctx, providerNames) {
+		if nearlyExhausted(provider, count) {
+			cacheOnly[provider] = true
+		}
+	}
+
+	return cacheOnly
+}
+
+// ProviderUsages returns the limits and the current calls of all providers
+func ProviderUsages(ctx context.Context) []ProviderUsage {
+	names := make([]string, 0, len(registeredProviders))
+	for _, provider := range registeredProviders {
+		names = append(names, provider.GetProviderName())
+	}
+	counts := budgetedCounts(ctx, names)
+
+	usages := make([]ProviderUsage, 0, len(names))
+	for _, name := range names {
+		usage := ProviderUsage{
+			Provider:      name,
+			Calls:         counts[name],
+			DailyBudget:   utils.Cfg.ProviderLimits.DailyBudget[name],
+			MonthlyBudget: utils.Cfg.ProviderLimits.MonthlyBudget[name],
+			CacheOnly:     nearlyExhausted(name, counts[name]),
+		}
+		if limiter := rateLimiter(name); limiter != nil {
+			usage.RatePerSec = limiter.Rate()
+			usage.Burst = limiter.Burst()
+		}
+		usages = append(usages, usage)
+	}
+
+	return usages
+}
+
+// meteringTransport applies the rate limit of the provider and counts the calls it sends against its budgets. It sits
+// below the hedging, so duplicates and retries are limited and counted like any other call
+type meteringTransport struct {
+	provider string
+	next     http.RoundTripper
+}
+
+func (t *meteringTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if limiter := rateLimiter(t.provider); limiter != nil {
+		waited, err := limiter.Wait(req.Context())
+		if waited {
+			providerCallsCounter.Inc(t.provider + ":" + CALL_THROTTLED)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if CallCounterInstance != nil && hasBudget(t.provider) {
+		// the budgets are a cost control, a failing counter must not fail the lookups. Only calls which are sent are
+		// counted, rejected ones would use up the budget without any upstream traffic
+		_, counted, err := CallCounterInstance.Record(req.Context(), t.provider, providerBudget(t.provider))
+		if err != nil {
+			log.WithError(err).WithField("provider", t.provider).Warn("Failed to count provider call")
+		} else if !counted {
+			providerCallsCounter.Inc(t.provider + ":" + CALL_REJECTED)
+			return nil, utils.NonRetryable(fmt.Errorf("%s: %w", t.provider, ErrCallBudgetExhausted))
+		}
+	}
+
+	providerCallsCounter.Inc(t.provider + ":" + CALL_SENT)
+	return t.next.RoundTrip(req)
+}
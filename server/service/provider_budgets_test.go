@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"server/domain"
+	"server/utils"
+	"sync"
+	"testing"
+)
+
+// memoryCallCounter counts the calls of a single day and month
+type memoryCallCounter struct {
+	mu     sync.Mutex
+	counts map[string]domain.CallCount
+}
+
+func (c *memoryCallCounter) Record(_ context.Context, provider string, budget domain.CallCount) (domain.CallCount, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	count := c.counts[provider]
+	if (budget.Daily > 0 && count.Daily >= budget.Daily) || (budget.Monthly > 0 && count.Monthly >= budget.Monthly) {
+		return count, false, nil
+	}
+	count.Daily++
+	count.Monthly++
+	c.counts[provider] = count
+	return count, true, nil
+}
+
+func (c *memoryCallCounter) Counts(_ context.Context, providers []string) (map[string]domain.CallCount, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counts := make(map[string]domain.CallCount)
+	for _, provider := range providers {
+		counts[provider] = c.counts[provider]
+	}
+	return counts, nil
+}
+
+// withBudgets replaces the budgets and the reserve of the providers for the test
+func withBudgets(t *testing.T, daily, monthly map[string]int64, reservePercent float64) {
+	limits := utils.Cfg.ProviderLimits
+	utils.Cfg.ProviderLimits.DailyBudget = daily
+	utils.Cfg.ProviderLimits.MonthlyBudget = monthly
+	utils.Cfg.ProviderLimits.BudgetReservePercent = reservePercent
+	t.Cleanup(func() { utils.Cfg.ProviderLimits = limits })
+}
+
+func TestNearlyExhausted(t *testing.T) {
+	withBudgets(t, map[string]int64{"daily": 100}, map[string]int64{"monthly": 1000, "daily": 5000}, 10)
+
+	for _, tc := range []struct {
+		name     string
+		provider string
+		count    domain.CallCount
+		want     bool
+	}{
+		{name: "below the reserve", provider: "daily", count: domain.CallCount{Daily: 89, Monthly: 89}, want: false},
+		{name: "daily reserve reached", provider: "daily", count: domain.CallCount{Daily: 90, Monthly: 90}, want: true},
+		{name: "daily budget used up", provider: "daily", count: domain.CallCount{Daily: 100, Monthly: 100}, want: true},
+		{name: "monthly reserve reached", provider: "daily", count: domain.CallCount{Daily: 10, Monthly: 4500}, want: true},
+		{name: "only a monthly budget", provider: "monthly", count: domain.CallCount{Daily: 899, Monthly: 899}, want: false},
+		{name: "monthly reserve of a monthly budget", provider: "monthly", count: domain.CallCount{Daily: 1, Monthly: 900}, want: true},
+		{name: "no budget", provider: "unlimited", count: domain.CallCount{Daily: 1e6, Monthly: 1e6}, want: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := nearlyExhausted(tc.provider, tc.count); got != tc.want {
+				t.Errorf("nearly exhausted is %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestInReserve(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		count          int64
+		budget         int64
+		reservePercent float64
+		want           bool
+	}{
+		{name: "no reserve below the budget", count: 99, budget: 100, want: false},
+		{name: "no reserve at the budget", count: 100, budget: 100, want: true},
+		{name: "below the reserve", count: 74, budget: 100, reservePercent: 25, want: false},
+		{name: "at the reserve", count: 75, budget: 100, reservePercent: 25, want: true},
+		{name: "unlimited", count: 100, budget: 0, reservePercent: 25, want: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			withBudgets(t, nil, nil, tc.reservePercent)
+			if got := inReserve(tc.count, tc.budget); got != tc.want {
+				t.Errorf("%d of %d calls are in the reserve: %t, want %t", tc.count, tc.budget, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMeteringCountsOnlySentCalls(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		daily   int64
+		monthly int64
+		calls   int
+		// calls which were sent, and the counts after all calls
+		sent int
+		want domain.CallCount
+	}{
+		{name: "within the budget", daily: 5, calls: 3, sent: 3, want: domain.CallCount{Daily: 3, Monthly: 3}},
+		{name: "daily budget used up", daily: 2, calls: 5, sent: 2, want: domain.CallCount{Daily: 2, Monthly: 2}},
+		{name: "monthly budget used up", daily: 10, monthly: 3, calls: 5, sent: 3, want: domain.CallCount{Daily: 3, Monthly: 3}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			provider := t.Name()
+			withBudgets(t, map[string]int64{provider: tc.daily}, map[string]int64{provider: tc.monthly}, 0)
+			counter := &memoryCallCounter{counts: make(map[string]domain.CallCount)}
+			previous := CallCounterInstance
+			CallCounterInstance = counter
+			t.Cleanup(func() { CallCounterInstance = previous })
+			rejected := providerCallsCounter.Get(provider + ":" + CALL_REJECTED)
+
+			sent := 0
+			transport := &meteringTransport{provider: provider, next: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				sent++
+				return okResponse("offers"), nil
+			})}
+			for range tc.calls {
+				req, err := http.NewRequest(http.MethodGet, "http://provider.test/offers", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp, err := transport.RoundTrip(req)
+				if err != nil {
+					if !errors.Is(err, ErrCallBudgetExhausted) {
+						t.Fatalf("call failed with %v, want %v", err, ErrCallBudgetExhausted)
+					}
+					continue
+				}
+				resp.Body.Close()
+			}
+
+			if sent != tc.sent {
+				t.Errorf("%d calls were sent, want %d", sent, tc.sent)
+			}
+			if got := counter.counts[provider]; got != tc.want {
+				t.Errorf("counts are %+v, want the sent calls %+v", got, tc.want)
+			}
+			if got := providerCallsCounter.Get(provider+":"+CALL_REJECTED) - rejected; got != int64(tc.calls-tc.sent) {
+				t.Errorf("%d calls were counted as rejected, want %d", got, tc.calls-tc.sent)
+			}
+		})
+	}
+}
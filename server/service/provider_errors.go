@@ -0,0 +1,152 @@
+package service
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"server/utils"
+	"strings"
+)
+
+type ProviderErrorKind string
+
+const (
+	// the provider rejected our request, repeating it does not help
+	CLIENT_FAULT ProviderErrorKind = "CLIENT_FAULT"
+	// the provider failed to answer, the request can be repeated
+	SERVER_FAULT ProviderErrorKind = "SERVER_FAULT"
+	// the response is not what the provider specification describes
+	UNEXPECTED_RESPONSE ProviderErrorKind = "UNEXPECTED_RESPONSE"
+	// the provider does not know the address, asking again for the same address does not help
+	ADDRESS_REJECTED ProviderErrorKind = "ADDRESS_REJECTED"
+)
+
+// ProviderError is a failure reported by the provider itself, as opposed to network errors or our own bugs
+type ProviderError struct {
+	Provider string
+	Kind     ProviderErrorKind
+	Code     string
+	Message  string
+}
+
+func (e *ProviderError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("%s: %s: %s", e.Provider, e.Kind, e.Message)
+	}
+
+	return fmt.Sprintf("%s: %s %s: %s", e.Provider, e.Kind, e.Code, e.Message)
+}
+
+// Retryable implements utils.RetryableError, only server faults are worth repeating
+func (e *ProviderError) Retryable() bool {
+	return e.Kind == SERVER_FAULT
+}
+
+var providerErrorsCounter = utils.NewCounterVec("provider_errors_total")
+
+// providerErrorKind returns the kind of the provider error wrapped in err, or an empty string for other errors
+func providerErrorKind(err error) ProviderErrorKind {
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		return providerErr.Kind
+	}
+
+	return ""
+}
+
+// isAddressRejectedStatus reports whether a client error status can reject the address of a request. Rejected
+// credentials, timeouts and rate limits are client errors as well, but say nothing about the address. Whether a
+// provider uses the status for unknown addresses has to be documented by the provider
+func isAddressRejectedStatus(statusCode int) bool {
+	return statusCode >= 400 && statusCode <= 499 && !isAuthRejected(statusCode) &&
+		statusCode != http.StatusRequestTimeout && statusCode != http.StatusTooManyRequests
+}
+
+// soapFault covers SOAP 1.1 (faultcode, faultstring) and SOAP 1.2 (Code/Value, Reason/Text) faults
+type soapFault struct {
+	FaultCode   string `xml:"faultcode"`
+	FaultString string `xml:"faultstring"`
+	Code        struct {
+		Value string `xml:"Value"`
+	} `xml:"Code"`
+	Reason struct {
+		Text string `xml:"Text"`
+	} `xml:"Reason"`
+}
+
+// soapClientFaults are the fault codes of requests the provider will never accept, codes are compared without
+// namespace prefix and subcode, e.g. "soapenv:Client.Authentication" is a "Client" fault
+var soapClientFaults = map[string]bool{
+	"Client":          true,
+	"Sender":          true,
+	"VersionMismatch": true,
+	"MustUnderstand":  true,
+}
+
+// newSoapFaultError maps a SOAP fault to a typed provider error, unknown fault codes are treated as server faults
+func newSoapFaultError(provider string, fault soapFault) *ProviderError {
+	code := strings.TrimSpace(fault.FaultCode)
+	if code == "" {
+		code = strings.TrimSpace(fault.Code.Value)
+	}
+	message := strings.TrimSpace(fault.FaultString)
+	if message == "" {
+		message = strings.TrimSpace(fault.Reason.Text)
+	}
+
+	localCode := code
+	if _, local, found := strings.Cut(localCode, ":"); found {
+		localCode = local
+	}
+	localCode, _, _ = strings.Cut(localCode, ".")
+
+	kind := SERVER_FAULT
+	if soapClientFaults[localCode] {
+		kind = CLIENT_FAULT
+	}
+
+	return &ProviderError{Provider: provider, Kind: kind, Code: code, Message: message}
+}
+
+// withAddressRejection turns client faults with one of the fault codes the provider documents for addresses it does
+// not know into ADDRESS_REJECTED. Codes are compared without namespace prefix, e.g. "Client.UnknownAddress". The text of
+// a fault is never matched, a validation fault naming an address element may as well be caused by our request
+func withAddressRejection(fault *ProviderError, addressFaultCodes map[string]bool) *ProviderError {
+	if fault == nil || fault.Kind != CLIENT_FAULT {
+		return fault
+	}
+
+	code := fault.Code
+	if _, local, found := strings.Cut(code, ":"); found {
+		code = local
+	}
+	if addressFaultCodes[code] {
+		fault.Kind = ADDRESS_REJECTED
+	}
+
+	return fault
+}
+
+// parseSoapFault returns the fault of a SOAP response body, or nil if the body does not contain a fault
+func parseSoapFault(provider string, body io.Reader) *ProviderError {
+	decoder := xml.NewDecoder(body)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "Fault" {
+			continue
+		}
+
+		var fault soapFault
+		if err := decoder.DecodeElement(&fault, &start); err != nil {
+			return nil
+		}
+		return newSoapFaultError(provider, fault)
+	}
+}
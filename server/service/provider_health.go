@@ -0,0 +1,78 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+type HealthStatus string
+
+const (
+	HEALTHY  HealthStatus = "HEALTHY"
+	DEGRADED HealthStatus = "DEGRADED"
+)
+
+// HealthSignal is a single aspect of a provider's health, e.g. whether its response schema still matches ours
+type HealthSignal struct {
+	Status  HealthStatus `json:"status"`
+	Message string       `json:"message,omitempty"`
+	Since   int64        `json:"since"`
+}
+
+type providerHealth struct {
+	mu      sync.Mutex
+	signals map[string]map[string]HealthSignal
+}
+
+var (
+	ProviderHealthInstance = &providerHealth{
+		signals: make(map[string]map[string]HealthSignal),
+	}
+)
+
+// SetDegraded marks a signal of a provider as degraded with the given message
+func (h *providerHealth) SetDegraded(provider string, signal string, message string) {
+	h.set(provider, signal, DEGRADED, message)
+}
+
+// SetHealthy marks a signal of a provider as healthy
+func (h *providerHealth) SetHealthy(provider string, signal string) {
+	h.set(provider, signal, HEALTHY, "")
+}
+
+func (h *providerHealth) set(provider string, signal string, status HealthStatus, message string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.signals[provider] == nil {
+		h.signals[provider] = make(map[string]HealthSignal)
+	}
+
+	// keep the timestamp of the first transition into the current status
+	since := time.Now().Unix()
+	if current, ok := h.signals[provider][signal]; ok && current.Status == status {
+		since = current.Since
+	}
+
+	h.signals[provider][signal] = HealthSignal{
+		Status:  status,
+		Message: message,
+		Since:   since,
+	}
+}
+
+// Snapshot returns all health signals by provider and signal name
+func (h *providerHealth) Snapshot() map[string]map[string]HealthSignal {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	result := make(map[string]map[string]HealthSignal, len(h.signals))
+	for provider, signals := range h.signals {
+		result[provider] = make(map[string]HealthSignal, len(signals))
+		for name, signal := range signals {
+			result[provider][name] = signal
+		}
+	}
+
+	return result
+}
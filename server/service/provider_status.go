@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"server/domain"
+	"sync"
+	"sync/atomic"
+)
+
+// providerStatusTracker counts the valid offers, the requests and the errors of one provider during a lookup
+type providerStatusTracker struct {
+	provider string
+	next     OfferPublisher
+	// requests sent with the context returned by countRequests
+	requests atomic.Int64
+
+	mu        sync.Mutex
+	offers    int
+	errors    int
+	errorKind ProviderErrorKind
+	// errors by kind, errors without a kind are counted under the empty kind
+	errorKinds map[ProviderErrorKind]int
+}
+
+func newProviderStatusTracker(provider string, next OfferPublisher) *providerStatusTracker {
+	return &providerStatusTracker{provider: provider, next: next, errorKinds: make(map[ProviderErrorKind]int)}
+}
+
+type requestCountKey struct{}
+
+// countRequests returns a context the provider requests of the lookup are counted with, see requestCountingTransport
+func (t *providerStatusTracker) countRequests(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requestCountKey{}, &t.requests)
+}
+
+// discardRequests takes requests whose responses say nothing about the address out of the count of the lookup in ctx,
+// e.g. pages requested past the last page
+func discardRequests(ctx context.Context, n int) {
+	if requests, ok := ctx.Value(requestCountKey{}).(*atomic.Int64); ok {
+		requests.Add(-int64(n))
+	}
+}
+
+// addressRejected reports whether the provider rejected the address with every request of the lookup, a single
+// rejection among timeouts or other failures says nothing about the address
+func (t *providerStatusTracker) addressRejected() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rejected := t.errorKinds[ADDRESS_REJECTED]
+	return rejected > 0 && rejected == t.errors && int64(rejected) >= t.requests.Load()
+}
+
+// requestCountingTransport counts the requests sent in the lookup of the request context, hedges and retries included
+type requestCountingTransport struct {
+	next http.RoundTripper
+}
+
+func (t *requestCountingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if requests, ok := req.Context().Value(requestCountKey{}).(*atomic.Int64); ok {
+		requests.Add(1)
+	}
+
+	return t.next.RoundTrip(req)
+}
+
+func (t *providerStatusTracker) Publish(offer domain.Offer) {
+	t.mu.Lock()
+	t.offers++
+	t.mu.Unlock()
+
+	t.next.Publish(offer)
+}
+
+func (t *providerStatusTracker) recordError(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.errors++
+	kind := providerErrorKind(err)
+	t.errorKinds[kind]++
+	if kind != "" {
+		t.errorKind = kind
+	}
+}
+
+// status summarizes the lookup once the provider returned, ctxErr is the error of the provider context
+func (t *providerStatusTracker) status(ctxErr error) domain.ProviderStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	status := domain.ProviderStatus{
+		Provider:  t.provider,
+		Offers:    t.offers,
+		Errors:    t.errors,
+		ErrorKind: string(t.errorKind),
+	}
+
+	switch {
+	case errors.Is(ctxErr, context.DeadlineExceeded):
+		status.Status = domain.PROVIDER_TIMEOUT
+	case t.offers > 0 && t.errors > 0:
+		status.Status = domain.PROVIDER_PARTIAL
+	case t.offers > 0:
+		status.Status = domain.PROVIDER_OK
+	case t.errors > 0:
+		status.Status = domain.PROVIDER_ERROR
+	default:
+		status.Status = domain.PROVIDER_NO_OFFERS
+	}
+
+	return status
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"server/utils"
+	"sync"
+	"time"
+)
+
+const (
+	// lookups per provider the timeout is derived from
+	latencyWindowSize = 200
+	// until a provider has this many samples it gets the API timeout
+	minLatencySamples = 20
+)
+
+// providerTimeouts derives the timeout of each provider from the durations of its recent lookups, so slow providers
+// get the time they need and fast ones do not hold a lookup open for long when they hang
+type providerTimeouts struct {
+	mu        sync.Mutex
+	latencies map[string]*utils.LatencyWindow
+}
+
+var providerTimeoutsInstance = &providerTimeouts{latencies: make(map[string]*utils.LatencyWindow)}
+
+func (t *providerTimeouts) window(provider string) *utils.LatencyWindow {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	window, ok := t.latencies[provider]
+	if !ok {
+		window = utils.NewLatencyWindow(latencyWindowSize)
+		t.latencies[provider] = window
+	}
+
+	return window
+}
+
+// observe records the duration of a lookup, lookups which hit their timeout are recorded with it, so the timeout
+// grows if a provider becomes slower
+func (t *providerTimeouts) observe(provider string, duration time.Duration) {
+	t.window(provider).Add(duration)
+}
+
+// timeout returns the quantile of the recent lookups times the factor, bounded by the minimum and the API timeout
+func (t *providerTimeouts) timeout(provider string) time.Duration {
+	maxTimeout := time.Duration(utils.Cfg.Server.ApiTimeoutSec) * time.Second
+
+	window := t.window(provider)
+	if window.Count() < minLatencySamples {
+		return maxTimeout
+	}
+
+	timeout := time.Duration(float64(window.Quantile(utils.Cfg.Timeouts.Quantile)) * utils.Cfg.Timeouts.Factor)
+	minTimeout := time.Duration(utils.Cfg.Timeouts.MinMilli) * time.Millisecond
+	return min(max(timeout, minTimeout), maxTimeout)
+}
+
+// lookupTimeout is the timeout of the provider within a lookup, fast lookups give up at their deadline
+func (t *providerTimeouts) lookupTimeout(provider string, fast bool) time.Duration {
+	timeout := t.timeout(provider)
+	if fast {
+		timeout = min(timeout, fastTimeout())
+	}
+
+	return timeout
+}
+
+// fastTimeout is the deadline of lookups which accept partial results
+func fastTimeout() time.Duration {
+	return time.Duration(utils.Cfg.Timeouts.FastMilli) * time.Millisecond
+}
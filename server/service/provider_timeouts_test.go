@@ -0,0 +1,71 @@
+package service
+
+import (
+	"server/utils"
+	"testing"
+	"time"
+)
+
+func TestProviderTimeout(t *testing.T) {
+	timeouts, apiTimeout := utils.Cfg.Timeouts, utils.Cfg.Server.ApiTimeoutSec
+	t.Cleanup(func() {
+		utils.Cfg.Timeouts = timeouts
+		utils.Cfg.Server.ApiTimeoutSec = apiTimeout
+	})
+	utils.Cfg.Server.ApiTimeoutSec = 30
+	utils.Cfg.Timeouts.MinMilli = 2000
+	utils.Cfg.Timeouts.FastMilli = 3000
+
+	for _, tc := range []struct {
+		name     string
+		samples  int
+		latency  time.Duration
+		quantile float64
+		factor   float64
+		fast     bool
+		want     time.Duration
+	}{
+		{name: "too few samples", samples: minLatencySamples - 1, latency: time.Second, quantile: 0.99, factor: 2, want: 30 * time.Second},
+		{name: "quantile times factor", samples: minLatencySamples, latency: 4 * time.Second, quantile: 0.99, factor: 2, want: 8 * time.Second},
+		{name: "fractional factor", samples: 100, latency: 4 * time.Second, quantile: 0.5, factor: 1.5, want: 6 * time.Second},
+		{name: "minimum", samples: 100, latency: 100 * time.Millisecond, quantile: 0.99, factor: 2, want: 2 * time.Second},
+		{name: "API timeout", samples: 100, latency: 20 * time.Second, quantile: 0.99, factor: 2, want: 30 * time.Second},
+		{name: "fast mode below its deadline", samples: 100, latency: time.Second, quantile: 0.99, factor: 2, fast: true, want: 2 * time.Second},
+		{name: "fast mode deadline", samples: 100, latency: 4 * time.Second, quantile: 0.99, factor: 2, fast: true, want: 3 * time.Second},
+		{name: "fast mode without samples", fast: true, want: 3 * time.Second},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			utils.Cfg.Timeouts.Quantile = tc.quantile
+			utils.Cfg.Timeouts.Factor = tc.factor
+			timeouts := &providerTimeouts{latencies: make(map[string]*utils.LatencyWindow)}
+			for range tc.samples {
+				timeouts.observe("provider", tc.latency)
+			}
+
+			if got := timeouts.lookupTimeout("provider", tc.fast); got != tc.want {
+				t.Errorf("timeout is %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestProviderTimeoutFollowsQuantile(t *testing.T) {
+	timeouts := utils.Cfg.Timeouts
+	t.Cleanup(func() { utils.Cfg.Timeouts = timeouts })
+	utils.Cfg.Timeouts.Quantile = 0.9
+	utils.Cfg.Timeouts.Factor = 1
+	utils.Cfg.Timeouts.MinMilli = 0
+
+	// a single slow lookup in a hundred stays above the quantile
+	provider := &providerTimeouts{latencies: make(map[string]*utils.LatencyWindow)}
+	for i := range 100 {
+		latency := time.Second
+		if i == 50 {
+			latency = 20 * time.Second
+		}
+		provider.observe("provider", latency)
+	}
+	if got := provider.timeout("provider"); got != time.Second {
+		t.Errorf("timeout is %s, want the 90th percentile of 1s", got)
+	}
+}
@@ -0,0 +1,247 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"server/utils"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// provider traffic modes, set with PROVIDER_TRAFFIC_MODE
+const (
+	TRAFFIC_OFF    = "off"
+	TRAFFIC_RECORD = "record"
+	TRAFFIC_REPLAY = "replay"
+)
+
+const redacted = "REDACTED"
+
+// scrubbedHeaders and scrubbedQueryParams carry provider credentials and are never written to a recording
+var (
+	scrubbedHeaders     = []string{"X-Api-Key", "Authorization", "X-Signature", "X-Client-Id"}
+	scrubbedQueryParams = []string{"apiKey"}
+)
+
+// RecordedRequest is the scrubbed upstream request of a recording
+type RecordedRequest struct {
+	Method  string      `json:"method"`
+	Url     string      `json:"url"`
+	Headers http.Header `json:"headers"`
+	Body    string      `json:"body"`
+}
+
+// RecordedResponse is the upstream response of a recording
+type RecordedResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Headers    http.Header `json:"headers"`
+	Body       string      `json:"body"`
+}
+
+// Recording is stored as <PROVIDER_TRAFFIC_DIR>/<provider>/<fingerprint>.json
+type Recording struct {
+	Provider    string           `json:"provider"`
+	Fingerprint string           `json:"fingerprint"`
+	Request     RecordedRequest  `json:"request"`
+	Response    RecordedResponse `json:"response"`
+}
+
+// newProviderClient returns the HTTP client all provider adapters send their requests with, transport sends them
+// upstream and defaults to http.DefaultTransport.
+// Depending on PROVIDER_TRAFFIC_MODE the traffic is recorded to disk or replayed from earlier recordings.
+// Requests marked with withHedging are hedged upstream, replayed requests never are. Upstream requests are rate
+// limited, counted against the budgets of the provider and wait for a slot of the outbound scheduler
+func newProviderClient(provider string, transport http.RoundTripper) *http.Client {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	upstream := &hedgingTransport{next: &meteringTransport{provider: provider, next: &schedulingTransport{next: transport}}}
+
+	// the requests of a lookup are counted however they are answered
+	var outbound http.RoundTripper
+	switch utils.Cfg.ProviderTraffic.Mode {
+	case TRAFFIC_RECORD:
+		outbound = &recordingTransport{provider: provider, dir: utils.Cfg.ProviderTraffic.Dir, next: upstream}
+	case TRAFFIC_REPLAY:
+		outbound = &replayTransport{provider: provider, dir: utils.Cfg.ProviderTraffic.Dir}
+	default:
+		outbound = upstream
+	}
+
+	return &http.Client{Transport: &requestCountingTransport{next: outbound}}
+}
+
+// recordingTransport passes requests upstream and writes every response to disk.
+// The response body is read completely before it is returned, so offers are not streamed while recording
+type recordingTransport struct {
+	provider string
+	dir      string
+	next     http.RoundTripper
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req, requestBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(responseBody))
+
+	recording := Recording{
+		Provider:    t.provider,
+		Fingerprint: requestFingerprint(req.Method, req.URL, requestBody),
+		Request: RecordedRequest{
+			Method:  req.Method,
+			Url:     scrubURL(req.URL),
+			Headers: scrubHeaders(req.Header),
+			Body:    string(requestBody),
+		},
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Headers:    scrubHeaders(resp.Header),
+			Body:       string(responseBody),
+		},
+	}
+	// a failed recording must not fail the lookup
+	if err := writeRecording(t.dir, recording); err != nil {
+		log.WithError(err).WithField("provider", t.provider).Error("Failed to write provider traffic recording")
+	}
+
+	return resp, nil
+}
+
+// replayTransport answers requests from recordings and never calls the provider
+type replayTransport struct {
+	provider string
+	dir      string
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req, requestBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint := requestFingerprint(req.Method, req.URL, requestBody)
+	data, err := os.ReadFile(recordingPath(t.dir, t.provider, fingerprint))
+	if err != nil {
+		return nil, fmt.Errorf("no recording of %s %s for %s: %w", req.Method, scrubURL(req.URL), t.provider, err)
+	}
+
+	var recording Recording
+	if err := json.Unmarshal(data, &recording); err != nil {
+		return nil, fmt.Errorf("invalid recording %s of %s: %w", fingerprint, t.provider, err)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recording.Response.StatusCode, http.StatusText(recording.Response.StatusCode)),
+		StatusCode:    recording.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        recording.Response.Headers,
+		Body:          io.NopCloser(strings.NewReader(recording.Response.Body)),
+		ContentLength: int64(len(recording.Response.Body)),
+		Request:       req,
+	}, nil
+}
+
+// readRequestBody reads the body of the request and returns a clone which can still be sent
+func readRequestBody(req *http.Request) (*http.Request, []byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil, nil
+	}
+	defer req.Body.Close()
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+
+	clone := req.Clone(req.Context())
+	clone.Body = io.NopCloser(bytes.NewReader(body))
+	return clone, body, nil
+}
+
+// requestFingerprint identifies a request by method, scrubbed URL and body, so rotating credentials
+// or changing timestamps and signatures still replay the same recording
+func requestFingerprint(method string, u *url.URL, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(method + "\n" + scrubURL(u) + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func scrubURL(u *url.URL) string {
+	scrubbed := *u
+	query := scrubbed.Query()
+	for _, param := range scrubbedQueryParams {
+		if query.Has(param) {
+			query.Set(param, redacted)
+		}
+	}
+	scrubbed.RawQuery = query.Encode()
+	return scrubbed.String()
+}
+
+func scrubHeaders(headers http.Header) http.Header {
+	scrubbed := headers.Clone()
+	for _, header := range scrubbedHeaders {
+		if scrubbed.Get(header) != "" {
+			scrubbed.Set(header, redacted)
+		}
+	}
+	return scrubbed
+}
+
+func recordingPath(dir string, provider string, fingerprint string) string {
+	return filepath.Join(dir, strings.ToLower(provider), fingerprint+".json")
+}
+
+func writeRecording(dir string, recording Recording) error {
+	path := recordingPath(dir, recording.Provider, recording.Fingerprint)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(recording, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first, parallel lookups of the same address record the same fingerprint
+	tmp, err := os.CreateTemp(filepath.Dir(path), recording.Fingerprint+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
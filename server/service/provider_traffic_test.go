@@ -0,0 +1,99 @@
+package service
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"server/utils"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRecordingIsScrubbed(t *testing.T) {
+	traffic := utils.Cfg.ProviderTraffic
+	t.Cleanup(func() { utils.Cfg.ProviderTraffic = traffic })
+
+	for _, tc := range []struct {
+		name string
+		auth providerAuth
+	}{
+		{name: "header key", auth: webWunderAuth},
+		{name: "query key", auth: verbynDichAuth},
+		{name: "basic auth", auth: servusSpeedAuth},
+		{name: "signature", auth: pingPerfectProvider.auth},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			utils.Cfg.ProviderTraffic.Mode = TRAFFIC_RECORD
+			utils.Cfg.ProviderTraffic.Dir = t.TempDir()
+
+			// the stub echoes the credentials in its response headers, as some providers do in their errors
+			var mu sync.Mutex
+			var sent []string
+			stub := newConformanceStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				for _, header := range []string{"X-Api-Key", "Authorization", "X-Signature", "X-Client-Id"} {
+					if value := r.Header.Get(header); value != "" {
+						sent = append(sent, value)
+						w.Header().Set(header, value)
+					}
+				}
+				if value := r.URL.Query().Get("apiKey"); value != "" {
+					sent = append(sent, value)
+				}
+				w.Write([]byte("offers"))
+			}))
+			defer stub.close()
+
+			req, err := http.NewRequest(http.MethodPost, "http://provider.test/offers?plz=80331", strings.NewReader(`{"plz":"80331"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := doAuthenticated(newProviderClient(t.Name(), stub), req, tc.auth)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+
+			if len(sent) == 0 {
+				t.Fatal("no credential was sent")
+			}
+			// besides the values sent, the credentials themselves must not be written, e.g. the password of basic auth
+			secrets := sent
+			for _, key := range tc.auth.rotatingCredential().Keys() {
+				secrets = append(secrets, key.Reveal())
+			}
+
+			recordings := 0
+			err = filepath.WalkDir(utils.Cfg.ProviderTraffic.Dir, func(path string, entry fs.DirEntry, err error) error {
+				if err != nil || entry.IsDir() {
+					return err
+				}
+				recordings++
+				data, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				for _, secret := range secrets {
+					if strings.Contains(string(data), secret) {
+						t.Errorf("recording %s contains the credential %q:\n%s", entry.Name(), secret, data)
+					}
+				}
+				if !strings.Contains(string(data), redacted) {
+					t.Errorf("recording %s has no scrubbed credential:\n%s", entry.Name(), data)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if recordings != 1 {
+				t.Errorf("%d recordings were written, want 1", recordings)
+			}
+		})
+	}
+}
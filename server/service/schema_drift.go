@@ -0,0 +1,370 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"server/utils"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxDriftSampleLength limits the size of the sample attached to a drift warning
+const maxDriftSampleLength = 512
+
+type fieldKind string
+
+const (
+	STRING_FIELD fieldKind = "string"
+	NUMBER_FIELD fieldKind = "number"
+	BOOL_FIELD   fieldKind = "bool"
+	OBJECT_FIELD fieldKind = "object"
+	ARRAY_FIELD  fieldKind = "array"
+)
+
+type schemaField struct {
+	Kind     fieldKind
+	Required bool
+}
+
+// responseSchema describes the fields we expect in a provider response by their dotted path
+type responseSchema map[string]schemaField
+
+type SchemaTypeChange struct {
+	Field    string    `json:"field"`
+	Expected fieldKind `json:"expected"`
+	Actual   fieldKind `json:"actual"`
+}
+
+// SchemaDrift is the structured result of checking a provider response against its expected schema
+type SchemaDrift struct {
+	Provider      string             `json:"provider"`
+	Response      string             `json:"response"`
+	UnknownFields []string           `json:"unknownFields,omitempty"`
+	MissingFields []string           `json:"missingFields,omitempty"`
+	TypeChanges   []SchemaTypeChange `json:"typeChanges,omitempty"`
+	Sample        string             `json:"sample,omitempty"`
+}
+
+func (d SchemaDrift) HasDrift() bool {
+	return len(d.UnknownFields) > 0 || len(d.MissingFields) > 0 || len(d.TypeChanges) > 0
+}
+
+var schemaDriftCounter = utils.NewCounterVec("provider_schema_drift_total")
+
+// schemaDriftChecker collects the observed fields of one or more records of a response and compares them to the schema.
+// It is safe for concurrent use
+type schemaDriftChecker struct {
+	schema responseSchema
+	// the drift is reported by the lookup the checker belongs to, see withSchemaDrift
+	deferred bool
+
+	mu      sync.Mutex
+	drift   SchemaDrift
+	records int
+	unknown map[string]bool
+	missing map[string]bool
+	changed map[string]SchemaTypeChange
+}
+
+type schemaDriftKey struct{}
+
+// lookupSchemaDrift holds the checkers of the responses of one provider lookup by response name
+type lookupSchemaDrift struct {
+	mu       sync.Mutex
+	checkers map[string]*schemaDriftChecker
+}
+
+// withSchemaDrift returns a context whose responses of the same name share one checker, so the responses of a lookup do
+// not overwrite the health signal of each other. report reports the drift of all responses once the lookup finished
+func withSchemaDrift(ctx context.Context) (context.Context, func()) {
+	lookup := &lookupSchemaDrift{checkers: make(map[string]*schemaDriftChecker)}
+	report := func() {
+		lookup.mu.Lock()
+		defer lookup.mu.Unlock()
+
+		for _, checker := range lookup.checkers {
+			checker.publish()
+		}
+	}
+
+	return context.WithValue(ctx, schemaDriftKey{}, lookup), report
+}
+
+// newSchemaDriftChecker returns the checker of the response, within a lookup of withSchemaDrift the checker shared by
+// all responses of the name. Outside of a lookup every response reports its own drift
+func newSchemaDriftChecker(ctx context.Context, provider string, response string, schema responseSchema) *schemaDriftChecker {
+	lookup, ok := ctx.Value(schemaDriftKey{}).(*lookupSchemaDrift)
+	if !ok {
+		return newStandaloneSchemaDriftChecker(provider, response, schema)
+	}
+
+	lookup.mu.Lock()
+	defer lookup.mu.Unlock()
+
+	checker, ok := lookup.checkers[response]
+	if !ok {
+		checker = newStandaloneSchemaDriftChecker(provider, response, schema)
+		checker.deferred = true
+		lookup.checkers[response] = checker
+	}
+	return checker
+}
+
+func newStandaloneSchemaDriftChecker(provider string, response string, schema responseSchema) *schemaDriftChecker {
+	return &schemaDriftChecker{
+		schema:  schema,
+		drift:   SchemaDrift{Provider: provider, Response: response},
+		unknown: make(map[string]bool),
+		missing: make(map[string]bool),
+		changed: make(map[string]SchemaTypeChange),
+	}
+}
+
+// checkRecord compares the observed fields of a single record and keeps the first offending record as sample
+func (c *schemaDriftChecker) checkRecord(observed map[string]fieldKind, sample []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.records++
+	drifted := false
+
+	for field, kind := range observed {
+		expected, ok := c.schema[field]
+		if !ok {
+			c.unknown[field] = true
+			drifted = true
+			continue
+		}
+		// strings are the fallback kind for text based formats, so every value fits into a string field.
+		// Empty values of text based formats have no kind at all
+		if kind != "" && expected.Kind != kind && expected.Kind != STRING_FIELD {
+			c.changed[field] = SchemaTypeChange{Field: field, Expected: expected.Kind, Actual: kind}
+			drifted = true
+		}
+	}
+
+	for field, expected := range c.schema {
+		if _, ok := observed[field]; expected.Required && !ok {
+			c.missing[field] = true
+			drifted = true
+		}
+	}
+
+	if drifted && c.drift.Sample == "" {
+		c.drift.Sample = truncateSample(sample)
+	}
+}
+
+// result aggregates the drift over all checked records
+func (c *schemaDriftChecker) result() SchemaDrift {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	drift := c.drift
+	drift.UnknownFields = sortedKeys(c.unknown)
+	drift.MissingFields = sortedKeys(c.missing)
+	for _, field := range sortedKeys(c.changed) {
+		drift.TypeChanges = append(drift.TypeChanges, c.changed[field])
+	}
+
+	return drift
+}
+
+// report logs the drift as structured warning and updates the drift metric and the provider health. Within a lookup
+// of withSchemaDrift the lookup reports once it finished
+func (c *schemaDriftChecker) report() SchemaDrift {
+	if c.deferred {
+		return c.result()
+	}
+
+	return c.publish()
+}
+
+// publish reports the drift, a response without records shows nothing about the schema and leaves the health as is
+func (c *schemaDriftChecker) publish() SchemaDrift {
+	drift := c.result()
+	c.mu.Lock()
+	records := c.records
+	c.mu.Unlock()
+
+	if records > 0 {
+		reportSchemaDrift(drift)
+	}
+	return drift
+}
+
+func reportSchemaDrift(drift SchemaDrift) {
+	signal := "schema:" + drift.Response
+	if !drift.HasDrift() {
+		ProviderHealthInstance.SetHealthy(drift.Provider, signal)
+		return
+	}
+
+	schemaDriftCounter.Inc(drift.Provider)
+	ProviderHealthInstance.SetDegraded(drift.Provider, signal, fmt.Sprintf("unknown fields %v, missing fields %v, type changes %d",
+		drift.UnknownFields, drift.MissingFields, len(drift.TypeChanges)))
+	log.WithFields(log.Fields{
+		"provider":      drift.Provider,
+		"response":      drift.Response,
+		"unknownFields": drift.UnknownFields,
+		"missingFields": drift.MissingFields,
+		"typeChanges":   drift.TypeChanges,
+		"sample":        drift.Sample,
+	}).Warn("Provider response schema drifted")
+}
+
+// checkJSONSchema checks a JSON object, or every object of a JSON array, against the schema
+func checkJSONSchema(ctx context.Context, provider string, response string, schema responseSchema, body []byte) SchemaDrift {
+	checker := newSchemaDriftChecker(ctx, provider, response, schema)
+
+	var records []json.RawMessage
+	if err := json.Unmarshal(body, &records); err != nil {
+		records = []json.RawMessage{body}
+	}
+	for _, record := range records {
+		checker.checkRecord(jsonObservedKinds(record), record)
+	}
+
+	return checker.report()
+}
+
+// jsonObservedKinds returns the kind of every field of a JSON record by its dotted path
+func jsonObservedKinds(record []byte) map[string]fieldKind {
+	observed := make(map[string]fieldKind)
+
+	var raw any
+	if err := json.Unmarshal(record, &raw); err != nil {
+		// the decoding error itself is reported by the adapter
+		return observed
+	}
+	flattenJSON("", raw, observed)
+
+	return observed
+}
+
+func flattenJSON(prefix string, value any, observed map[string]fieldKind) {
+	switch v := value.(type) {
+	case map[string]any:
+		if prefix != "" {
+			observed[prefix] = OBJECT_FIELD
+		}
+		for key, child := range v {
+			flattenJSON(joinFieldPath(prefix, key), child, observed)
+		}
+	case []any:
+		observed[prefix] = ARRAY_FIELD
+		for _, child := range v {
+			// elements of an array share the path of the array, only objects add nested fields
+			if _, ok := child.(map[string]any); ok {
+				flattenJSON(prefix, child, observed)
+				observed[prefix] = ARRAY_FIELD
+			}
+		}
+	case string:
+		observed[prefix] = STRING_FIELD
+	case float64, json.Number:
+		observed[prefix] = NUMBER_FIELD
+	case bool:
+		observed[prefix] = BOOL_FIELD
+	case nil:
+		// null is treated like an absent field
+	}
+}
+
+// csvObservedKinds returns the kind of every cell of a CSV record by its column, empty cells have no kind
+func csvObservedKinds(headers []string, record []string) map[string]fieldKind {
+	observed := make(map[string]fieldKind, len(headers))
+	for i, header := range headers {
+		observed[header] = ""
+		if i < len(record) && strings.TrimSpace(record[i]) != "" {
+			observed[header] = textFieldKind(record[i])
+		}
+	}
+
+	return observed
+}
+
+// xmlObservedKinds returns the kind of every element of a single XML record by its dotted path relative to the record element
+func xmlObservedKinds(record []byte) map[string]fieldKind {
+	observed := make(map[string]fieldKind)
+	decoder := xml.NewDecoder(bytes.NewReader(record))
+
+	// path[0] is the record element itself
+	var path []string
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			// io.EOF or a syntax error, which is reported by the adapter
+			return observed
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			path = append(path, t.Name.Local)
+			if len(path) > 1 {
+				observed[strings.Join(path[1:], ".")] = OBJECT_FIELD
+			}
+			text.Reset()
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if len(path) > 1 {
+				if trimmed := strings.TrimSpace(text.String()); trimmed != "" {
+					observed[strings.Join(path[1:], ".")] = textFieldKind(trimmed)
+				}
+			}
+			text.Reset()
+			if len(path) > 0 {
+				path = path[:len(path)-1]
+			}
+		}
+	}
+}
+
+// textFieldKind guesses the kind of a value of a text based format
+func textFieldKind(value string) fieldKind {
+	value = strings.TrimSpace(value)
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return NUMBER_FIELD
+	}
+	if value == "true" || value == "false" {
+		return BOOL_FIELD
+	}
+
+	return STRING_FIELD
+}
+
+func joinFieldPath(prefix string, key string) string {
+	if prefix == "" {
+		return key
+	}
+
+	return prefix + "." + key
+}
+
+func truncateSample(sample []byte) string {
+	if len(sample) > maxDriftSampleLength {
+		return string(sample[:maxDriftSampleLength]) + "..."
+	}
+
+	return string(sample)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	return keys
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"server/domain"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestAdapterReplay replays the recorded responses of the golden corpus through every adapter and checks the decoded
+// offers and the schema drift the adapter reported
+func TestAdapterReplay(t *testing.T) {
+	scripts := conformanceScripts(t, goldenDir)
+
+	pingPerfectProducts, err := os.ReadFile(filepath.Join(goldenDir, "pingperfect", "products.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the provider added a field to every product
+	pingPerfectDrifted := bytes.ReplaceAll(pingPerfectProducts, []byte(`"productInfo": {`), []byte(`"productInfo": {"fiberReady": true, `))
+
+	pingPerfectOffers := []string{
+		"PingPerfect Fiber 1000: FIBER 1000 Mbit/s 6990 ct",
+		"PingPerfect Speed 100: DSL 100 Mbit/s 3990 ct",
+		"PingPerfect Young 250: CABLE 250 Mbit/s 2990 ct",
+	}
+
+	for _, tc := range []struct {
+		name     string
+		provider InternetProviderAPI
+		script   http.Handler
+		want     []string
+		// drift signals of the provider which have to be degraded, with a part of their message
+		degraded map[string]string
+	}{
+		{
+			name:     "ByteMe",
+			provider: byteMeProvider,
+			script:   scripts[byteMeProvider.GetProviderName()].handler,
+			want: []string{
+				"ByteMe Basic 50: DSL 50 Mbit/s 3499 ct",
+				"ByteMe Cable Young: CABLE 250 Mbit/s 2999 ct",
+				"ByteMe Fiber 500: FIBER 500 Mbit/s 5999 ct",
+				"ByteMe Fiber 500: FIBER 500 Mbit/s 5999 ct",
+				"ByteMe Mobile 100: MOBILE 100 Mbit/s 1999 ct",
+			},
+			// some rows send the installation service as number instead of bool
+			degraded: map[string]string{"schema:products": "type changes 1"},
+		},
+		{
+			name:     "PingPerfect",
+			provider: pingPerfectProvider,
+			script:   scripts[pingPerfectProvider.GetProviderName()].handler,
+			want:     pingPerfectOffers,
+		},
+		{
+			name:     "PingPerfect with an unknown field",
+			provider: pingPerfectProvider,
+			script:   staticScript("application/json", pingPerfectDrifted),
+			want:     pingPerfectOffers,
+			degraded: map[string]string{"schema:products": "unknown fields [productInfo.fiberReady]"},
+		},
+		{
+			name:     "ServusSpeed",
+			provider: &ServusSpeedApi{},
+			script:   scripts[(&ServusSpeedApi{}).GetProviderName()].handler,
+			want: []string{
+				"Servus Speed Basic DSL: DSL 50 Mbit/s 3200 ct",
+				"Servus Speed Fiber Max: FIBER 1000 Mbit/s 5900 ct",
+			},
+		},
+		{
+			name:     "VerbynDich",
+			provider: &VerbyndichAPI{},
+			script:   scripts[(&VerbyndichAPI{}).GetProviderName()].handler,
+			want: []string{
+				"VerbynDich Cable 100: CABLE 100 Mbit/s 3500 ct",
+				"VerbynDich Cable 100: CABLE 100 Mbit/s 4000 ct",
+				"VerbynDich Cable 100: CABLE 100 Mbit/s 4400 ct",
+				"VerbynDich Cable 200: CABLE 200 Mbit/s 4000 ct",
+				"VerbynDich Cable 200: CABLE 200 Mbit/s 4500 ct",
+				"VerbynDich Cable 200: CABLE 200 Mbit/s 4900 ct",
+				"VerbynDich DSL 25: DSL 25 Mbit/s 2500 ct",
+				"VerbynDich DSL 25: DSL 25 Mbit/s 3000 ct",
+				"VerbynDich DSL 25: DSL 25 Mbit/s 3400 ct",
+				"VerbynDich DSL 50: DSL 50 Mbit/s 3000 ct",
+				"VerbynDich DSL 50: DSL 50 Mbit/s 3500 ct",
+				"VerbynDich DSL 50: DSL 50 Mbit/s 3900 ct",
+				"VerbynDich Fiber 1000: FIBER 1000 Mbit/s 5200 ct",
+				"VerbynDich Fiber 500: FIBER 500 Mbit/s 4500 ct",
+				"VerbynDich Fiber 500: FIBER 500 Mbit/s 5000 ct",
+				"VerbynDich Fiber 500: FIBER 500 Mbit/s 5400 ct",
+			},
+		},
+		{
+			name:     "WebWunder",
+			provider: &WebWunderApi{},
+			script:   scripts[(&WebWunderApi{}).GetProviderName()].handler,
+			want: []string{
+				"WebWunder Standard 40: DSL 40 Mbit/s 2824 ct",
+				"WebWunder Standard 50: DSL 50 Mbit/s 3024 ct",
+				"WebWunder Standard 60: DSL 60 Mbit/s 3224 ct",
+				"WebWunder Starter 20: DSL 20 Mbit/s 2224 ct",
+				"WebWunder Starter 30: DSL 30 Mbit/s 2424 ct",
+				"WebWunder Starter 40: DSL 40 Mbit/s 2624 ct",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			stub := newConformanceStub(tc.script)
+			defer stub.close()
+			provider := tc.provider.withTransport(stub)
+			driftBefore := schemaDriftCounter.Get(provider.GetProviderName())
+
+			publisher := &conformancePublisher{}
+			errChannel := make(chan error)
+			errorsChecked := make(chan struct{})
+			go func() {
+				defer close(errorsChecked)
+				for err := range errChannel {
+					t.Errorf("unexpected error: %v", err)
+				}
+			}()
+			provider.GetOffersStream(context.Background(), conformanceAddress, domain.OfferFilter{}, publisher, errChannel)
+			close(errChannel)
+			<-errorsChecked
+
+			var got []string
+			for _, offer := range publisher.published() {
+				got = append(got, fmt.Sprintf("%s: %s %d Mbit/s %d ct", offer.ProductName, offer.ConnectionType, offer.Speed, offer.MonthlyCostInCent))
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("decoded offers\n  %s\nwant\n  %s", strings.Join(got, "\n  "), strings.Join(tc.want, "\n  "))
+			}
+
+			for signal, health := range ProviderHealthInstance.Snapshot()[provider.GetProviderName()] {
+				if !strings.HasPrefix(signal, "schema:") {
+					continue
+				}
+				message, wantDegraded := tc.degraded[signal]
+				if wantDegraded != (health.Status == DEGRADED) {
+					t.Errorf("signal %s is %s: %s", signal, health.Status, health.Message)
+				}
+				if wantDegraded && !strings.Contains(health.Message, message) {
+					t.Errorf("signal %s has message %q, want it to contain %q", signal, health.Message, message)
+				}
+			}
+			if drifted := schemaDriftCounter.Get(provider.GetProviderName()) - driftBefore; drifted != int64(len(tc.degraded)) {
+				t.Errorf("drift was counted %d times, want %d", drifted, len(tc.degraded))
+			}
+		})
+	}
+}
+
+func TestSchemaDriftReportedOncePerLookup(t *testing.T) {
+	const provider = "SchemaDriftLookup"
+	schema := responseSchema{"speed": {Kind: NUMBER_FIELD, Required: true}}
+	clean := map[string]fieldKind{"speed": NUMBER_FIELD}
+	drifted := map[string]fieldKind{"speed": NUMBER_FIELD, "fiberReady": BOOL_FIELD}
+	health := func() HealthSignal {
+		return ProviderHealthInstance.Snapshot()[provider]["schema:products"]
+	}
+
+	ctx, report := withSchemaDrift(context.Background())
+	// the responses of a lookup arrive in any order, some without records
+	for _, observed := range []map[string]fieldKind{drifted, clean, nil} {
+		checker := newSchemaDriftChecker(ctx, provider, "products", schema)
+		if observed != nil {
+			checker.checkRecord(observed, nil)
+		}
+		checker.report()
+	}
+	if _, ok := ProviderHealthInstance.Snapshot()[provider]; ok {
+		t.Fatalf("drift was reported before the lookup finished")
+	}
+
+	report()
+	if signal := health(); signal.Status != DEGRADED || !strings.Contains(signal.Message, "fiberReady") {
+		t.Errorf("signal is %s: %s, want the drift of the lookup", signal.Status, signal.Message)
+	}
+	if drifted := schemaDriftCounter.Get(provider); drifted != 1 {
+		t.Errorf("drift was counted %d times, want once", drifted)
+	}
+
+	// a later response without records shows nothing about the schema
+	newSchemaDriftChecker(context.Background(), provider, "products", schema).report()
+	if signal := health(); signal.Status != DEGRADED {
+		t.Errorf("a response without records marked the signal %s", signal.Status)
+	}
+
+	checker := newSchemaDriftChecker(context.Background(), provider, "products", schema)
+	checker.checkRecord(clean, nil)
+	checker.report()
+	if signal := health(); signal.Status != HEALTHY {
+		t.Errorf("a response matching the schema left the signal %s", signal.Status)
+	}
+}
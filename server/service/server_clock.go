@@ -0,0 +1,90 @@
+package service
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// health signal raised when PingPerfect rejects our signature
+const SIGNATURE_SIGNAL = "signature"
+
+// Date headers have a resolution of one second, smaller offsets cannot be measured
+const minClockOffset = time.Second
+
+// serverClock estimates the offset of a provider's clock from the Date headers of its responses,
+// so timestamps in signed requests match the provider's clock even if our host clock drifts
+type serverClock struct {
+	provider string
+
+	mu     sync.Mutex
+	offset time.Duration
+}
+
+// now returns the current time of the provider's clock
+func (c *serverClock) now() time.Time {
+	return time.Now().Add(c.Offset())
+}
+
+func (c *serverClock) Offset() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.offset
+}
+
+// observe updates the offset from the Date header of a response to a request sent at sent and answered at received
+func (c *serverClock) observe(sent time.Time, received time.Time, date string) {
+	serverTime, err := http.ParseTime(date)
+	if err != nil {
+		return
+	}
+
+	// the server truncates to full seconds, its time lies somewhere in the following second
+	serverTime = serverTime.Add(500 * time.Millisecond)
+	localTime := sent.Add(received.Sub(sent) / 2)
+	offset := serverTime.Sub(localTime)
+	if offset.Abs() < minClockOffset {
+		offset = 0
+	}
+
+	c.mu.Lock()
+	previous := c.offset
+	c.offset = offset
+	c.mu.Unlock()
+
+	if (offset - previous).Abs() >= minClockOffset {
+		log.WithFields(log.Fields{
+			"provider": c.provider,
+			"offset":   offset.Round(time.Second).String(),
+			"previous": previous.Round(time.Second).String(),
+		}).Warn("Provider clock offset changed, adjusting request timestamps")
+	}
+}
+
+// observing wraps the transport of a provider client so every response updates the offset
+func (c *serverClock) observing(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &clockObservingTransport{clock: c, next: next}
+}
+
+type clockObservingTransport struct {
+	clock *serverClock
+	next  http.RoundTripper
+}
+
+func (t *clockObservingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	sent := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	t.clock.observe(sent, time.Now(), resp.Header.Get("Date"))
+	return resp, nil
+}
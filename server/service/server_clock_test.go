@@ -0,0 +1,140 @@
+package service
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"server/domain"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServerClockObserve(t *testing.T) {
+	sent := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	skewed := func(skew time.Duration) string {
+		return sent.Add(skew).Format(http.TimeFormat)
+	}
+
+	for _, tc := range []struct {
+		name string
+		// Date header of the response, which arrives 400ms after sending
+		date   string
+		before time.Duration
+		want   time.Duration
+	}{
+		{name: "in sync", date: skewed(0), want: 0},
+		{name: "ahead", date: skewed(90 * time.Second), want: 90 * time.Second},
+		{name: "behind", date: skewed(-45 * time.Second), want: -45 * time.Second},
+		{name: "within the resolution of the header", date: skewed(800 * time.Millisecond), want: 0},
+		{name: "back in sync", date: skewed(0), before: time.Minute, want: 0},
+		{name: "invalid header keeps the offset", date: "yesterday", before: time.Minute, want: time.Minute},
+		{name: "missing header keeps the offset", date: "", before: time.Minute, want: time.Minute},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := &serverClock{provider: "test", offset: tc.before}
+			clock.observe(sent, sent.Add(400*time.Millisecond), tc.date)
+
+			// the header is truncated to seconds, the offset is only known to a second
+			if got := clock.Offset(); (got - tc.want).Abs() > time.Second {
+				t.Errorf("offset is %s, want %s", got, tc.want)
+			}
+			if got := time.Until(clock.now()); (got - tc.want).Abs() > time.Second {
+				t.Errorf("now is %s ahead, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+// signingStub answers signed requests like PingPerfect with a clock skewed by skew, requests whose timestamp is more
+// than maxSkew off are rejected
+func signingStub(t *testing.T, skew time.Duration, maxSkew time.Duration) (*conformanceStub, *atomic.Int64) {
+	products, err := os.ReadFile(filepath.Join(goldenDir, "pingperfect", "products.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var requests atomic.Int64
+	stub := newConformanceStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		now := time.Now().Add(skew)
+		w.Header().Set("Date", now.UTC().Format(http.TimeFormat))
+
+		timestamp, err := strconv.ParseInt(r.Header.Get("X-Timestamp"), 10, 64)
+		if err != nil || now.Sub(time.Unix(timestamp, 0)).Abs() > maxSkew {
+			http.Error(w, `{"error":"invalid signature"}`, http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(products)
+	}))
+	t.Cleanup(stub.close)
+
+	return stub, &requests
+}
+
+// signingProvider returns PingPerfect with a clock of its own and health signals which do not leak into other tests
+func signingProvider(t *testing.T, transport http.RoundTripper) (*DeclarativeProvider, *providerHealth) {
+	definition, err := providerDefinitions.ReadFile("providers/pingperfect.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := NewDeclarativeProvider(definition)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	health := &providerHealth{signals: make(map[string]map[string]HealthSignal)}
+	previous := ProviderHealthInstance
+	ProviderHealthInstance = health
+	t.Cleanup(func() { ProviderHealthInstance = previous })
+
+	return provider.withTransport(transport).(*DeclarativeProvider), health
+}
+
+func TestSignedRequestFollowsSkewedClock(t *testing.T) {
+	stub, requests := signingStub(t, 2*time.Minute, 30*time.Second)
+	provider, health := signingProvider(t, stub)
+	service := OfferServiceImpl{providers: []InternetProviderAPI{provider}}
+
+	// the first request is rejected, its Date header corrects the clock and the repeated request is accepted
+	if status := lookupStatus(t, service); status.Status != domain.PROVIDER_OK {
+		t.Fatalf("lookup has status %s with error kind %q, want OK", status.Status, status.ErrorKind)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("%d requests were sent, want the rejected one and its repetition", got)
+	}
+	if offset := provider.clock.Offset(); (offset - 2*time.Minute).Abs() > time.Second {
+		t.Errorf("clock offset is %s, want 2m", offset)
+	}
+	if signal := health.Snapshot()[provider.GetProviderName()][SIGNATURE_SIGNAL]; signal.Status != HEALTHY {
+		t.Errorf("signature signal is %+v, want healthy", signal)
+	}
+
+	// the clock stays corrected, the next lookup is accepted at once
+	if status := lookupStatus(t, service); status.Status != domain.PROVIDER_OK || requests.Load() != 3 {
+		t.Errorf("next lookup has status %s after %d requests, want OK after one more", status.Status, requests.Load())
+	}
+}
+
+func TestRejectedSignatureDegradesHealth(t *testing.T) {
+	// the clock of the stub is in sync, the signature is rejected for another reason
+	stub, requests := signingStub(t, 0, -1)
+	provider, health := signingProvider(t, stub)
+	service := OfferServiceImpl{providers: []InternetProviderAPI{provider}}
+
+	status := lookupStatus(t, service)
+	if status.Status != domain.PROVIDER_ERROR || status.ErrorKind != string(CLIENT_FAULT) {
+		t.Errorf("lookup has status %s with error kind %q, want a client fault", status.Status, status.ErrorKind)
+	}
+	// repeating the request with the same clock does not help
+	if got := requests.Load(); got != 1 {
+		t.Errorf("%d requests were sent, want 1", got)
+	}
+	signal := health.Snapshot()[provider.GetProviderName()][SIGNATURE_SIGNAL]
+	if signal.Status != DEGRADED || !strings.Contains(signal.Message, "signature rejected with status 401") {
+		t.Errorf("signature signal is %+v, want degraded by the rejection", signal)
+	}
+}
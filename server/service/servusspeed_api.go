@@ -3,17 +3,20 @@ package service
 import (
 	"bytes"
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"server/domain"
 	"server/utils"
+	"strconv"
 	"sync"
 )
 
-type ServusSpeedApi struct{}
+type ServusSpeedApi struct {
+	// sends the requests upstream, nil for http.DefaultTransport
+	transport http.RoundTripper
+}
 
 type ServusSpeedRequestAddress struct {
 	Strasse      string `json:"strasse"`
@@ -48,9 +51,32 @@ type ServusSpeedProductResponse struct {
 		} `json:"pricingDetails"`
 		Discount int `json:"discount"`
 	} `json:"servusSpeedProduct"`
+
+	// raw response to capture the fields we do not map
+	Raw json.RawMessage `json:"-"`
+}
+
+var servusSpeedProductsSchema = responseSchema{
+	"availableProducts": {Kind: ARRAY_FIELD, Required: true},
+}
+
+var servusSpeedProductSchema = responseSchema{
+	"servusSpeedProduct":                                      {Kind: OBJECT_FIELD, Required: true},
+	"servusSpeedProduct.providerName":                         {Kind: STRING_FIELD, Required: true},
+	"servusSpeedProduct.productInfo":                          {Kind: OBJECT_FIELD, Required: true},
+	"servusSpeedProduct.productInfo.speed":                    {Kind: NUMBER_FIELD, Required: true},
+	"servusSpeedProduct.productInfo.contractDurationInMonths": {Kind: NUMBER_FIELD, Required: true},
+	"servusSpeedProduct.productInfo.connectionType":           {Kind: STRING_FIELD, Required: true},
+	"servusSpeedProduct.productInfo.tv":                       {Kind: STRING_FIELD},
+	"servusSpeedProduct.productInfo.limitFrom":                {Kind: NUMBER_FIELD},
+	"servusSpeedProduct.productInfo.maxAge":                   {Kind: NUMBER_FIELD},
+	"servusSpeedProduct.pricingDetails":                       {Kind: OBJECT_FIELD, Required: true},
+	"servusSpeedProduct.pricingDetails.monthlyCostInCent":     {Kind: NUMBER_FIELD, Required: true},
+	"servusSpeedProduct.pricingDetails.installationService":   {Kind: BOOL_FIELD, Required: true},
+	"servusSpeedProduct.discount":                             {Kind: NUMBER_FIELD},
 }
 
-func (api *ServusSpeedApi) GetOffersStream(ctx context.Context, address domain.Address, offersChannel *utils.PubSubChannel[domain.Offer], errChannel chan<- error) {
+func (api *ServusSpeedApi) GetOffersStream(ctx context.Context, address domain.Address, filter domain.OfferFilter, offersChannel OfferPublisher, errChannel chan<- error) {
 	// Step 1: Get the list of available product IDs
 	productIDs, err := api.getAvailableProducts(ctx, address)
 	if err != nil {
@@ -62,43 +88,54 @@ func (api *ServusSpeedApi) GetOffersStream(ctx context.Context, address domain.A
 		return
 	}
 
-	// Step 2: Get the details for each product ID in parallel
+	// Step 2: Get the details with a fixed pool of workers, one per request slot. Cached details and the request cap
+	// over all lookups are handled by cachedProductDetails
+	ids := make(chan string)
 	var wg sync.WaitGroup
-
-	for _, productID := range productIDs {
+	for range min(max(1, utils.Cfg.ServusSpeed.MaxConcurrency), len(productIDs)) {
 		wg.Add(1)
-		go func(id string) {
+		go func() {
 			defer wg.Done()
-
-			product, err := api.getProductDetails(ctx, id, address)
-			if err != nil {
-				select {
-				case <-ctx.Done():
-					return
-				case errChannel <- fmt.Errorf("%s: failed to get product details for %s: %w", api.GetProviderName(), id, err):
-				}
-				return
+			for id := range ids {
+				api.publishProductDetails(ctx, id, address, offersChannel, errChannel)
 			}
+		}()
+	}
 
-			// Convert to domain.Offer
-			offer := api.convertToOffer(product)
-			offer.Provider = api.GetProviderName()
-			offer.HelperIsPreliminary = false
-
-			// Write directly to the passed channel
-			offersChannel.Publish(offer)
-			select {
-			case <-ctx.Done():
-				return
-			default:
-			}
-		}(productID)
+feed:
+	for _, productID := range productIDs {
+		select {
+		case <-ctx.Done():
+			break feed
+		case ids <- productID:
+		}
 	}
+	close(ids)
 
-	// Wait for all goroutines to complete
+	// Wait for all workers to complete
 	wg.Wait()
 }
 
+// publishProductDetails publishes the offer of a product ID, or reports why its details are unavailable
+func (api *ServusSpeedApi) publishProductDetails(ctx context.Context, id string, address domain.Address, offersChannel OfferPublisher, errChannel chan<- error) {
+	product, err := api.cachedProductDetails(ctx, id, address)
+	if err != nil {
+		select {
+		case <-ctx.Done():
+		case errChannel <- fmt.Errorf("%s: failed to get product details for %s: %w", api.GetProviderName(), id, err):
+		}
+		return
+	}
+
+	// Convert to domain.Offer
+	offer := api.convertToOffer(product)
+	offer.Provider = api.GetProviderName()
+	offer.HelperIsPreliminary = false
+
+	// Write directly to the passed channel
+	offersChannel.Publish(offer)
+}
+
 func (api *ServusSpeedApi) getAvailableProducts(ctx context.Context, address domain.Address) ([]string, error) {
 	// Create request body
 	reqBody := ServusSpeedRequest{
@@ -117,9 +154,6 @@ func (api *ServusSpeedApi) getAvailableProducts(ctx context.Context, address dom
 		return nil, fmt.Errorf("failed to marshal request body: %w", err)
 	}
 
-	auth := utils.Cfg.ServusSpeed.Username + ":" + utils.Cfg.ServusSpeed.Password
-	basicAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte(auth))
-
 	// Send the request
 	productsResp, err := utils.RetryWrapper(ctx, func() (*ServusSpeedProductsResponse, error) {
 		// Create HTTP request with context
@@ -128,26 +162,39 @@ func (api *ServusSpeedApi) getAvailableProducts(ctx context.Context, address dom
 			return nil, fmt.Errorf("failed to create request: %w", err)
 		}
 
-		// Add basic auth header
-		req.Header.Set("Authorization", basicAuth)
 		req.Header.Set("Content-Type", "application/json")
 
-		client := &http.Client{}
-		resp, err := client.Do(req)
+		// basic auth is set per attempt
+		client := newProviderClient(api.GetProviderName(), api.transport)
+		resp, err := doAuthenticated(client, req, servusSpeedAuth)
 		if err != nil {
 			return nil, fmt.Errorf("failed to send request: %w", err)
 		}
 		defer resp.Body.Close()
 
-		// Check response status
+		// Check response status. ServusSpeed documents no status for addresses it does not know, client errors are
+		// reported as such and never cached as rejected address, they may as well be caused by our request
+		if isAddressRejectedStatus(resp.StatusCode) {
+			return nil, &ProviderError{
+				Provider: api.GetProviderName(),
+				Kind:     CLIENT_FAULT,
+				Code:     strconv.Itoa(resp.StatusCode),
+				Message:  readErrorBody(resp),
+			}
+		}
 		if resp.StatusCode != http.StatusOK {
-			bodyBytes, _ := io.ReadAll(resp.Body)
-			return nil, fmt.Errorf("API returned non-OK status: %d with body %s", resp.StatusCode, bodyBytes)
+			return nil, fmt.Errorf("API returned non-OK status: %d with body %s", resp.StatusCode, readErrorBody(resp))
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response: %w", err)
 		}
+		checkJSONSchema(ctx, api.GetProviderName(), "available-products", servusSpeedProductsSchema, body)
 
 		// Parse response
 		var productsResp ServusSpeedProductsResponse
-		err = json.NewDecoder(resp.Body).Decode(&productsResp)
+		err = json.Unmarshal(body, &productsResp)
 		return &productsResp, err
 	})
 	if err != nil {
@@ -177,9 +224,6 @@ func (api *ServusSpeedApi) getProductDetails(ctx context.Context, productID stri
 
 	url := fmt.Sprintf("https://servus-speed.gendev7.check24.fun/api/external/product-details/%s", productID)
 
-	auth := utils.Cfg.ServusSpeed.Username + ":" + utils.Cfg.ServusSpeed.Password
-	basicAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte(auth))
-
 	// Send the request
 	productResp, err := utils.RetryWrapper(ctx, func() (*ServusSpeedProductResponse, error) {
 		// Create HTTP request with context
@@ -188,12 +232,13 @@ func (api *ServusSpeedApi) getProductDetails(ctx context.Context, productID stri
 			return nil, fmt.Errorf("failed to create request: %w", err)
 		}
 
-		// Add basic auth header
-		req.Header.Set("Authorization", basicAuth)
 		req.Header.Set("Content-Type", "application/json")
+		// the product details only read
+		req = withHedging(req, api.GetProviderName()+":product-details", true)
 
-		client := &http.Client{}
-		resp, err := client.Do(req)
+		// basic auth is set per attempt
+		client := newProviderClient(api.GetProviderName(), api.transport)
+		resp, err := doAuthenticated(client, req, servusSpeedAuth)
 		if err != nil {
 			return nil, fmt.Errorf("failed to send request: %w", err)
 		}
@@ -201,11 +246,17 @@ func (api *ServusSpeedApi) getProductDetails(ctx context.Context, productID stri
 
 		// Check response status
 		if resp.StatusCode != http.StatusOK {
-			bodyBytes, _ := io.ReadAll(resp.Body)
-			return nil, fmt.Errorf("API returned non-OK status: %d with body %s", resp.StatusCode, bodyBytes)
+			return nil, fmt.Errorf("API returned non-OK status: %d with body %s", resp.StatusCode, readErrorBody(resp))
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response: %w", err)
 		}
+		checkJSONSchema(ctx, api.GetProviderName(), "product-details", servusSpeedProductSchema, body)
+
 		var productResp ServusSpeedProductResponse
-		err = json.NewDecoder(resp.Body).Decode(&productResp)
+		err = json.Unmarshal(body, &productResp)
+		productResp.Raw = body
 		return &productResp, err
 	})
 	if err != nil {
@@ -234,12 +285,27 @@ func (api *ServusSpeedApi) convertToOffer(product *ServusSpeedProductResponse) d
 		Value:       product.ServusSpeedProduct.Discount,
 		Description: "The discount is a fixed discount in Cent",
 	}
-	offer.MonthlyCostInCentWithVoucher = ((offer.MonthlyCostInCent * offer.ContractDurationInMonths) - offer.VoucherDetails.Value) / offer.ContractDurationInMonths
+	if offer.ContractDurationInMonths > 0 {
+		offer.MonthlyCostInCentWithVoucher = ((offer.MonthlyCostInCent * offer.ContractDurationInMonths) - offer.VoucherDetails.Value) / offer.ContractDurationInMonths
+	}
 	offer.InstallationService = product.ServusSpeedProduct.PricingDetails.InstallationService
 
+	offer.ExtraProperties = extraPropertiesFromJSON("servusspeed", product.Raw, servusSpeedProductSchema)
+	// we interpret the discount as absolute discount over the whole contract, keep the raw value in case this turns out wrong
+	offer.SetExtraProperty("servusspeed.discount", strconv.Itoa(product.ServusSpeedProduct.Discount))
+
 	return offer
 }
 
+func (api *ServusSpeedApi) withTransport(transport http.RoundTripper) InternetProviderAPI {
+	return &ServusSpeedApi{transport: transport}
+}
+
 func (api *ServusSpeedApi) GetProviderName() string {
 	return "ServusSpeed"
 }
+
+func (api *ServusSpeedApi) GetFilterCapabilities() FilterCapabilities {
+	// ServusSpeed has no filter parameters
+	return FilterCapabilities{}
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"server/domain"
+	"server/utils"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// servusSpeedSlots caps the product detail requests in flight over all lookups, it is created once the config is loaded
+	servusSpeedSlots = sync.OnceValue(func() chan struct{} {
+		return make(chan struct{}, max(1, utils.Cfg.ServusSpeed.MaxConcurrency))
+	})
+
+	// servusSpeedDetails caches product details by product ID, or by product ID and address for products whose details
+	// depend on the address and with SERVUSSPEED_DETAILS_PER_ADDRESS
+	servusSpeedDetails = utils.NewTTLCache[string, *ServusSpeedProductResponse]()
+
+	productDetailsLookupCounter    = utils.NewCounterVec("servusspeed_product_details_lookups_total")
+	addressDependentDetailsCounter = utils.NewCounterVec("servusspeed_address_dependent_details_total")
+)
+
+type productDetailsFingerprint struct {
+	addressHash string
+	bodyHash    string
+}
+
+// productDetailsFingerprints keeps the last fetched details per product ID to notice if the details depend on the address,
+// such products are remembered and cached per address from then on
+var productDetailsFingerprints = struct {
+	mu               sync.Mutex
+	fingerprints     map[string]productDetailsFingerprint
+	addressDependent map[string]bool
+}{fingerprints: make(map[string]productDetailsFingerprint), addressDependent: make(map[string]bool)}
+
+func productDetailsKey(productID string, addressHash string) string {
+	if utils.Cfg.ServusSpeed.DetailsPerAddress || isAddressDependent(productID) {
+		return productID + ":" + addressHash
+	}
+
+	return productID
+}
+
+func isAddressDependent(productID string) bool {
+	productDetailsFingerprints.mu.Lock()
+	defer productDetailsFingerprints.mu.Unlock()
+
+	return productDetailsFingerprints.addressDependent[productID]
+}
+
+// cachedProductDetails returns the details of a product from the cache, or fetches them once a request slot is free
+func (api *ServusSpeedApi) cachedProductDetails(ctx context.Context, productID string, address domain.Address) (*ServusSpeedProductResponse, error) {
+	addressHash := domain.GetHashByAddress(address)
+	key := productDetailsKey(productID, addressHash)
+	if product, ok := servusSpeedDetails.Get(key); ok {
+		productDetailsLookupCounter.Inc("hit")
+		return product, nil
+	}
+	productDetailsLookupCounter.Inc("miss")
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case servusSpeedSlots() <- struct{}{}:
+	}
+	product, err := api.getProductDetails(ctx, productID, address)
+	<-servusSpeedSlots()
+	if err != nil {
+		return nil, err
+	}
+
+	api.checkAddressDependence(productID, addressHash, product)
+	// the product may just have turned out to depend on the address
+	servusSpeedDetails.Set(productDetailsKey(productID, addressHash), product, time.Duration(utils.Cfg.ServusSpeed.DetailsTTL)*time.Second)
+
+	return product, nil
+}
+
+// checkAddressDependence notices if the same product returned different details for two addresses, in that case the
+// details must not be shared between addresses. The product is cached per address from then on and its shared entry is
+// dropped
+func (api *ServusSpeedApi) checkAddressDependence(productID string, addressHash string, product *ServusSpeedProductResponse) {
+	fingerprint := productDetailsFingerprint{addressHash: addressHash, bodyHash: utils.HashURLEncoded(product.Raw)}
+
+	productDetailsFingerprints.mu.Lock()
+	previous, ok := productDetailsFingerprints.fingerprints[productID]
+	productDetailsFingerprints.fingerprints[productID] = fingerprint
+	dependent := ok && previous.addressHash != addressHash && previous.bodyHash != fingerprint.bodyHash
+	known := productDetailsFingerprints.addressDependent[productID]
+	if dependent {
+		productDetailsFingerprints.addressDependent[productID] = true
+	}
+	productDetailsFingerprints.mu.Unlock()
+
+	if !dependent {
+		return
+	}
+
+	addressDependentDetailsCounter.Inc(api.GetProviderName())
+	if known || utils.Cfg.ServusSpeed.DetailsPerAddress {
+		return
+	}
+
+	servusSpeedDetails.Delete(productID)
+	log.WithFields(log.Fields{
+		"provider":  api.GetProviderName(),
+		"productId": productID,
+	}).Info("Product details differ between addresses, caching them per address")
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"server/domain"
+	"sync/atomic"
+	"testing"
+)
+
+func TestProductDetailsCachedPerAddressOnceTheyDiffer(t *testing.T) {
+	dsl, err := os.ReadFile(filepath.Join(goldenDir, "servusspeed", "product-details-dsl.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fiber, err := os.ReadFile(filepath.Join(goldenDir, "servusspeed", "product-details-fiber.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := conformanceAddress
+	other.ZipCode = "10115"
+
+	// the product is a fiber product only at the other address
+	var requests atomic.Int64
+	stub := newConformanceStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		var request ServusSpeedRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if request.Address.Postleitzahl == other.ZipCode {
+			w.Write(fiber)
+		} else {
+			w.Write(dsl)
+		}
+	}))
+	defer stub.close()
+	api := (&ServusSpeedApi{}).withTransport(stub).(*ServusSpeedApi)
+
+	const productID = "address-dependent"
+	dependentBefore := addressDependentDetailsCounter.Get(api.GetProviderName())
+
+	lookup := func(address domain.Address, want domain.ConnectionType, wantRequests int64) {
+		t.Helper()
+		sent := requests.Load()
+		product, err := api.cachedProductDetails(context.Background(), productID, address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := api.convertToOffer(product).ConnectionType; got != want {
+			t.Errorf("details for %s are %s, want %s", address.ZipCode, got, want)
+		}
+		if got := requests.Load() - sent; got != wantRequests {
+			t.Errorf("lookup for %s sent %d requests, want %d", address.ZipCode, got, wantRequests)
+		}
+	}
+
+	lookup(conformanceAddress, domain.DSL, 1)
+	lookup(conformanceAddress, domain.DSL, 0)
+	// until they differ the details are shared between addresses
+	lookup(other, domain.DSL, 0)
+
+	// once the shared entry expired the details of the other address differ
+	servusSpeedDetails.Delete(productID)
+	lookup(other, domain.FIBER, 1)
+	if _, ok := servusSpeedDetails.Get(productID); ok {
+		t.Errorf("the shared details are still cached")
+	}
+	if got := addressDependentDetailsCounter.Get(api.GetProviderName()) - dependentBefore; got != 1 {
+		t.Errorf("address dependent details were counted %d times, want 1", got)
+	}
+
+	lookup(conformanceAddress, domain.DSL, 1)
+	lookup(conformanceAddress, domain.DSL, 0)
+	lookup(other, domain.FIBER, 0)
+}
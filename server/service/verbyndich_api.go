@@ -7,25 +7,39 @@ import (
 	"io"
 	"net/http"
 	"net/url"
-	"regexp"
 	"server/domain"
 	"server/utils"
 	"strconv"
 	"strings"
 	"sync"
-	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
 )
 
-type VerbyndichAPI struct{}
+type VerbyndichAPI struct {
+	// sends the requests upstream, nil for http.DefaultTransport
+	transport http.RoundTripper
+}
 
 type VerbyndichResponse struct {
 	Product     string `json:"product"`
 	Description string `json:"description"`
 	Last        bool   `json:"last"`
 	Valid       bool   `json:"valid"`
+
+	// raw response to capture the fields we do not map
+	Raw json.RawMessage `json:"-"`
+}
+
+var verbynDichSchema = responseSchema{
+	"product":     {Kind: STRING_FIELD, Required: true},
+	"description": {Kind: STRING_FIELD, Required: true},
+	"last":        {Kind: BOOL_FIELD, Required: true},
+	"valid":       {Kind: BOOL_FIELD, Required: true},
 }
 
-func (api *VerbyndichAPI) GetOffersStream(ctx context.Context, address domain.Address, offersChannel *utils.PubSubChannel[domain.Offer], errChannel chan<- error) {
+func (api *VerbyndichAPI) GetOffersStream(ctx context.Context, address domain.Address, filter domain.OfferFilter, offersChannel OfferPublisher, errChannel chan<- error) {
 	// Format the address as required: "street;house number;city;plz"
 	addressStr := fmt.Sprintf("%s;%s;%s;%s",
 		address.Street,
@@ -33,81 +47,132 @@ func (api *VerbyndichAPI) GetOffersStream(ctx context.Context, address domain.Ad
 		address.City,
 		address.ZipCode)
 
-	// Worker pool setup
-	const numWorkers = 5
-	pageChannel := make(chan int, numWorkers*2) // Buffer to prevent blocking
-	var lastPageFound int32                     // Atomic flag to signal when last page is found
+	fetch := newPageFetch()
+	next := 0
+	if pages, ok := knownPageCount(addressStr); ok {
+		// the page count is known from an earlier lookup, so no page past the end is requested
+		api.fetchPages(ctx, addressStr, 0, pages, fetch, offersChannel, errChannel)
+		next = pages
+	}
+	if fetch.last() < 0 {
+		// unknown or outdated page count, pages are requested until one reports to be the last
+		api.fetchPages(ctx, addressStr, next, -1, fetch, offersChannel, errChannel)
+	}
+
+	if last := fetch.last(); last >= 0 {
+		rememberPageCount(addressStr, last+1)
+	}
+	if wasted := fetch.wasted(); wasted > 0 {
+		// pages past the last page were requested in vain, they do not count against a rejected address
+		discardRequests(ctx, wasted)
+		wastedPagesCounter.Add(api.GetProviderName(), int64(wasted))
+		log.WithFields(log.Fields{
+			"provider": api.GetProviderName(),
+			"wasted":   wasted,
+			"pages":    fetch.last() + 1,
+		}).Debug("Fetched pages past the last page")
+	}
+}
+
+// fetchPages fetches the pages from (inclusive) to to (exclusive, -1 for no end) in parallel, as many as the limiter allows.
+// No page is requested once the last page is known
+func (api *VerbyndichAPI) fetchPages(ctx context.Context, addressStr string, from int, to int, fetch *pageFetch, offersChannel OfferPublisher, errChannel chan<- error) {
+	limiter := verbynDichLimiter()
 
-	// Start workers
 	var wg sync.WaitGroup
-	for i := 0; i < numWorkers; i++ {
+	for page := from; to < 0 || page < to; page++ {
+		if fetch.beyond(page) {
+			break
+		}
+		if err := limiter.Acquire(ctx); err != nil {
+			break
+		}
+		// the last page may have been found while waiting for a free slot
+		if fetch.beyond(page) {
+			limiter.Cancel()
+			break
+		}
+
 		wg.Add(1)
-		go func() {
+		go func(page int) {
 			defer wg.Done()
-			api.worker(ctx, addressStr, pageChannel, &lastPageFound, offersChannel, errChannel)
-		}()
+			api.processPage(ctx, addressStr, page, limiter, fetch, offersChannel, errChannel)
+		}(page)
+	}
+
+	wg.Wait()
+}
+
+func (api *VerbyndichAPI) processPage(ctx context.Context, addressStr string, page int, limiter *utils.AIMDLimiter, fetch *pageFetch, offersChannel OfferPublisher, errChannel chan<- error) {
+	start := time.Now()
+	response, err := api.fetchPage(ctx, addressStr, page)
+	if ctx.Err() != nil {
+		limiter.Cancel()
+		return
 	}
+	limiter.Release(time.Since(start), err != nil)
 
-	// Page dispatcher - sends page numbers to workers
-	go func() {
-		defer close(pageChannel)
-		page := 0
-		for {
-			// Check if we should stop dispatching
-			if atomic.LoadInt32(&lastPageFound) == 1 {
-				return
-			}
-
-			select {
-			case <-ctx.Done():
-				return
-			case pageChannel <- page:
-				page++
-			}
+	if err != nil {
+		fetch.failed()
+		select {
+		case <-ctx.Done():
+		case errChannel <- err:
 		}
-	}()
+		return
+	}
+	fetch.succeeded(page, response.Last)
 
-	// Wait for all workers to finish
-	wg.Wait()
+	// addresses VerbynDich does not know are answered with a single invalid page
+	if page == 0 && response.Last && !response.Valid {
+		providerErrorsCounter.Inc(api.GetProviderName() + ":" + string(ADDRESS_REJECTED))
+		select {
+		case <-ctx.Done():
+		case errChannel <- &ProviderError{Provider: api.GetProviderName(), Kind: ADDRESS_REJECTED, Message: "the only page is invalid"}:
+		}
+		return
+	}
+
+	// Process the offer if it's valid
+	if response.Valid {
+		// partially parsed offers are published anyway, the validation decides whether they reach the user
+		offer, result := api.responseToOffer(response)
+		api.reportParseResult(ctx, offer, result, errChannel)
+		offersChannel.Publish(offer)
+	}
+}
+
+// responseToOffer converts a page to a domain.Offer object by parsing its description
+func (api *VerbyndichAPI) responseToOffer(response *VerbyndichResponse) (domain.Offer, DescriptionParseResult) {
+	offer := domain.Offer{}
+	offer.ProductName = response.Product
+
+	result := parseVerbynDichDescription(response.Description, &offer)
+
+	offer.ExtraProperties = mergeExtraProperties(extraPropertiesFromJSON("verbyndich", response.Raw, verbynDichSchema), offer.ExtraProperties)
+	offer.Provider = api.GetProviderName()
+	offer.HelperIsPreliminary = false
+
+	return offer, result
 }
 
-func (api *VerbyndichAPI) worker(ctx context.Context, addressStr string, pageChannel <-chan int, lastPageFound *int32, offersChannel *utils.PubSubChannel[domain.Offer], errChannel chan<- error) {
-	for {
+var unmatchedSentencesCounter = utils.NewCounterVec("verbyndich_unmatched_sentences_total")
+
+// reportParseResult logs unmatched sentences and reports incomplete descriptions as error
+func (api *VerbyndichAPI) reportParseResult(ctx context.Context, offer domain.Offer, result DescriptionParseResult, errChannel chan<- error) {
+	if len(result.UnmatchedSentences) > 0 {
+		unmatchedSentencesCounter.Add(api.GetProviderName(), int64(len(result.UnmatchedSentences)))
+		log.WithFields(log.Fields{
+			"provider":  api.GetProviderName(),
+			"product":   offer.ProductName,
+			"sentences": result.UnmatchedSentences,
+		}).Warn("Unmatched sentences in offer description")
+	}
+
+	if !result.IsComplete() {
 		select {
 		case <-ctx.Done():
-			return
-		case page, ok := <-pageChannel:
-			if !ok {
-				return // Channel closed, no more work
-			}
-
-			// Process this page
-			response, err := api.fetchPage(ctx, addressStr, page)
-			if err != nil {
-				select {
-				case <-ctx.Done():
-					return
-				case errChannel <- err:
-				}
-				continue
-			}
-
-			// Check if this is the last page
-			if response.Last {
-				atomic.StoreInt32(lastPageFound, 1)
-			}
-
-			// Process the offer if it's valid
-			if response.Valid {
-				offer := domain.Offer{}
-				offer.ProductName = response.Product
-
-				if err := api.parseVerbyndichDescription(response.Description, &offer); err == nil {
-					offer.Provider = api.GetProviderName()
-					offer.HelperIsPreliminary = false
-					offersChannel.Publish(offer)
-				}
-			}
+		case errChannel <- fmt.Errorf("%s: description of %s parsed only partially (confidence %.2f), missing %v",
+			api.GetProviderName(), offer.ProductName, result.Confidence, result.MissingFields):
 		}
 	}
 }
@@ -121,7 +186,6 @@ func (api *VerbyndichAPI) fetchPage(ctx context.Context, addressStr string, page
 	}
 
 	q := u.Query()
-	q.Add("apiKey", utils.Cfg.VerbynDich.ApiKey)
 	q.Add("page", strconv.Itoa(page))
 	u.RawQuery = q.Encode()
 
@@ -133,8 +197,9 @@ func (api *VerbyndichAPI) fetchPage(ctx context.Context, addressStr string, page
 			return nil, fmt.Errorf("%s: failed to create request: %w", api.GetProviderName(), err)
 		}
 
-		client := &http.Client{}
-		resp, err := client.Do(req)
+		// the api key is added to the query per attempt
+		client := newProviderClient(api.GetProviderName(), api.transport)
+		resp, err := doAuthenticated(client, req, verbynDichAuth)
 		if err != nil {
 			return nil, err
 		}
@@ -142,123 +207,31 @@ func (api *VerbyndichAPI) fetchPage(ctx context.Context, addressStr string, page
 
 		// Check the response status
 		if resp.StatusCode != http.StatusOK {
-			bodyBytes, _ := io.ReadAll(resp.Body)
-			return nil, fmt.Errorf("%s: received non-200 response: %d with body %s", api.GetProviderName(), resp.StatusCode, bodyBytes)
+			return nil, fmt.Errorf("%s: received non-200 response: %d with body %s", api.GetProviderName(), resp.StatusCode, readErrorBody(resp))
 		}
 
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		checkJSONSchema(ctx, api.GetProviderName(), "page", verbynDichSchema, body)
+
 		var response VerbyndichResponse
-		err = json.NewDecoder(resp.Body).Decode(&response)
+		err = json.Unmarshal(body, &response)
+		response.Raw = body
 		return &response, err
 	})
 }
 
-// have to do it in multiple regex because i was not able to write a regex with multiple options after each other
-var regexPatterns = []func(string, *domain.Offer) error{
-	func(description string, offer *domain.Offer) error {
-		regexPattern := regexp.MustCompile(strings.Join([]string{
-			`(?s)`,
-			`.*?`,
-			`nur\s+(?P<Price>\d+)€\s+im\s+Monat`,
-			`.*?`,
-			`(?P<Type>DSL|Cable|Fiber)\-Verbindung`,
-			`.*?`,
-			`Geschwindigkeit\s+von\s+(?P<Speed>\d+)\s+Mbit\/s`,
-			`.*?`,
-			`Mindestvertragslaufzeit\s+(?P<MinContract>\d+)\s*Monate`,
-			`.*?`,
-			`Ab\s+dem\s+24\.\s+Monat\s+beträgt\s+der\s+monatliche\s+Preis\s+(?P<TwoYearsCost>\d+)€`,
-			`.*`,
-		}, ""))
-		names := regexPattern.SubexpNames()
-		if m := regexPattern.FindStringSubmatch(description); m != nil {
-			for i, name := range names {
-				if name == "" || m[i] == "" {
-					continue
-				}
-				switch name {
-				case "Price":
-					costInEuro, _ := strconv.Atoi(m[i])
-					offer.MonthlyCostInCent = costInEuro * 100
-				case "Type":
-					offer.ConnectionType = domain.FromStringToConnectionType(m[i])
-				case "Speed":
-					offer.Speed, _ = strconv.Atoi(m[i])
-				case "MinContract":
-					offer.ContractDurationInMonths, _ = strconv.Atoi(m[i])
-				case "TwoYearsCost":
-					costInEuro, _ := strconv.Atoi(m[i])
-					offer.AfterTwoYearsMonthlyCost = costInEuro * 100
-				}
-			}
-		} else {
-			// non optional matches so return error
-			return fmt.Errorf("no match for offer %s with description %s", offer.ProductName, description)
-		}
-
-		return nil
-	}, // non optional matches
-	func(description string, offer *domain.Offer) error {
-		regexPattern := regexp.MustCompile(`(?s).*?Fernsehsender\s+enthalten\s+([^.]+).*`)
-		if matches := regexPattern.FindStringSubmatch(description); len(matches) > 1 {
-			// matches[0] is the full match, matches[1] is the first capture group
-			offer.Tv = matches[1]
-		}
-
-		return nil
-	}, //optional
-	func(description string, offer *domain.Offer) error {
-		regexPattern := regexp.MustCompile(`(?s).*?Ab\s+(\d+)GB\s+pro\s+Monat\s+wird\s+die\s+Geschwindigkeit\s+gedrosselt.*`)
-		if matches := regexPattern.FindStringSubmatch(description); len(matches) > 1 {
-			// matches[0] is the full match, matches[1] is the first capture group
-			offer.LimitInGb, _ = strconv.Atoi(matches[1])
-		}
-
-		return nil
-	}, //optional
-	func(description string, offer *domain.Offer) error {
-		regexPattern := regexp.MustCompile(`(?s).*?nur\s+für\s+Personen\s+unter\s+(\d+)\s+Jahren.*`)
-		if matches := regexPattern.FindStringSubmatch(description); len(matches) > 1 {
-			// matches[0] is the full match, matches[1] is the first capture group
-			offer.MaxAgePerson, _ = strconv.Atoi(matches[1])
-		}
-
-		return nil
-	}, //optional
-    func(description string, offer *domain.Offer) error {
-        regexPattern := regexp.MustCompile(`(?s).*?(Rabatt\s+von\s+(\d+)%.*?maximale\s+Rabatt\s+beträgt\s+?(\d+)€).*`)
-        if matches := regexPattern.FindStringSubmatch(description); len(matches) > 2 {
-            // matches[0] is the full string
-            // matches[1] is just the discount description text
-            // matches[2] is the percentage value
-            // matches[3] is the maximum discount in euros
-            voucherValuePerc, _ := strconv.Atoi(matches[2])
-            maxVoucherValueEuro, _ := strconv.Atoi(matches[3])
-    
-            offer.VoucherDetails = domain.VoucherDetails{
-                Type:  domain.PERCENTAGE,
-                Value: voucherValuePerc,
-                Description: matches[1],
-            }
-    
-            discountOverContractDuration := min((offer.MonthlyCostInCent * offer.ContractDurationInMonths * voucherValuePerc) / 100, maxVoucherValueEuro * 100)
-            offer.MonthlyCostInCentWithVoucher = offer.MonthlyCostInCent - discountOverContractDuration / offer.ContractDurationInMonths
-        }
-    
-        return nil
-    }, //optional
-}
-
-func (api *VerbyndichAPI) parseVerbyndichDescription(description string, offer *domain.Offer) error {
-	for _, patternFunc := range regexPatterns {
-		err := patternFunc(description, offer)
-		if err != nil {
-			return fmt.Errorf("error parsing description: %v", err)
-		}
-	}
-
-	return nil
+func (api *VerbyndichAPI) withTransport(transport http.RoundTripper) InternetProviderAPI {
+	return &VerbyndichAPI{transport: transport}
 }
 
 func (api *VerbyndichAPI) GetProviderName() string {
 	return "VerbynDich"
 }
+
+func (api *VerbyndichAPI) GetFilterCapabilities() FilterCapabilities {
+	// VerbynDich has no filter parameters
+	return FilterCapabilities{}
+}
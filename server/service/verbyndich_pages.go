@@ -0,0 +1,106 @@
+package service
+
+import (
+	"server/utils"
+	"sync"
+	"time"
+)
+
+// initialVerbynDichConcurrency is the number of pages fetched in parallel before the limiter adapted to VerbynDich
+const initialVerbynDichConcurrency = 5
+
+// maxConsecutivePageErrors stops the search for the last page if VerbynDich keeps failing
+const maxConsecutivePageErrors = 3
+
+var (
+	// the limiter is shared by all lookups as it protects VerbynDich, it is created once the config is loaded
+	verbynDichLimiter = sync.OnceValue(func() *utils.AIMDLimiter {
+		return utils.NewAIMDLimiter(initialVerbynDichConcurrency, 1, utils.Cfg.VerbynDich.MaxConcurrency)
+	})
+
+	wastedPagesCounter     = utils.NewCounterVec("verbyndich_wasted_pages_total")
+	pageCountLookupCounter = utils.NewCounterVec("verbyndich_page_count_lookups_total")
+)
+
+// verbynDichPageCounts remembers how many pages VerbynDich returned for an address, so later lookups fetch exactly these pages
+var verbynDichPageCounts = utils.NewTTLCache[string, int]()
+
+func knownPageCount(address string) (int, bool) {
+	pages, ok := verbynDichPageCounts.Get(address)
+	if ok {
+		pageCountLookupCounter.Inc("hit")
+	} else {
+		pageCountLookupCounter.Inc("miss")
+	}
+
+	return pages, ok
+}
+
+func rememberPageCount(address string, pages int) {
+	verbynDichPageCounts.Set(address, pages, time.Duration(utils.Cfg.VerbynDich.PageCountTTL)*time.Second)
+}
+
+// pageFetch tracks the pages of one lookup, pages are fetched out of order
+type pageFetch struct {
+	mu                sync.Mutex
+	lastPage          int // -1 until a page reports to be the last one
+	fetched           []int
+	consecutiveErrors int
+}
+
+func newPageFetch() *pageFetch {
+	return &pageFetch{lastPage: -1}
+}
+
+func (f *pageFetch) succeeded(page int, last bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.fetched = append(f.fetched, page)
+	f.consecutiveErrors = 0
+	if last && (f.lastPage < 0 || page < f.lastPage) {
+		f.lastPage = page
+	}
+}
+
+func (f *pageFetch) failed() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.consecutiveErrors++
+}
+
+// last returns the index of the last page or -1 if it is not known yet
+func (f *pageFetch) last() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.lastPage
+}
+
+// beyond reports whether the page does not have to be fetched anymore
+func (f *pageFetch) beyond(page int) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return (f.lastPage >= 0 && page > f.lastPage) || f.consecutiveErrors >= maxConsecutivePageErrors
+}
+
+// wasted returns the number of pages fetched after the last page
+func (f *pageFetch) wasted() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.lastPage < 0 {
+		return 0
+	}
+
+	wasted := 0
+	for _, page := range f.fetched {
+		if page > f.lastPage {
+			wasted++
+		}
+	}
+
+	return wasted
+}
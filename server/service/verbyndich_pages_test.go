@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"server/domain"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// pageCountStub answers VerbynDich pages without offers, the number of pages can change between lookups
+type pageCountStub struct {
+	pages atomic.Int64
+
+	mu        sync.Mutex
+	requested []int
+}
+
+func (s *pageCountStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		http.Error(w, "invalid page", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.requested = append(s.requested, page)
+	s.mu.Unlock()
+
+	// pages past the end are answered as last page, as VerbynDich does
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"product": "", "description": "", "last": %t, "valid": false}`, page >= int(s.pages.Load())-1)
+}
+
+// lookup runs a lookup of the address and returns the pages it requested in order
+func (s *pageCountStub) lookup(api InternetProviderAPI, address domain.Address) []int {
+	s.mu.Lock()
+	s.requested = nil
+	s.mu.Unlock()
+
+	errs := make(chan error)
+	go func() {
+		for range errs {
+		}
+	}()
+	api.GetOffersStream(context.Background(), address, domain.OfferFilter{}, discardPublisher{}, errs)
+	close(errs)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slices.Sort(s.requested)
+	return slices.Clone(s.requested)
+}
+
+// pageCountKey is the address as VerbynDich is asked for it, its page count is remembered under it
+func pageCountKey(t *testing.T, address domain.Address) string {
+	key := fmt.Sprintf("%s;%s;%s;%s", address.Street, address.HouseNumber, address.City, address.ZipCode)
+	verbynDichPageCounts.Delete(key)
+	t.Cleanup(func() { verbynDichPageCounts.Delete(key) })
+	return key
+}
+
+func TestVerbynDichRemembersPageCount(t *testing.T) {
+	stub := &pageCountStub{}
+	server := newConformanceStub(stub)
+	defer server.close()
+	api := (&VerbyndichAPI{}).withTransport(server)
+
+	address := pageCountKey(t, conformanceAddress)
+
+	for _, tc := range []struct {
+		name  string
+		pages int
+		// pages a lookup requests at least, and whether it requests no other page
+		want  int
+		exact bool
+	}{
+		{name: "unknown page count", pages: 6, want: 6},
+		{name: "known page count", pages: 6, want: 6, exact: true},
+		{name: "more pages than known", pages: 9, want: 9},
+		{name: "grown page count is known", pages: 9, want: 9, exact: true},
+		{name: "fewer pages than known", pages: 3, want: 3},
+		{name: "shrunk page count is known", pages: 3, want: 3, exact: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			stub.pages.Store(int64(tc.pages))
+			requested := stub.lookup(api, conformanceAddress)
+
+			for page := range tc.want {
+				if !slices.Contains(requested, page) {
+					t.Errorf("page %d was not requested, requested %v", page, requested)
+				}
+			}
+			if tc.exact && len(requested) != tc.want {
+				t.Errorf("requested pages %v, want exactly the %d known pages", requested, tc.want)
+			}
+			if pages, ok := verbynDichPageCounts.Get(address); !ok || pages != tc.pages {
+				t.Errorf("remembered %d pages, want %d", pages, tc.pages)
+			}
+		})
+	}
+
+	// the page count is remembered per address
+	other := conformanceAddress
+	other.HouseNumber = "2"
+	otherKey := pageCountKey(t, other)
+	stub.pages.Store(5)
+	stub.lookup(api, other)
+	if pages, _ := verbynDichPageCounts.Get(otherKey); pages != 5 {
+		t.Errorf("remembered %d pages for another address, want 5", pages)
+	}
+	if pages, _ := verbynDichPageCounts.Get(address); pages != 3 {
+		t.Errorf("the lookup of another address changed the page count to %d, want 3", pages)
+	}
+}
@@ -0,0 +1,257 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"server/domain"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// offer fields extracted from a VerbynDich description, used to calculate the confidence of a parse
+const (
+	PRICE_FIELD           = "price"
+	CONNECTION_FIELD      = "connectionType"
+	SPEED_FIELD           = "speed"
+	CONTRACT_FIELD        = "contractDuration"
+	AFTER_TWO_YEARS_FIELD = "afterTwoYearsPrice"
+	TV_FIELD              = "tv"
+	LIMIT_FIELD           = "limit"
+	MAX_AGE_FIELD         = "maxAge"
+	VOUCHER_FIELD         = "voucher"
+	MAX_DISCOUNT_FIELD    = "maxDiscount"
+)
+
+// requiredDescriptionFields have to be found in every description for an offer to be complete
+var requiredDescriptionFields = []string{PRICE_FIELD, CONNECTION_FIELD, SPEED_FIELD, CONTRACT_FIELD}
+
+// descriptionState collects the values of all sentences, values depending on each other are calculated once all sentences are parsed
+type descriptionState struct {
+	offer                   *domain.Offer
+	voucherPercentage       int
+	maxDiscountInEuro       int
+	absoluteDiscountInEuro  int
+	voucherDescriptionParts []string
+}
+
+// descriptionRule extracts the values of one kind of sentence, the pattern has to match the whole sentence
+type descriptionRule struct {
+	name    string
+	pattern *regexp.Regexp
+	fields  []string
+	apply   func(match []string, state *descriptionState) error
+}
+
+// newDescriptionRule compiles the pattern once, patterns are matched case insensitive against whole sentences
+func newDescriptionRule(name string, pattern string, fields []string, apply func(match []string, state *descriptionState) error) descriptionRule {
+	return descriptionRule{
+		name:    name,
+		pattern: regexp.MustCompile(`(?is)^` + pattern + `$`),
+		fields:  fields,
+		apply:   apply,
+	}
+}
+
+// ignore is used for rules of sentences which are known marketing text
+func ignore([]string, *descriptionState) error {
+	return nil
+}
+
+// verbynDichRules is the rule table of all known sentences in German and English, the order of sentences in a description does not matter
+var verbynDichRules = []descriptionRule{
+	newDescriptionRule("marketing", `(Dieses\s+einzigartige\s+Angebot\s+ist\s+der\s+perfekte\s+Match\s+für\s+Sie|This\s+unique\s+offer\s+is\s+the\s+perfect\s+match\s+for\s+you)\.`, nil, ignore),
+	newDescriptionRule("call to action", `(Zögern\s+Sie\s+nicht\s+und\s+schlagen\s+Sie\s+jetzt\s+zu|Don'?t\s+hesitate\s+and\s+grab\s+it\s+now)!`, nil, ignore),
+	newDescriptionRule("price and connection",
+		`(?:Für\s+nur\s+(\d+)€\s+im\s+Monat\s+erhalten\s+Sie\s+eine\s+(DSL|Cable|Fiber|Mobile)-Verbindung\s+mit\s+einer\s+Geschwindigkeit\s+von\s+(\d+)\s+Mbit/s`+
+			`|For\s+only\s+(\d+)€\s+(?:a|per)\s+month\s+you\s+get\s+an?\s+(DSL|Cable|Fiber|Mobile)\s+connection\s+with\s+a\s+speed\s+of\s+(\d+)\s+Mbit/s)\.`,
+		[]string{PRICE_FIELD, CONNECTION_FIELD, SPEED_FIELD},
+		func(match []string, state *descriptionState) error {
+			// the German and the English variant use different groups
+			price, connectionType, speed := match[1], match[2], match[3]
+			if price == "" {
+				price, connectionType, speed = match[4], match[5], match[6]
+			}
+
+			costInEuro, err := strconv.Atoi(price)
+			if err != nil {
+				return err
+			}
+			state.offer.MonthlyCostInCent = costInEuro * 100
+			state.offer.ConnectionType = domain.FromStringToConnectionType(connectionType)
+			state.offer.Speed, err = strconv.Atoi(speed)
+			return err
+		}),
+	newDescriptionRule("contract duration",
+		`(?:Bitte\s+beachten\s+Sie,\s+dass\s+die\s+Mindestvertragslaufzeit\s+(\d+)\s*Monate\s+beträgt|Please\s+note\s+that\s+the\s+minimum\s+contract\s+(?:term|duration)\s+is\s+(\d+)\s*months)\.`,
+		[]string{CONTRACT_FIELD},
+		func(match []string, state *descriptionState) (err error) {
+			state.offer.ContractDurationInMonths, err = strconv.Atoi(match[1] + match[2])
+			return err
+		}),
+	newDescriptionRule("price after two years",
+		`(?:Ab\s+dem\s+24\.\s+Monat\s+beträgt\s+der\s+monatliche\s+Preis\s+(\d+)€|From\s+the\s+24th\s+month(?:\s+on)?,?\s+the\s+monthly\s+price\s+is\s+(\d+)€)\.`,
+		[]string{AFTER_TWO_YEARS_FIELD},
+		func(match []string, state *descriptionState) error {
+			costInEuro, err := strconv.Atoi(match[1] + match[2])
+			state.offer.AfterTwoYearsMonthlyCost = costInEuro * 100
+			return err
+		}),
+	newDescriptionRule("tv",
+		`(?:Zusätzlich\s+sind\s+folgende\s+Fernsehsender\s+enthalten|Additionally\s+the\s+following\s+TV\s+channels\s+are\s+included:?)\s+([^.]+)\.`,
+		[]string{TV_FIELD},
+		func(match []string, state *descriptionState) error {
+			state.offer.Tv = match[1]
+			return nil
+		}),
+	newDescriptionRule("limit",
+		`(?:Ab\s+(\d+)GB\s+pro\s+Monat\s+wird\s+die\s+Geschwindigkeit\s+gedrosselt|From\s+(\d+)\s*GB\s+per\s+month\s+the\s+speed\s+is\s+throttled)\.`,
+		[]string{LIMIT_FIELD},
+		func(match []string, state *descriptionState) (err error) {
+			state.offer.LimitInGb, err = strconv.Atoi(match[1] + match[2])
+			return err
+		}),
+	newDescriptionRule("max age",
+		`(?:Dieses\s+Angebot\s+ist\s+nur\s+für\s+Personen\s+unter\s+(\d+)\s+Jahren\s+verfügbar|This\s+offer\s+is\s+only\s+available\s+for\s+persons\s+under\s+(\d+)\s+years(?:\s+of\s+age)?)\.`,
+		[]string{MAX_AGE_FIELD},
+		func(match []string, state *descriptionState) (err error) {
+			state.offer.MaxAgePerson, err = strconv.Atoi(match[1] + match[2])
+			return err
+		}),
+	newDescriptionRule("percentage discount",
+		`(?:Mit\s+diesem\s+Angebot\s+erhalten\s+Sie\s+einen\s+Rabatt\s+von\s+(\d+)%.*|With\s+this\s+offer\s+you\s+get\s+a\s+discount\s+of\s+(\d+)%.*)\.`,
+		[]string{VOUCHER_FIELD},
+		func(match []string, state *descriptionState) (err error) {
+			state.voucherPercentage, err = strconv.Atoi(match[1] + match[2])
+			state.voucherDescriptionParts = append(state.voucherDescriptionParts, match[0])
+			return err
+		}),
+	newDescriptionRule("absolute discount",
+		`(?:Mit\s+diesem\s+Angebot\s+erhalten\s+Sie\s+einen\s+(?:einmaligen\s+)?Rabatt\s+(?:in\s+Höhe\s+)?von\s+(\d+)€.*|With\s+this\s+offer\s+you\s+get\s+a\s+(?:one-time\s+)?discount\s+of\s+(\d+)€.*)\.`,
+		[]string{VOUCHER_FIELD},
+		func(match []string, state *descriptionState) (err error) {
+			state.absoluteDiscountInEuro, err = strconv.Atoi(match[1] + match[2])
+			state.voucherDescriptionParts = append(state.voucherDescriptionParts, match[0])
+			return err
+		}),
+	newDescriptionRule("max discount",
+		`(?:Der\s+maximale\s+Rabatt\s+beträgt\s+(\d+)€|The\s+maximum\s+discount\s+is\s+(\d+)€)\.`,
+		[]string{MAX_DISCOUNT_FIELD},
+		func(match []string, state *descriptionState) (err error) {
+			state.maxDiscountInEuro, err = strconv.Atoi(match[1] + match[2])
+			state.voucherDescriptionParts = append(state.voucherDescriptionParts, match[0])
+			return err
+		}),
+}
+
+// DescriptionParseResult reports how well a description could be parsed, the offer keeps all values found
+type DescriptionParseResult struct {
+	MatchedRules       []string
+	UnmatchedSentences []string
+	MissingFields      []string
+	// share of required fields which were found
+	Confidence float64
+}
+
+func (r DescriptionParseResult) IsComplete() bool {
+	return len(r.MissingFields) == 0
+}
+
+// sentenceEndRegex matches the end of a sentence, dots after numbers like "24. Monat" do not end a sentence
+var sentenceEndRegex = regexp.MustCompile(`[^\d]([.!])\s+`)
+
+// splitSentences splits a description into its trimmed sentences
+func splitSentences(description string) []string {
+	var sentences []string
+	start := 0
+	for _, match := range sentenceEndRegex.FindAllStringSubmatchIndex(description, -1) {
+		// match[3] is the end of the punctuation, match[1] the end of the following whitespace
+		if sentence := strings.TrimSpace(description[start:match[3]]); sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+		start = match[1]
+	}
+	if sentence := strings.TrimSpace(description[start:]); sentence != "" {
+		sentences = append(sentences, sentence)
+	}
+
+	return sentences
+}
+
+// parseVerbynDichDescription matches every sentence of the description against the rule table and sets the values found on the offer.
+// Sentences no rule matches are kept in the extra properties of the offer
+func parseVerbynDichDescription(description string, offer *domain.Offer) DescriptionParseResult {
+	state := &descriptionState{offer: offer}
+	result := DescriptionParseResult{}
+	found := make(map[string]bool)
+
+	for _, sentence := range splitSentences(description) {
+		matched := false
+		for _, rule := range verbynDichRules {
+			match := rule.pattern.FindStringSubmatch(sentence)
+			if match == nil {
+				continue
+			}
+			if err := rule.apply(match, state); err != nil {
+				continue
+			}
+
+			matched = true
+			result.MatchedRules = append(result.MatchedRules, rule.name)
+			for _, field := range rule.fields {
+				found[field] = true
+			}
+			break
+		}
+
+		if !matched {
+			offer.SetExtraProperty(fmt.Sprintf("verbyndich.description.unparsed.%d", len(result.UnmatchedSentences)), sentence)
+			result.UnmatchedSentences = append(result.UnmatchedSentences, sentence)
+		}
+	}
+
+	state.applyVoucher()
+
+	for _, field := range requiredDescriptionFields {
+		if !found[field] {
+			result.MissingFields = append(result.MissingFields, field)
+		}
+	}
+	result.Confidence = float64(len(requiredDescriptionFields)-len(result.MissingFields)) / float64(len(requiredDescriptionFields))
+
+	return result
+}
+
+// applyVoucher calculates the monthly price with voucher once price and contract duration are known
+func (state *descriptionState) applyVoucher() {
+	offer := state.offer
+	if len(state.voucherDescriptionParts) == 0 {
+		return
+	}
+	description := strings.Join(slices.Compact(state.voucherDescriptionParts), " ")
+
+	switch {
+	case state.voucherPercentage > 0:
+		offer.VoucherDetails = domain.VoucherDetails{
+			Type:        domain.PERCENTAGE,
+			Value:       state.voucherPercentage,
+			Description: description,
+		}
+		if offer.ContractDurationInMonths > 0 {
+			discountOverContractDuration := offer.MonthlyCostInCent * offer.ContractDurationInMonths * state.voucherPercentage / 100
+			if state.maxDiscountInEuro > 0 {
+				discountOverContractDuration = min(discountOverContractDuration, state.maxDiscountInEuro*100)
+			}
+			offer.MonthlyCostInCentWithVoucher = offer.MonthlyCostInCent - discountOverContractDuration/offer.ContractDurationInMonths
+		}
+	case state.absoluteDiscountInEuro > 0:
+		offer.VoucherDetails = domain.VoucherDetails{
+			Type:        domain.ABSOLUTE,
+			Value:       state.absoluteDiscountInEuro * 100,
+			Description: description,
+		}
+		if offer.ContractDurationInMonths > 0 {
+			offer.MonthlyCostInCentWithVoucher = offer.MonthlyCostInCent - state.absoluteDiscountInEuro*100/offer.ContractDurationInMonths
+		}
+	}
+}
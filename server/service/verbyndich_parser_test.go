@@ -0,0 +1,25 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"server/domain"
+	"testing"
+)
+
+// FuzzVerbynDichDescription makes sure no description makes the rule table panic
+func FuzzVerbynDichDescription(f *testing.F) {
+	for _, payload := range goldenPayloads(f, "verbyndich") {
+		for _, line := range bytes.Split(payload, []byte("\n")) {
+			var response VerbyndichResponse
+			if json.Unmarshal(line, &response) == nil {
+				f.Add(response.Description)
+			}
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, description string) {
+		offer := domain.Offer{}
+		parseVerbynDichDescription(description, &offer)
+	})
+}
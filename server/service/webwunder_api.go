@@ -9,10 +9,16 @@ import (
 	"net/http"
 	"server/domain"
 	"server/utils"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 )
 
-type WebWunderApi struct{}
+type WebWunderApi struct {
+	// sends the requests upstream, nil for http.DefaultTransport
+	transport http.RoundTripper
+}
 
 // WebWunderSoapEnvelope represents the SOAP envelope for the request
 type WebWunderSoapEnvelope struct {
@@ -53,176 +59,389 @@ type WebWunderSoapAddress struct {
 	CountryCode string   `xml:"gs:countryCode"`
 }
 
-// WebWunderSoapResponse represents the SOAP response structure
-type WebWunderSoapResponse struct {
-	XMLName xml.Name `xml:"http://schemas.xmlsoap.org/soap/envelope/ Envelope"`
-	Body    struct {
-		XMLName xml.Name `xml:"http://schemas.xmlsoap.org/soap/envelope/ Body"`
-		Output  struct {
-			XMLName  xml.Name               `xml:"Output"`
-			Products []WebWunderSoapProduct `xml:"products"`
-		} `xml:"Output"`
-	} `xml:"Body"`
-}
-
 // WebWunderSoapProduct represents a product in the SOAP response as per WSDL spec
 type WebWunderSoapProduct struct {
-	XMLName      xml.Name                  `xml:"products"`
-	ProductID    int                       `xml:"productId"`
-	ProviderName string                    `xml:"providerName"`
-	ProductInfo  *WebWunderSoapProductInfo `xml:"productInfo,omitempty"`
+	XMLName      xml.Name                   `xml:"products"`
+	ProductID    int                        `xml:"productId"`
+	ProviderName string                     `xml:"providerName"`
+	ProductInfo  *WebWunderSoapProductInfo  `xml:"productInfo,omitempty"`
+	Unmapped     []WebWunderUnmappedElement `xml:",any"`
+}
+
+// WebWunderUnmappedElement captures elements which are not part of the WSDL spec
+type WebWunderUnmappedElement struct {
+	XMLName xml.Name
+	Value   string `xml:",innerxml"`
 }
 
 // WebWunderSoapProductInfo represents the product info as per WSDL spec
 type WebWunderSoapProductInfo struct {
-	XMLName                        xml.Name              `xml:"productInfo"`
-	Speed                          int                   `xml:"speed"`
-	MonthlyCostInCent              int                   `xml:"monthlyCostInCent"`
-	MonthlyCostInCentFrom25thMonth int                   `xml:"monthlyCostInCentFrom25thMonth"`
-	Voucher                        *WebWunderSoapVoucher `xml:"voucher,omitempty"`
-	ContractDurationInMonths       int                   `xml:"contractDurationInMonths"`
-	ConnectionType                 string                `xml:"connectionType"`
+	XMLName                        xml.Name                   `xml:"productInfo"`
+	Speed                          int                        `xml:"speed"`
+	MonthlyCostInCent              int                        `xml:"monthlyCostInCent"`
+	MonthlyCostInCentFrom25thMonth int                        `xml:"monthlyCostInCentFrom25thMonth"`
+	Voucher                        *WebWunderSoapVoucher      `xml:"voucher,omitempty"`
+	ContractDurationInMonths       int                        `xml:"contractDurationInMonths"`
+	ConnectionType                 string                     `xml:"connectionType"`
+	Unmapped                       []WebWunderUnmappedElement `xml:",any"`
 }
 
-// WebWunderSoapVoucher represents the voucher in the response
+// WebWunderSoapVoucher represents the voucher in the response.
+// The kind of voucher is not a nested element but given by the xsi:type attribute, e.g. "ns2:percentageVoucher"
 type WebWunderSoapVoucher struct {
-	XMLName           xml.Name `xml:"voucher"`
-	PercentageVoucher *struct {
-		XMLName           xml.Name `xml:"percentageVoucher"`
-		Percentage        int      `xml:"percentage"`
-		MaxDiscountInCent int      `xml:"maxDiscountInCent"`
-	} `xml:"percentageVoucher,omitempty"`
-	AbsoluteVoucher *struct {
-		XMLName             xml.Name `xml:"absoluteVoucher"`
-		DiscountInCent      int      `xml:"discountInCent"`
-		MinOrderValueInCent int      `xml:"minOrderValueInCent"`
-	} `xml:"absoluteVoucher,omitempty"`
+	XMLName             xml.Name                   `xml:"voucher"`
+	Type                string                     `xml:"http://www.w3.org/2001/XMLSchema-instance type,attr"`
+	Percentage          int                        `xml:"percentage"`
+	MaxDiscountInCent   int                        `xml:"maxDiscountInCent"`
+	DiscountInCent      int                        `xml:"discountInCent"`
+	MinOrderValueInCent int                        `xml:"minOrderValueInCent"`
+	Unmapped            []WebWunderUnmappedElement `xml:",any"`
+}
+
+// VoucherType returns the type of the voucher without namespace prefix
+func (v WebWunderSoapVoucher) VoucherType() string {
+	if _, local, found := strings.Cut(v.Type, ":"); found {
+		return local
+	}
+
+	return v.Type
+}
+
+// webWunderAddressFaultCodes are the fault codes WebWunder documents for addresses it does not know. It documents none
+// so far, its faults are client faults then and never cached as rejected address
+var webWunderAddressFaultCodes = map[string]bool{}
+
+// webWunderSchema describes a single products element of the SOAP response
+var webWunderSchema = responseSchema{
+	"productId":                     {Kind: NUMBER_FIELD, Required: true},
+	"providerName":                  {Kind: STRING_FIELD, Required: true},
+	"productInfo":                   {Kind: OBJECT_FIELD, Required: true},
+	"productInfo.speed":             {Kind: NUMBER_FIELD, Required: true},
+	"productInfo.monthlyCostInCent": {Kind: NUMBER_FIELD, Required: true},
+	"productInfo.monthlyCostInCentFrom25thMonth": {Kind: NUMBER_FIELD, Required: true},
+	"productInfo.contractDurationInMonths":       {Kind: NUMBER_FIELD, Required: true},
+	"productInfo.connectionType":                 {Kind: STRING_FIELD, Required: true},
+	"productInfo.voucher":                        {Kind: OBJECT_FIELD},
+	"productInfo.voucher.percentage":             {Kind: NUMBER_FIELD},
+	"productInfo.voucher.maxDiscountInCent":      {Kind: NUMBER_FIELD},
+	"productInfo.voucher.discountInCent":         {Kind: NUMBER_FIELD},
+	"productInfo.voucher.minOrderValueInCent":    {Kind: NUMBER_FIELD},
 }
 
-func (api *WebWunderApi) GetOffersStream(ctx context.Context, address domain.Address, offersChannel *utils.PubSubChannel[domain.Offer], errChannel chan<- error) {
+func (api *WebWunderApi) GetOffersStream(ctx context.Context, address domain.Address, filter domain.OfferFilter, offersChannel OfferPublisher, errChannel chan<- error) {
 	// Create a wait group to wait for all workers to complete
 	var wg sync.WaitGroup
 
-	// Define all connection types to query
-	connectionTypes := []domain.ConnectionType{domain.DSL, domain.CABLE, domain.FIBER, domain.MOBILE}
+	// Define all connection types to query, a connection type filter is evaluated upstream
+	connectionTypes := api.GetFilterCapabilities().ConnectionTypes
+	if filter.ConnectionType != nil {
+		connectionTypes = []domain.ConnectionType{*filter.ConnectionType}
+	}
 
-	// Define installation service options
-	// there can be offers which are available for both - we will keep them separate
+	// with an installation filter only the matching variant is queried and nothing is merged
 	installationOptions := []bool{true, false}
+	if filter.Installation != nil {
+		installationOptions = []bool{*filter.Installation}
+	}
 
-	// Launch a worker for each combination of connection type and installation service
+	// Launch a worker for each connection type, it queries the products with and without installation service in parallel.
+	// A product available with both is published as one offer as soon as both variants are decoded, products only
+	// available with one wait until the other response is complete
 	for _, connType := range connectionTypes {
-		for _, installation := range installationOptions {
-			wg.Add(1)
-			go func(connType domain.ConnectionType, installation bool) {
-				defer wg.Done()
-
-				// Create SOAP request envelope
-				soapEnvelope := WebWunderSoapEnvelope{
-					SoapNS: "http://schemas.xmlsoap.org/soap/envelope/",
-					GsNS:   "http://webwunder.gendev7.check24.fun/offerservice",
-					Header: "",
-					Body: WebWunderSoapBody{
-						LegacyGetInternetOffers: WebWunderSoapRequest{
-							Input: WebWunderSoapInput{
-								Installation:   installation,
-								ConnectionEnum: connType.String(),
-								Address: WebWunderSoapAddress{
-									Street:      address.Street,
-									HouseNumber: address.HouseNumber,
-									City:        address.City,
-									PLZ:         address.ZipCode,
-									CountryCode: "DE", // we only support Germany for now
-								},
-							},
-						},
-					},
-				}
+		wg.Add(1)
+		go func(connType domain.ConnectionType) {
+			defer wg.Done()
 
-				// Marshal the request to XML
-				requestXML, err := xml.MarshalIndent(soapEnvelope, "", "  ")
-				if err != nil {
-					select {
-					case <-ctx.Done():
-						return
-					case errChannel <- fmt.Errorf("%s: failed to marshal SOAP request for %s (installation=%t): %w",
-						api.GetProviderName(), connType.String(), installation, err):
-					}
-					return
-				}
+			variants := &installationVariants{next: offersChannel}
+			if len(installationOptions) == 1 {
+				variants.complete(!installationOptions[0])
+			}
 
-				// Create XML declaration and prepend to the request
-				xmlHeader := []byte(`<?xml version="1.0" encoding="UTF-8"?>`)
-				requestXML = append(xmlHeader, requestXML...)
+			var variantsWg sync.WaitGroup
+			for _, installation := range installationOptions {
+				variantsWg.Add(1)
+				go func() {
+					defer variantsWg.Done()
 
-				// Send the request
-				body, err := utils.RetryWrapper(ctx, func() ([]byte, error) {
-					// Create HTTP request with the SOAP payload and context
-					req, err := http.NewRequestWithContext(ctx, "POST", "https://webwunder.gendev7.check24.fun:443/endpunkte/soap/ws", bytes.NewReader(requestXML))
+					err := api.fetchProducts(ctx, address, connType, installation, variants.variant(installation))
+					variants.complete(installation)
 					if err != nil {
-						return nil, fmt.Errorf("%s: failed to create request: %w", api.GetProviderName(), err)
+						select {
+						case <-ctx.Done():
+						case errChannel <- err:
+						}
 					}
+				}()
+			}
+			variantsWg.Wait()
+		}(connType)
+	}
 
-					// Set necessary headers
-					req.Header.Set("Content-Type", "text/xml; charset=utf-8")
-					req.Header.Set("X-Api-Key", utils.Cfg.WebWunder.ApiKey)
-					req.Header.Set("SOAPAction", "legacyGetInternetOffers")
+	// Wait for all workers to complete
+	wg.Wait()
+}
 
-					client := &http.Client{}
-					resp, err := client.Do(req)
-					if err != nil {
-						return nil, err
-					}
-					defer resp.Body.Close()
+// fetchProducts queries the products of one connection type with or without installation service
+func (api *WebWunderApi) fetchProducts(ctx context.Context, address domain.Address, connType domain.ConnectionType, installation bool, offersChannel OfferPublisher) error {
+	// Create SOAP request envelope
+	soapEnvelope := WebWunderSoapEnvelope{
+		SoapNS: "http://schemas.xmlsoap.org/soap/envelope/",
+		GsNS:   "http://webwunder.gendev7.check24.fun/offerservice",
+		Header: "",
+		Body: WebWunderSoapBody{
+			LegacyGetInternetOffers: WebWunderSoapRequest{
+				Input: WebWunderSoapInput{
+					Installation:   installation,
+					ConnectionEnum: connType.String(),
+					Address: WebWunderSoapAddress{
+						Street:      address.Street,
+						HouseNumber: address.HouseNumber,
+						City:        address.City,
+						PLZ:         address.ZipCode,
+						CountryCode: "DE", // we only support Germany for now
+					},
+				},
+			},
+		},
+	}
 
-					// Check the response status code
-					if resp.StatusCode != http.StatusOK {
-						bodyBytes, _ := io.ReadAll(resp.Body)
-						return nil, fmt.Errorf("%s: received non-200 response: %d with body %s", api.GetProviderName(), resp.StatusCode, bodyBytes)
-					}
+	// Marshal the request to XML
+	requestXML, err := xml.MarshalIndent(soapEnvelope, "", "  ")
+	if err != nil {
+		return fmt.Errorf("%s: failed to marshal SOAP request for %s (installation=%t): %w",
+			api.GetProviderName(), connType.String(), installation, err)
+	}
 
-					return io.ReadAll(resp.Body)
-				})
-				if err != nil {
-					select {
-					case <-ctx.Done():
-						return
-					case errChannel <- err:
-					}
-					return
-				}
+	// Create XML declaration and prepend to the request
+	xmlHeader := []byte(`<?xml version="1.0" encoding="UTF-8"?>`)
+	requestXML = append(xmlHeader, requestXML...)
 
-				// Unmarshal the XML response
-				var soapResponse WebWunderSoapResponse
-				if err := xml.Unmarshal(body, &soapResponse); err != nil {
-					select {
-					case <-ctx.Done():
-						return
-					case errChannel <- fmt.Errorf("%s: failed to unmarshal SOAP response for %s (installation=%v): %w",
-						api.GetProviderName(), connType.String(), installation, err):
-					}
-					return
-				}
+	// The response is streamed inside the retry, so a fault in a 200 response is retried like one with an error status.
+	// Faults replace the products, once products were published the request is not repeated to not publish them twice
+	_, err = utils.RetryWrapper(ctx, func() (struct{}, error) {
+		resp, err := api.sendRequest(ctx, requestXML)
+		if err != nil {
+			return struct{}{}, err
+		}
+		defer resp.Body.Close()
+
+		publisher := &countingPublisher{next: offersChannel}
+		if err := api.streamProducts(ctx, resp.Body, installation, publisher); err != nil {
+			err = fmt.Errorf("%s: failed to decode SOAP response for %s (installation=%v): %w",
+				api.GetProviderName(), connType.String(), installation, err)
+			if publisher.count > 0 {
+				return struct{}{}, utils.NonRetryable(err)
+			}
+			return struct{}{}, err
+		}
 
-				// Convert the SOAP response products to domain offers
-				for _, product := range soapResponse.Body.Output.Products {
-					offer := api.soapProductToOffer(product)
-					offer.Provider = api.GetProviderName()
-					offer.InstallationService = installation
-					offer.HelperIsPreliminary = false
-					offersChannel.Publish(offer)
-					select {
-					case <-ctx.Done():
-						return
-					default:
-					}
-				}
-			}(connType, installation)
+		return struct{}{}, nil
+	})
+	if kind := providerErrorKind(err); kind != "" {
+		providerErrorsCounter.Inc(api.GetProviderName() + ":" + string(kind))
+	}
+
+	return err
+}
+
+// sendRequest posts the SOAP request, faults in responses with an error status are returned as *ProviderError
+func (api *WebWunderApi) sendRequest(ctx context.Context, requestXML []byte) (*http.Response, error) {
+	// Create HTTP request with the SOAP payload and context
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://webwunder.gendev7.check24.fun:443/endpunkte/soap/ws", bytes.NewReader(requestXML))
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to create request: %w", api.GetProviderName(), err)
+	}
+
+	// Set necessary headers
+	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	req.Header.Set("SOAPAction", "legacyGetInternetOffers")
+	// every combination is a single request, the SOAP call only reads
+	req = withHedging(req, api.GetProviderName()+":offers", true)
+
+	client := newProviderClient(api.GetProviderName(), api.transport)
+	resp, err := doAuthenticated(client, req, webWunderAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the response status code, SOAP faults usually come with status 500
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		bodyBytes := []byte(readErrorBody(resp))
+		if fault := parseSoapFault(api.GetProviderName(), bytes.NewReader(bodyBytes)); fault != nil {
+			return nil, withAddressRejection(fault, webWunderAddressFaultCodes)
 		}
+		return nil, fmt.Errorf("%s: received non-200 response: %d with body %s", api.GetProviderName(), resp.StatusCode, bodyBytes)
 	}
 
-	// Wait for all workers to complete
-	wg.Wait()
+	return resp, nil
+}
+
+// countingPublisher counts the offers published through it
+type countingPublisher struct {
+	next  OfferPublisher
+	count int
+}
+
+func (p *countingPublisher) Publish(offer domain.Offer) {
+	p.count++
+	p.next.Publish(offer)
+}
+
+// installationVariants pairs the offers of one connection type with and without installation service. An offer is
+// published once its other variant arrived, or once the other response is complete without it
+type installationVariants struct {
+	next OfferPublisher
+
+	mu sync.Mutex
+	// offers waiting for their other variant, indexed by variantIndex
+	pending [2][]domain.Offer
+	done    [2]bool
+}
+
+func variantIndex(installation bool) int {
+	if installation {
+		return 1
+	}
+	return 0
+}
+
+// variant returns the publisher for the offers of the response with or without installation service
+func (v *installationVariants) variant(installation bool) OfferPublisher {
+	return &installationVariant{variants: v, installation: installation}
+}
+
+// complete marks the response with or without installation service as complete, the offers of the other response
+// still waiting for a variant from it are published as they are
+func (v *installationVariants) complete(installation bool) {
+	v.mu.Lock()
+	v.done[variantIndex(installation)] = true
+	other := variantIndex(!installation)
+	unpaired := v.pending[other]
+	v.pending[other] = nil
+	v.mu.Unlock()
+
+	for _, offer := range unpaired {
+		v.next.Publish(offer)
+	}
+}
+
+// add pairs the offer with a waiting variant of the same product, offers are published outside the lock as the
+// publisher may block
+func (v *installationVariants) add(installation bool, offer domain.Offer) {
+	v.mu.Lock()
+	ready := v.pair(installation, offer)
+	v.mu.Unlock()
+
+	for _, offer := range ready {
+		v.next.Publish(offer)
+	}
+}
+
+// pair returns the offers which are ready to be published, v.mu must be held
+func (v *installationVariants) pair(installation bool, offer domain.Offer) []domain.Offer {
+	self, other := variantIndex(installation), variantIndex(!installation)
+	i := slices.IndexFunc(v.pending[other], func(waiting domain.Offer) bool { return waiting.ProductID == offer.ProductID })
+	if i < 0 {
+		if v.done[other] {
+			return []domain.Offer{offer}
+		}
+		v.pending[self] = append(v.pending[self], offer)
+		return nil
+	}
+
+	withInstallation, withoutInstallation := offer, v.pending[other][i]
+	if !installation {
+		withInstallation, withoutInstallation = withoutInstallation, withInstallation
+	}
+	v.pending[other] = slices.Delete(v.pending[other], i, i+1)
+	if merged, ok := domain.MergeInstallationOptions(withInstallation, withoutInstallation); ok {
+		return []domain.Offer{merged}
+	}
+	// variants differing in more than the installation service are different products
+	return []domain.Offer{withoutInstallation, withInstallation}
+}
+
+type installationVariant struct {
+	variants     *installationVariants
+	installation bool
+}
+
+func (p *installationVariant) Publish(offer domain.Offer) {
+	p.variants.add(p.installation, offer)
+}
+
+// webWunderOutputElement wraps the products of a successful response, it is empty if there are no offers
+const webWunderOutputElement = "Output"
+
+// streamProducts walks the tokens of the SOAP response and publishes an offer for every products element as soon as it is decoded.
+// SOAP faults and responses without output are returned as *ProviderError
+func (api *WebWunderApi) streamProducts(ctx context.Context, body io.Reader, installation bool, offersChannel OfferPublisher) error {
+	decoder := xml.NewDecoder(body)
+
+	// Report changes of the products before they silently turn into zero values
+	schemaChecker := newSchemaDriftChecker(ctx, api.GetProviderName(), "products", webWunderSchema)
+	defer schemaChecker.report()
+
+	// without the output element an empty response would look like there are no offers for the address
+	foundOutput := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			if !foundOutput {
+				return &ProviderError{Provider: api.GetProviderName(), Kind: UNEXPECTED_RESPONSE, Message: "SOAP response has no " + webWunderOutputElement + " element"}
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case webWunderOutputElement:
+			foundOutput = true
+			continue
+		case "Fault":
+			var fault soapFault
+			if err := decoder.DecodeElement(&fault, &start); err != nil {
+				return err
+			}
+			return withAddressRejection(newSoapFaultError(api.GetProviderName(), fault), webWunderAddressFaultCodes)
+		case "products":
+		default:
+			continue
+		}
+
+		// decode the raw element first, so it can be checked for drift and unmarshalled without the rest of the response
+		var rawProduct struct {
+			InnerXML []byte `xml:",innerxml"`
+		}
+		if err := decoder.DecodeElement(&rawProduct, &start); err != nil {
+			return err
+		}
+		productXML := append(append([]byte("<products>"), rawProduct.InnerXML...), "</products>"...)
+		schemaChecker.checkRecord(xmlObservedKinds(productXML), productXML)
+
+		var product WebWunderSoapProduct
+		if err := xml.Unmarshal(productXML, &product); err != nil {
+			return err
+		}
+
+		offer := api.soapProductToOffer(product)
+		offer.Provider = api.GetProviderName()
+		offer.InstallationService = installation
+		offer.HelperIsPreliminary = false
+		offersChannel.Publish(offer)
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+	}
 }
 
 // soapProductToOffer converts a WebWunder SOAP product to a domain.Offer
@@ -240,37 +459,64 @@ func (api *WebWunderApi) soapProductToOffer(product WebWunderSoapProduct) (offer
 		offer.MonthlyCostInCent = product.ProductInfo.MonthlyCostInCent
 		offer.AfterTwoYearsMonthlyCost = product.ProductInfo.MonthlyCostInCentFrom25thMonth
 
-		// TODO process voucher differently
 		// Process voucher if available
-		if product.ProductInfo.Voucher != nil {
-			if product.ProductInfo.Voucher.PercentageVoucher != nil {
+		if voucher := product.ProductInfo.Voucher; voucher != nil && offer.ContractDurationInMonths > 0 {
+			offer.SetExtraProperty("webwunder.voucher.type", voucher.VoucherType())
+
+			switch voucher.VoucherType() {
+			case "percentageVoucher":
 				offer.VoucherDetails = domain.VoucherDetails{
-					Type:  domain.PERCENTAGE,
-					Value: product.ProductInfo.Voucher.PercentageVoucher.Percentage,
-					Description: fmt.Sprintf("Maximum Discount: %d Cent", product.ProductInfo.Voucher.PercentageVoucher.MaxDiscountInCent),
+					Type:        domain.PERCENTAGE,
+					Value:       voucher.Percentage,
+					Description: fmt.Sprintf("Maximum Discount: %d Cent", voucher.MaxDiscountInCent),
 				}
-				discountOverContractDuration := min(offer.MonthlyCostInCent * offer.ContractDurationInMonths * product.ProductInfo.Voucher.PercentageVoucher.Percentage / 100, product.ProductInfo.Voucher.PercentageVoucher.MaxDiscountInCent)
+				offer.SetExtraProperty("webwunder.voucher.maxDiscountInCent", strconv.Itoa(voucher.MaxDiscountInCent))
+				discountOverContractDuration := min(offer.MonthlyCostInCent*offer.ContractDurationInMonths*voucher.Percentage/100, voucher.MaxDiscountInCent)
 
 				offer.MonthlyCostInCentWithVoucher = offer.MonthlyCostInCent - discountOverContractDuration/offer.ContractDurationInMonths
-			} else if product.ProductInfo.Voucher.AbsoluteVoucher != nil {
+			case "absoluteVoucher":
 				offer.VoucherDetails = domain.VoucherDetails{
-					Type:  domain.ABSOLUTE,
-					Value: product.ProductInfo.Voucher.AbsoluteVoucher.DiscountInCent,
-					Description: fmt.Sprintf("Minimal Order Value: %d Cent", product.ProductInfo.Voucher.AbsoluteVoucher.MinOrderValueInCent),
+					Type:        domain.ABSOLUTE,
+					Value:       voucher.DiscountInCent,
+					Description: fmt.Sprintf("Minimal Order Value: %d Cent", voucher.MinOrderValueInCent),
 				}
+				offer.SetExtraProperty("webwunder.voucher.minOrderValueInCent", strconv.Itoa(voucher.MinOrderValueInCent))
 
 				priceOverContractDuration := offer.MonthlyCostInCent * offer.ContractDurationInMonths
-				if priceOverContractDuration > product.ProductInfo.Voucher.AbsoluteVoucher.MinOrderValueInCent {
-					offer.MonthlyCostInCentWithVoucher = offer.MonthlyCostInCent - product.ProductInfo.Voucher.AbsoluteVoucher.DiscountInCent/offer.ContractDurationInMonths
+				if priceOverContractDuration > voucher.MinOrderValueInCent {
+					offer.MonthlyCostInCentWithVoucher = offer.MonthlyCostInCent - voucher.DiscountInCent/offer.ContractDurationInMonths
 				}
-
 			}
+			addWebWunderUnmapped(&offer, "webwunder.voucher.", voucher.Unmapped)
 		}
+		addWebWunderUnmapped(&offer, "webwunder.productInfo.", product.ProductInfo.Unmapped)
 	}
+	addWebWunderUnmapped(&offer, "webwunder.", product.Unmapped)
 
 	return offer
 }
 
+// addWebWunderUnmapped stores elements we do not map as extra properties of the offer
+func addWebWunderUnmapped(offer *domain.Offer, prefix string, elements []WebWunderUnmappedElement) {
+	for _, element := range elements {
+		offer.SetExtraProperty(prefix+element.XMLName.Local, strings.TrimSpace(element.Value))
+	}
+}
+
+func (api *WebWunderApi) withTransport(transport http.RoundTripper) InternetProviderAPI {
+	return &WebWunderApi{transport: transport}
+}
+
 func (api *WebWunderApi) GetProviderName() string {
 	return "WebWunder"
 }
+
+func (api *WebWunderApi) GetFilterCapabilities() FilterCapabilities {
+	// every request is for a single connection type and installation service, only these types are queried
+	connectionTypes := []domain.ConnectionType{domain.DSL, domain.CABLE, domain.FIBER, domain.MOBILE}
+	return FilterCapabilities{
+		ConnectionTypes:        connectionTypes,
+		Installation:           true,
+		OfferedConnectionTypes: connectionTypes,
+	}
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"server/domain"
+	"testing"
+)
+
+func TestInstallationVariantsPublishPairsImmediately(t *testing.T) {
+	publisher := &conformancePublisher{}
+	variants := &installationVariants{next: publisher}
+	withInstallation, withoutInstallation := variants.variant(true), variants.variant(false)
+
+	offer := func(productID int, installation bool, cost int) domain.Offer {
+		return domain.Offer{ProductID: productID, ProductName: "Starter", InstallationService: installation, MonthlyCostInCent: cost}
+	}
+	published := func(want int, message string) []domain.Offer {
+		t.Helper()
+		offers := publisher.published()
+		if len(offers) != want {
+			t.Fatalf("%d offers were published %s, want %d", len(offers), message, want)
+		}
+		return offers
+	}
+
+	withInstallation.Publish(offer(1, true, 2500))
+	withInstallation.Publish(offer(2, true, 3500))
+	published(0, "before any other variant arrived")
+
+	withoutInstallation.Publish(offer(1, false, 2000))
+	offers := published(1, "once both variants of a product arrived")
+	if len(offers[0].InstallationOptions) != 2 {
+		t.Errorf("offer has installation options %+v, want both variants", offers[0].InstallationOptions)
+	}
+
+	// a different product with the same ID is not merged
+	withoutInstallation.Publish(domain.Offer{ProductID: 2, ProductName: "Fiber", MonthlyCostInCent: 5000})
+	published(3, "for a product whose variants differ")
+
+	withoutInstallation.Publish(offer(3, false, 4000))
+	published(3, "while the response with installation service is not complete")
+	variants.complete(true)
+	published(4, "once the response with installation service is complete")
+
+	// the response without installation service is complete as well, offers with installation service pass through
+	variants.complete(false)
+	withInstallation.Publish(offer(4, true, 4500))
+	published(5, "once both responses are complete")
+}
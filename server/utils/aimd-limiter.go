@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// a request slower than latencyTolerance times the average latency counts as congestion
+	latencyTolerance = 2.0
+	decreaseFactor   = 0.5
+	// weight of a new latency sample in the moving average
+	latencySmoothing = 0.2
+)
+
+// AIMDLimiter limits the number of requests in flight. The limit grows by one per round trip while requests
+// succeed in time (additive increase) and is halved on errors or slow responses (multiplicative decrease)
+type AIMDLimiter struct {
+	mu           sync.Mutex
+	limit        float64
+	minLimit     float64
+	maxLimit     float64
+	inFlight     int
+	avgLatency   time.Duration
+	lastDecrease time.Time
+	// closed and replaced whenever a slot becomes available
+	changed chan struct{}
+}
+
+func NewAIMDLimiter(initial int, minLimit int, maxLimit int) *AIMDLimiter {
+	minLimit = max(minLimit, 1)
+	maxLimit = max(maxLimit, minLimit)
+
+	return &AIMDLimiter{
+		limit:    float64(min(max(initial, minLimit), maxLimit)),
+		minLimit: float64(minLimit),
+		maxLimit: float64(maxLimit),
+		changed:  make(chan struct{}),
+	}
+}
+
+// Acquire blocks until a request may be sent, every successful Acquire has to be followed by Release or Cancel
+func (l *AIMDLimiter) Acquire(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.inFlight < int(l.limit) {
+			l.inFlight++
+			l.mu.Unlock()
+			return nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Release frees the slot of a finished request and adapts the limit to its latency and outcome
+func (l *AIMDLimiter) Release(latency time.Duration, failed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	congested := failed || (l.avgLatency > 0 && float64(latency) > latencyTolerance*float64(l.avgLatency))
+	if congested {
+		// requests sent before the last decrease still report the old congestion, so the limit is halved at most once per round trip
+		if time.Since(l.lastDecrease) > l.avgLatency {
+			l.limit = max(l.minLimit, l.limit*decreaseFactor)
+			l.lastDecrease = time.Now()
+		}
+	} else {
+		l.limit = min(l.maxLimit, l.limit+1/l.limit)
+	}
+
+	if !failed {
+		if l.avgLatency == 0 {
+			l.avgLatency = latency
+		} else {
+			l.avgLatency = time.Duration(latencySmoothing*float64(latency) + (1-latencySmoothing)*float64(l.avgLatency))
+		}
+	}
+
+	l.release()
+}
+
+// Cancel frees the slot of a request which was canceled before it finished, the limit is not adapted
+func (l *AIMDLimiter) Cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.release()
+}
+
+func (l *AIMDLimiter) release() {
+	l.inFlight--
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// Limit returns the current number of requests allowed in flight
+func (l *AIMDLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return int(l.limit)
+}
@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// limiterTimeout is the time a request gets for its slot before the test gives up
+const limiterTimeout = time.Second
+
+type roundTrip struct {
+	latency time.Duration
+	failed  bool
+}
+
+func TestAIMDLimiter(t *testing.T) {
+	ok := roundTrip{latency: 10 * time.Millisecond}
+	failed := roundTrip{latency: 10 * time.Millisecond, failed: true}
+	slow := roundTrip{latency: 50 * time.Millisecond}
+
+	for _, tc := range []struct {
+		name                        string
+		initial, minLimit, maxLimit int
+		trips                       []roundTrip
+		want                        int
+	}{
+		{name: "initial limit", initial: 3, minLimit: 1, maxLimit: 10, want: 3},
+		{name: "initial limit within bounds", initial: 20, minLimit: 1, maxLimit: 10, want: 10},
+		{name: "at least one request", initial: 0, minLimit: 0, maxLimit: 0, want: 1},
+		// the limit grows by 1/limit per request, by one per round trip of limit requests
+		{name: "additive increase", initial: 2, minLimit: 1, maxLimit: 10, trips: []roundTrip{ok, ok}, want: 2},
+		{name: "one more per round trip", initial: 2, minLimit: 1, maxLimit: 10, trips: []roundTrip{ok, ok, ok}, want: 3},
+		{name: "increase up to the maximum", initial: 1, minLimit: 1, maxLimit: 3, trips: repeat(ok, 20), want: 3},
+		{name: "failure halves", initial: 8, minLimit: 1, maxLimit: 10, trips: []roundTrip{failed}, want: 4},
+		{name: "slow response halves", initial: 8, minLimit: 1, maxLimit: 10, trips: []roundTrip{ok, slow}, want: 4},
+		{name: "decrease down to the minimum", initial: 8, minLimit: 3, maxLimit: 10, trips: []roundTrip{failed, failed, failed}, want: 3},
+		{
+			// the failures of requests sent before the decrease do not halve the limit again
+			name: "halved once per round trip", initial: 8, minLimit: 1, maxLimit: 10,
+			trips: []roundTrip{{latency: time.Hour}, failed, failed}, want: 4,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := NewAIMDLimiter(tc.initial, tc.minLimit, tc.maxLimit)
+			for _, trip := range tc.trips {
+				if err := l.Acquire(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+				l.Release(trip.latency, trip.failed)
+			}
+
+			if got := l.Limit(); got != tc.want {
+				t.Errorf("limit is %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func repeat(trip roundTrip, n int) []roundTrip {
+	trips := make([]roundTrip, n)
+	for i := range trips {
+		trips[i] = trip
+	}
+	return trips
+}
+
+func TestAIMDLimiterBlocksAtLimit(t *testing.T) {
+	l := NewAIMDLimiter(1, 1, 1)
+	if err := l.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// a request over the limit gives up with its context
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.Acquire(ctx); err != context.DeadlineExceeded {
+		t.Errorf("request over the limit returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// and gets the slot once a request is cancelled
+	acquired := make(chan error, 1)
+	go func() { acquired <- l.Acquire(context.Background()) }()
+	l.Cancel()
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(limiterTimeout):
+		t.Fatal("the freed slot was not handed on")
+	}
+	if got := l.Limit(); got != 1 {
+		t.Errorf("cancelling changed the limit to %d", got)
+	}
+}
@@ -0,0 +1,227 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// OverflowPolicy decides what happens to a message for a subscriber whose queue is full
+type OverflowPolicy int
+
+const (
+	// the publisher waits until the subscriber made room or unsubscribed
+	OVERFLOW_BLOCK OverflowPolicy = iota
+	// the oldest queued message of the subscriber is dropped
+	OVERFLOW_DROP_OLDEST
+	// the subscriber is unsubscribed, its channel is closed after the queued messages
+	OVERFLOW_DISCONNECT
+)
+
+// REPLAY_ALL keeps every published message for late subscribers
+const REPLAY_ALL = -1
+
+// ErrSubscriberDisconnected is returned by Subscription.Err if the subscriber could not keep up
+var ErrSubscriberDisconnected = errors.New("subscriber disconnected, its queue overflowed")
+
+type BroadcasterOptions struct {
+	// messages queued per subscriber before the overflow policy applies
+	BufferSize int
+	Overflow   OverflowPolicy
+	// number of the latest messages new subscribers receive first, 0 disables and REPLAY_ALL keeps all
+	Replay int
+}
+
+type BroadcasterStats struct {
+	Published    uint64
+	Delivered    uint64
+	Dropped      uint64
+	Disconnected uint64
+	// publications which had to wait for a subscriber with the block policy
+	Blocked     uint64
+	Subscribers int
+}
+
+// Broadcaster delivers every published message to all subscribers in publish order. Each subscriber has its own
+// bounded queue, a subscriber which falls behind is handled by the overflow policy instead of piling up goroutines
+type Broadcaster[T any] struct {
+	options BroadcasterOptions
+
+	// held while a message is delivered, so messages reach all subscribers in the same order
+	mu      sync.Mutex
+	subs    []*Subscription[T]
+	history []T
+	closed  bool
+	stats   BroadcasterStats
+}
+
+func NewBroadcaster[T any](options BroadcasterOptions) *Broadcaster[T] {
+	options.BufferSize = max(options.BufferSize, 0)
+
+	return &Broadcaster[T]{options: options}
+}
+
+// Subscription receives the messages of a broadcaster on C until it is unsubscribed or the broadcaster is closed
+type Subscription[T any] struct {
+	C <-chan T
+
+	broadcaster *Broadcaster[T]
+	ch          chan T
+	// closed on unsubscribe, releases a publisher blocked on the subscriber
+	done      chan struct{}
+	closeOnce sync.Once
+	stop      func() bool
+	err       error
+}
+
+// Subscribe returns a subscription which first receives the replayed messages. It ends when ctx is done, so a
+// blocked publisher never waits for a subscriber which stopped reading. Subscribing to a closed broadcaster
+// returns the replayed messages on an already closed channel
+func (b *Broadcaster[T]) Subscribe(ctx context.Context) *Subscription[T] {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// the replay does not count towards the buffer, it is bounded by the replay option instead
+	ch := make(chan T, len(b.history)+b.options.BufferSize)
+	for _, msg := range b.history {
+		ch <- msg
+	}
+	b.stats.Delivered += uint64(len(b.history))
+
+	sub := &Subscription[T]{
+		C:           ch,
+		broadcaster: b,
+		ch:          ch,
+		done:        make(chan struct{}),
+	}
+	if b.closed {
+		close(sub.done)
+		close(ch)
+		return sub
+	}
+
+	b.subs = append(b.subs, sub)
+	sub.stop = context.AfterFunc(ctx, sub.Unsubscribe)
+
+	return sub
+}
+
+// Unsubscribe stops the delivery, messages already queued can still be received before C is closed
+func (s *Subscription[T]) Unsubscribe() {
+	s.closeOnce.Do(func() { close(s.done) })
+
+	b := s.broadcaster
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.remove(s, nil)
+}
+
+// Err returns ErrSubscriberDisconnected if the subscription was ended by the overflow policy
+func (s *Subscription[T]) Err() error {
+	s.broadcaster.mu.Lock()
+	defer s.broadcaster.mu.Unlock()
+
+	return s.err
+}
+
+// remove ends a subscription, b.mu has to be held
+func (b *Broadcaster[T]) remove(sub *Subscription[T], err error) {
+	index := slices.Index(b.subs, sub)
+	if index < 0 {
+		return
+	}
+
+	b.subs = slices.Delete(b.subs, index, index+1)
+	sub.err = err
+	if sub.stop != nil {
+		sub.stop()
+	}
+	close(sub.ch)
+}
+
+func (b *Broadcaster[T]) Publish(msg T) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		log.Debug("Broadcaster is closed, cannot publish message")
+		return
+	}
+
+	b.stats.Published++
+	switch {
+	case b.options.Replay == REPLAY_ALL:
+		b.history = append(b.history, msg)
+	case b.options.Replay > 0:
+		if len(b.history) == b.options.Replay {
+			b.history = b.history[1:]
+		}
+		b.history = append(b.history, msg)
+	}
+
+	// delivery can remove subscribers, so it iterates over a copy
+	for _, sub := range slices.Clone(b.subs) {
+		b.deliver(sub, msg)
+	}
+}
+
+// deliver queues the message for a subscriber and applies the overflow policy if its queue is full, b.mu has to be held
+func (b *Broadcaster[T]) deliver(sub *Subscription[T], msg T) {
+	select {
+	case sub.ch <- msg:
+		b.stats.Delivered++
+		return
+	default:
+	}
+
+	switch b.options.Overflow {
+	case OVERFLOW_DROP_OLDEST:
+		// only the publisher sends and it holds b.mu, so there is room after taking one message out
+		select {
+		case <-sub.ch:
+			b.stats.Dropped++
+		default:
+		}
+		sub.ch <- msg
+		b.stats.Delivered++
+	case OVERFLOW_DISCONNECT:
+		b.stats.Disconnected++
+		b.remove(sub, ErrSubscriberDisconnected)
+	default:
+		b.stats.Blocked++
+		select {
+		case sub.ch <- msg:
+			b.stats.Delivered++
+		case <-sub.done:
+			// unsubscribed while waiting, it is removed once the publisher releases the lock
+		}
+	}
+}
+
+// Close ends all subscriptions after their queued messages, later publications are ignored
+func (b *Broadcaster[T]) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+
+	b.closed = true
+	for _, sub := range slices.Clone(b.subs) {
+		b.remove(sub, nil)
+	}
+}
+
+func (b *Broadcaster[T]) Stats() BroadcasterStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := b.stats
+	stats.Subscribers = len(b.subs)
+	return stats
+}
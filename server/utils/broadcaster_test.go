@@ -0,0 +1,296 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// time a blocked publisher or subscriber gets before the test gives up
+const broadcasterTimeout = time.Second
+
+func TestBroadcasterBlockWaitsForSubscriber(t *testing.T) {
+	b := NewBroadcaster[int](BroadcasterOptions{BufferSize: 1, Overflow: OVERFLOW_BLOCK})
+	sub := b.Subscribe(context.Background())
+
+	b.Publish(1)
+	published := make(chan struct{})
+	go func() {
+		b.Publish(2)
+		close(published)
+	}()
+
+	select {
+	case <-published:
+		t.Fatal("Publish returned although the queue of the subscriber is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if got := receive(t, sub.C, 1); !slices.Equal(got, []int{1}) {
+		t.Fatalf("received %v, want [1]", got)
+	}
+	waitClosed(t, published, "Publish did not return once the subscriber made room")
+	b.Close()
+
+	if got := receiveAll(t, sub.C); !slices.Equal(got, []int{2}) {
+		t.Errorf("received %v, want [2]", got)
+	}
+	if stats := b.Stats(); stats.Blocked != 1 || stats.Delivered != 2 || stats.Dropped != 0 {
+		t.Errorf("stats are %+v, want 1 blocked and 2 delivered", stats)
+	}
+}
+
+func TestBroadcasterBlockReleasedOnUnsubscribe(t *testing.T) {
+	b := NewBroadcaster[int](BroadcasterOptions{BufferSize: 1, Overflow: OVERFLOW_BLOCK})
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := b.Subscribe(ctx)
+
+	b.Publish(1)
+	published := make(chan struct{})
+	go func() {
+		b.Publish(2)
+		close(published)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	cancel()
+	waitClosed(t, published, "Publish kept waiting for a subscriber whose context ended")
+
+	if got := receiveAll(t, sub.C); !slices.Equal(got, []int{1}) {
+		t.Errorf("received %v, want the message queued before the unsubscribe", got)
+	}
+	if err := sub.Err(); err != nil {
+		t.Errorf("Err is %v, want nil after an unsubscribe", err)
+	}
+}
+
+func TestBroadcasterDropOldest(t *testing.T) {
+	b := NewBroadcaster[int](BroadcasterOptions{BufferSize: 2, Overflow: OVERFLOW_DROP_OLDEST})
+	sub := b.Subscribe(context.Background())
+
+	for i := 1; i <= 5; i++ {
+		b.Publish(i)
+	}
+	b.Close()
+
+	if got := receiveAll(t, sub.C); !slices.Equal(got, []int{4, 5}) {
+		t.Errorf("received %v, want the latest messages [4 5]", got)
+	}
+	if stats := b.Stats(); stats.Dropped != 3 || stats.Blocked != 0 {
+		t.Errorf("stats are %+v, want 3 dropped and none blocked", stats)
+	}
+}
+
+func TestBroadcasterDisconnect(t *testing.T) {
+	b := NewBroadcaster[int](BroadcasterOptions{BufferSize: 2, Overflow: OVERFLOW_DISCONNECT})
+	slow := b.Subscribe(context.Background())
+	fast := b.Subscribe(context.Background())
+
+	var fastReceived []int
+	fastDone := make(chan struct{})
+	go func() {
+		defer close(fastDone)
+		for msg := range fast.C {
+			fastReceived = append(fastReceived, msg)
+		}
+	}()
+
+	for i := 1; i <= 4; i++ {
+		b.Publish(i)
+		// the fast subscriber keeps up, so only the slow one overflows
+		for len(fast.C) > 0 {
+			runtime.Gosched()
+		}
+	}
+	b.Close()
+	waitClosed(t, fastDone, "the fast subscriber was not closed")
+
+	if got := receiveAll(t, slow.C); !slices.Equal(got, []int{1, 2}) {
+		t.Errorf("slow subscriber received %v, want the messages queued before it was disconnected", got)
+	}
+	if !errors.Is(slow.Err(), ErrSubscriberDisconnected) {
+		t.Errorf("slow subscriber has error %v, want %v", slow.Err(), ErrSubscriberDisconnected)
+	}
+	if !slices.Equal(fastReceived, []int{1, 2, 3, 4}) {
+		t.Errorf("fast subscriber received %v, want all messages", fastReceived)
+	}
+	if fast.Err() != nil {
+		t.Errorf("fast subscriber has error %v", fast.Err())
+	}
+	if stats := b.Stats(); stats.Disconnected != 1 {
+		t.Errorf("stats are %+v, want 1 disconnected", stats)
+	}
+}
+
+func TestBroadcasterReplay(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		replay int
+		want   []int
+	}{
+		{name: "disabled", replay: 0, want: nil},
+		{name: "latest", replay: 2, want: []int{2, 3}},
+		{name: "all", replay: REPLAY_ALL, want: []int{1, 2, 3}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := NewBroadcaster[int](BroadcasterOptions{BufferSize: 1, Replay: tc.replay})
+			for i := 1; i <= 3; i++ {
+				b.Publish(i)
+			}
+
+			late := b.Subscribe(context.Background())
+			b.Close()
+			if got := receiveAll(t, late.C); !slices.Equal(got, tc.want) {
+				t.Errorf("late subscriber received %v, want %v", got, tc.want)
+			}
+
+			closed := b.Subscribe(context.Background())
+			if got := receiveAll(t, closed.C); !slices.Equal(got, tc.want) {
+				t.Errorf("subscriber of the closed broadcaster received %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+var overflowPolicies = []struct {
+	name   string
+	policy OverflowPolicy
+}{
+	{"block", OVERFLOW_BLOCK},
+	{"drop-oldest", OVERFLOW_DROP_OLDEST},
+	{"disconnect", OVERFLOW_DISCONNECT},
+}
+
+// BenchmarkBroadcaster publishes b.N messages to subscribers which read until the stream is closed. With a slow
+// subscriber the last one spends a microsecond per message. Besides the time per message it reports the share of
+// the messages the subscribers received and the peak number of goroutines
+func BenchmarkBroadcaster(b *testing.B) {
+	for _, overflow := range overflowPolicies {
+		for _, subscribers := range []int{1, 4, 16} {
+			for _, slow := range []bool{false, true} {
+				name := fmt.Sprintf("%s/subscribers=%d", overflow.name, subscribers)
+				if slow {
+					name += "+slow"
+				}
+				b.Run(name, func(b *testing.B) {
+					broadcaster := NewBroadcaster[int](BroadcasterOptions{BufferSize: 64, Overflow: overflow.policy})
+					benchmarkFanout(b, broadcaster, subscribers, slow)
+				})
+			}
+		}
+	}
+}
+
+func benchmarkFanout(b *testing.B, broadcaster *Broadcaster[int], subscribers int, slow bool) {
+	var wg sync.WaitGroup
+	var received atomic.Int64
+	for i := 0; i < subscribers; i++ {
+		delay := time.Duration(0)
+		if slow && i == subscribers-1 {
+			delay = time.Microsecond
+		}
+
+		sub := broadcaster.Subscribe(context.Background())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range sub.C {
+				received.Add(1)
+				if delay > 0 {
+					spin(delay)
+				}
+			}
+		}()
+	}
+
+	var peak atomic.Int64
+	peak.Store(int64(runtime.NumGoroutine()))
+	done := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				peak.Store(max(peak.Load(), int64(runtime.NumGoroutine())))
+			}
+		}
+	}()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		broadcaster.Publish(i)
+	}
+	broadcaster.Close()
+	wg.Wait()
+	b.StopTimer()
+	close(done)
+	<-sampled
+
+	b.ReportMetric(100*float64(received.Load())/float64(b.N*subscribers), "received%")
+	b.ReportMetric(float64(peak.Load()), "goroutines")
+}
+
+// spin waits without sleeping, sleeps are far coarser than the delays of a slow subscriber
+func spin(d time.Duration) {
+	for start := time.Now(); time.Since(start) < d; {
+	}
+}
+
+// receive reads n messages from ch
+func receive(t *testing.T, ch <-chan int, n int) []int {
+	t.Helper()
+
+	var got []int
+	for len(got) < n {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return got
+			}
+			got = append(got, msg)
+		case <-time.After(broadcasterTimeout):
+			t.Fatalf("received %v, waited for %d messages", got, n)
+		}
+	}
+	return got
+}
+
+// receiveAll reads ch until it is closed
+func receiveAll(t *testing.T, ch <-chan int) []int {
+	t.Helper()
+
+	var got []int
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return got
+			}
+			got = append(got, msg)
+		case <-time.After(broadcasterTimeout):
+			t.Fatalf("channel was not closed, received %v", got)
+		}
+	}
+}
+
+func waitClosed(t *testing.T, ch <-chan struct{}, message string) {
+	t.Helper()
+
+	select {
+	case <-ch:
+	case <-time.After(broadcasterTimeout):
+		t.Fatal(message)
+	}
+}
@@ -23,28 +23,82 @@ type Configuration struct {
 		Password string `env:"USER_OFFER_CACHE_PASSWORD,notEmpty"`
 		TTL      int64  `env:"USER_OFFER_CACHE_TTL_SEC" envDefault:"86400"` // 24 hours
 	}
+	// results without offers per provider and address, a TTL of 0 disables the entries of a kind
+	NegativeCache struct {
+		NoOffersTTL        int64 `env:"NEGATIVE_CACHE_NO_OFFERS_TTL_SEC" envDefault:"3600"`         // 1 hour
+		AddressRejectedTTL int64 `env:"NEGATIVE_CACHE_ADDRESS_REJECTED_TTL_SEC" envDefault:"86400"` // 24 hours
+	}
 	Server struct {
 		Port                uint   `env:"SERVER_PORT" envDefault:"8080"`
 		FreshnessWindowSec  int64  `env:"FRESHNESS_WINDOW_SEC" envDefault:"5"`
 		RetryFrequencyMilli []uint `env:"RETRY_FREQUENCY_MILLI" envDefault:"1000,2000,3000"`
 		ApiTimeoutSec       uint   `env:"API_TIMEOUT_SEC" envDefault:"30"`
+		// offers queued per consumer of a lookup before the providers have to wait
+		OfferStreamBuffer int `env:"OFFER_STREAM_BUFFER" envDefault:"64"`
+		// unfiltered lookups finish and fill the address cache even if the client disconnects
+		DetachLookups      bool `env:"DETACH_LOOKUPS" envDefault:"false"`
+		MaxDetachedLookups int  `env:"MAX_DETACHED_LOOKUPS" envDefault:"20"`
 	}
-	VerbynDich struct {
-		ApiKey string `env:"VERBYNDICH_API_KEY,notEmpty"`
+	// provider timeouts follow the observed latency, bounded by the API timeout
+	Timeouts struct {
+		Quantile float64 `env:"PROVIDER_TIMEOUT_QUANTILE" envDefault:"0.99"`
+		Factor   float64 `env:"PROVIDER_TIMEOUT_FACTOR" envDefault:"2"`
+		MinMilli uint    `env:"PROVIDER_TIMEOUT_MIN_MILLI" envDefault:"2000"`
+		// deadline of lookups in fast mode, which accept partial results
+		FastMilli uint `env:"FAST_MODE_TIMEOUT_MILLI" envDefault:"3000"`
 	}
-	ServusSpeed struct {
-		Username string `env:"SERVUSSPEED_USERNAME,notEmpty"`
-		Password string `env:"SERVUSSPEED_PASSWORD,notEmpty"`
+	// duplicate slow provider requests which are safe to send twice
+	Hedging struct {
+		Enabled bool `env:"HEDGING_ENABLED" envDefault:"false"`
+		// a duplicate is sent once a request took longer than this quantile of its recent latency
+		Quantile float64 `env:"HEDGE_QUANTILE" envDefault:"0.95"`
+		// hedges per 100 hedgeable requests
+		BudgetPercent float64 `env:"HEDGE_BUDGET_PERCENT" envDefault:"5"`
+	}
+	Scheduler struct {
+		// upstream provider calls running at once over all providers and lookups
+		MaxConcurrency int `env:"OUTBOUND_MAX_CONCURRENCY" envDefault:"64"`
+		// time a response body keeps its slot while it is read, a slow reader goes on without a slot
+		MaxSlotHoldMilli uint `env:"OUTBOUND_MAX_SLOT_HOLD_MILLI" envDefault:"2000"`
+	}
+	// outbound limits per provider name, e.g. "VerbynDich:10,ByteMe:5", providers which are not listed are not limited
+	ProviderLimits struct {
+		// requests per second
+		RatePerSec map[string]float64 `env:"PROVIDER_RATE_LIMITS"`
+		// requests sent at once after a quiet period, defaults to the rate
+		Burst map[string]int `env:"PROVIDER_RATE_BURSTS"`
+		// calls per day and month (UTC) over all replicas
+		DailyBudget   map[string]int64 `env:"PROVIDER_DAILY_BUDGETS"`
+		MonthlyBudget map[string]int64 `env:"PROVIDER_MONTHLY_BUDGETS"`
+		// share of a budget kept for running lookups, once only the reserve is left the provider is served from cache
+		BudgetReservePercent float64 `env:"PROVIDER_BUDGET_RESERVE_PERCENT" envDefault:"5"`
+	}
+	Admin struct {
+		// admin endpoints require the header "Authorization: Bearer <token>", without a token they are disabled
+		Token string `env:"ADMIN_TOKEN"`
+	}
+	ProviderTraffic struct {
+		// off, record or replay, recordings are stored per provider and request fingerprint in Dir
+		Mode string `env:"PROVIDER_TRAFFIC_MODE" envDefault:"off"`
+		Dir  string `env:"PROVIDER_TRAFFIC_DIR" envDefault:"recordings"`
 	}
-	PingPerfect struct {
-		SignatureSecret string `env:"PINGPERFECT_SIGNATURE_SECRET,notEmpty"`
-		ClientId        string `env:"PINGPERFECT_CLIENT_ID,notEmpty"`
+	// provider credentials are no config values but utils.Credential, see service/provider_auth.go
+	Secrets struct {
+		// directory with one file per credential, e.g. mounted docker or kubernetes secrets
+		Dir               string `env:"SECRETS_DIR"`
+		ReloadIntervalSec int64  `env:"SECRETS_RELOAD_INTERVAL_SEC" envDefault:"30"`
 	}
-	WebWunder struct {
-		ApiKey string `env:"WEBWUNDER_API_KEY,notEmpty"`
+	VerbynDich struct {
+		// upper bound of the adaptive number of pages fetched in parallel
+		MaxConcurrency int   `env:"VERBYNDICH_MAX_CONCURRENCY" envDefault:"20"`
+		PageCountTTL   int64 `env:"VERBYNDICH_PAGE_COUNT_TTL_SEC" envDefault:"86400"` // 24 hours
 	}
-	ByteMe struct {
-		ApiKey string `env:"BYTEME_API_KEY,notEmpty"`
+	ServusSpeed struct {
+		// product detail requests in flight over all lookups
+		MaxConcurrency int   `env:"SERVUSSPEED_MAX_CONCURRENCY" envDefault:"5"`
+		DetailsTTL     int64 `env:"SERVUSSPEED_DETAILS_TTL_SEC" envDefault:"3600"` // 1 hour
+		// cache product details per product ID and address instead of per product ID only
+		DetailsPerAddress bool `env:"SERVUSSPEED_DETAILS_PER_ADDRESS" envDefault:"false"`
 	}
 
 	Debug bool `env:"DEBUG" envDefault:"false"`
@@ -65,6 +119,20 @@ func LoadConfig() Configuration {
 		log.WithError(err).Fatal("Error parsing environment variables")
 	}
 
+	switch Cfg.ProviderTraffic.Mode {
+	case "off", "record", "replay":
+	default:
+		log.WithField("mode", Cfg.ProviderTraffic.Mode).Fatal("PROVIDER_TRAFFIC_MODE must be off, record or replay")
+	}
+	if Cfg.ProviderTraffic.Mode != "off" {
+		log.WithField("mode", Cfg.ProviderTraffic.Mode).WithField("dir", Cfg.ProviderTraffic.Dir).Warn("PROVIDER TRAFFIC RECORDING/REPLAY ENABLED")
+	}
+
+	if err := LoadCredentials(); err != nil {
+		log.WithError(err).Fatal("Error loading provider credentials")
+	}
+	log.AddHook(secretRedactionHook{})
+
 	if Cfg.Debug {
 		log.SetLevel(log.DebugLevel)
 		log.Warn("DEBUG MODE ENABLED")
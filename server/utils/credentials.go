@@ -0,0 +1,286 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const redactedSecret = "[REDACTED]"
+
+// rotationSuffix names the second key of a credential during rotation, e.g. BYTEME_API_KEY_NEXT
+const rotationSuffix = "_NEXT"
+
+// Secret hides its value when it is formatted, logged or marshalled, Reveal returns the value
+type Secret struct {
+	value string
+}
+
+func (s Secret) String() string {
+	return redactedSecret
+}
+
+func (s Secret) GoString() string {
+	return redactedSecret
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(redactedSecret)
+}
+
+func (s Secret) Reveal() string {
+	return s.value
+}
+
+func (s Secret) IsEmpty() bool {
+	return s.value == ""
+}
+
+// Credential is a named secret of a provider. It is loaded from the env var of its name, the file given by
+// <name>_FILE or the file <name> in SECRETS_DIR, in this order. During rotation a second key is loaded the same
+// way from <name>_NEXT. File based credentials are reloaded on change
+type Credential struct {
+	name     string
+	required bool
+
+	mu   sync.RWMutex
+	keys []Secret
+	// index of the key which was accepted last
+	active int
+}
+
+var (
+	credentialsMu       sync.Mutex
+	credentialsRegistry = make(map[string]*Credential)
+)
+
+// NewCredential registers a credential, it is loaded by LoadConfig. Registering the same name twice returns the
+// already registered credential
+func NewCredential(name string, required bool) *Credential {
+	credentialsMu.Lock()
+	defer credentialsMu.Unlock()
+
+	if credential, ok := credentialsRegistry[name]; ok {
+		credential.required = credential.required || required
+		return credential
+	}
+
+	credential := &Credential{name: name, required: required}
+	credentialsRegistry[name] = credential
+	return credential
+}
+
+func (c *Credential) Name() string {
+	return c.name
+}
+
+// Keys returns the keys to try in order, the key accepted last comes first
+func (c *Credential) Keys() []Secret {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	keys := make([]Secret, 0, len(c.keys))
+	if c.active < len(c.keys) {
+		keys = append(keys, c.keys[c.active])
+	}
+	for i, key := range c.keys {
+		if i != c.active {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// Current returns the key accepted last, or an empty secret if the credential is not set
+func (c *Credential) Current() Secret {
+	keys := c.Keys()
+	if len(keys) == 0 {
+		return Secret{}
+	}
+
+	return keys[0]
+}
+
+// Accepted remembers the key the provider accepted, so it is tried first from now on
+func (c *Credential) Accepted(key Secret) {
+	c.mu.Lock()
+	index := slices.Index(c.keys, key)
+	switched := index >= 0 && index != c.active
+	if switched {
+		c.active = index
+	}
+	c.mu.Unlock()
+
+	// logged without holding the lock, the redaction hook reads the keys
+	if switched {
+		log.WithField("credential", c.name).Info("Switched to rotated credential key")
+	}
+}
+
+// load reads the keys from their sources and reports whether they changed
+func (c *Credential) load() (bool, error) {
+	var keys []Secret
+	for _, name := range []string{c.name, c.name + rotationSuffix} {
+		value, err := readSecretValue(name)
+		if err != nil {
+			return false, err
+		}
+		if value != "" {
+			keys = append(keys, Secret{value: value})
+		}
+	}
+	if c.required && len(keys) == 0 {
+		return false, fmt.Errorf("credential %s is not set, provide %s, %s_FILE or the file %s in SECRETS_DIR", c.name, c.name, c.name, c.name)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if slices.Equal(keys, c.keys) {
+		return false, nil
+	}
+
+	// keep the accepted key active if it is still present, e.g. when only the next key was added
+	active := 0
+	if c.active < len(c.keys) {
+		active = max(slices.Index(keys, c.keys[c.active]), 0)
+	}
+	c.keys = keys
+	c.active = active
+
+	return true, nil
+}
+
+func readSecretValue(name string) (string, error) {
+	if value := os.Getenv(name); value != "" {
+		return value, nil
+	}
+
+	path := os.Getenv(name + "_FILE")
+	if path == "" && Cfg.Secrets.Dir != "" {
+		path = filepath.Join(Cfg.Secrets.Dir, name)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return "", nil
+		}
+	}
+	if path == "" {
+		return "", nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		// the path may name a secret, but never contains its value
+		return "", fmt.Errorf("failed to read credential %s from %s: %w", name, path, err)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// registeredCredentials returns all credentials, they are iterated without holding the registry lock
+// as logging redacts secrets and therefore reads the registry
+func registeredCredentials() []*Credential {
+	credentialsMu.Lock()
+	defer credentialsMu.Unlock()
+
+	credentials := make([]*Credential, 0, len(credentialsRegistry))
+	for _, credential := range credentialsRegistry {
+		credentials = append(credentials, credential)
+	}
+
+	return credentials
+}
+
+// LoadCredentials loads all registered credentials, it fails if a required credential is missing
+func LoadCredentials() error {
+	for _, credential := range registeredCredentials() {
+		if _, err := credential.load(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WatchCredentials reloads all credentials periodically until the context is done, so rotated secret files
+// are picked up without a restart
+func WatchCredentials(ctx context.Context) {
+	if Cfg.Secrets.ReloadIntervalSec <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(Cfg.Secrets.ReloadIntervalSec) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, credential := range registeredCredentials() {
+				changed, err := credential.load()
+				if err != nil {
+					log.WithError(err).WithField("credential", credential.name).Error("Failed to reload credential, keeping the previous keys")
+					continue
+				}
+				if changed {
+					log.WithField("credential", credential.name).Info("Reloaded credential")
+				}
+			}
+		}
+	}
+}
+
+// RedactSecrets replaces the values of all loaded credentials in s, it is used for provider responses and logs
+func RedactSecrets(s string) string {
+	for _, credential := range registeredCredentials() {
+		credential.mu.RLock()
+		for _, key := range credential.keys {
+			if key.value != "" {
+				s = strings.ReplaceAll(s, key.value, redactedSecret)
+			}
+		}
+		credential.mu.RUnlock()
+	}
+
+	return s
+}
+
+// secretRedactionHook removes credential values from log messages and fields as a last line of defence
+type secretRedactionHook struct{}
+
+func (secretRedactionHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (secretRedactionHook) Fire(entry *log.Entry) error {
+	entry.Message = RedactSecrets(entry.Message)
+
+	// the data map is shared with the logger the entry was created from, so it is replaced instead of modified
+	data := make(log.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		switch value := value.(type) {
+		case string:
+			data[key] = RedactSecrets(value)
+		case error:
+			if redacted := RedactSecrets(value.Error()); redacted != value.Error() {
+				data[key] = redacted
+			} else {
+				data[key] = value
+			}
+		default:
+			data[key] = value
+		}
+	}
+	entry.Data = data
+
+	return nil
+}
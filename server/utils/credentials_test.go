@@ -0,0 +1,234 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// keyValues returns the revealed keys of the credential in the order they are tried
+func keyValues(c *Credential) []string {
+	var values []string
+	for _, key := range c.Keys() {
+		values = append(values, key.Reveal())
+	}
+	return values
+}
+
+// writeSecret writes a secret file with a trailing newline, as editors and kubernetes do
+func writeSecret(t *testing.T, path string, value string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(value+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// withSecretsDir points SECRETS_DIR at a new directory for the test
+func withSecretsDir(t *testing.T) string {
+	dir := t.TempDir()
+	previous := Cfg.Secrets
+	Cfg.Secrets.Dir = dir
+	t.Cleanup(func() { Cfg.Secrets = previous })
+	return dir
+}
+
+func TestCredentialSources(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// env vars, the value "file:<content>" is written to a file whose path is set instead
+		env map[string]string
+		// files in SECRETS_DIR
+		files map[string]string
+		want  []string
+	}{
+		{name: "env var", env: map[string]string{"KEY": "from-env"}, want: []string{"from-env"}},
+		{name: "file", env: map[string]string{"KEY_FILE": "file:from-file"}, want: []string{"from-file"}},
+		{name: "secrets dir", files: map[string]string{"KEY": "from-dir"}, want: []string{"from-dir"}},
+		{
+			name:  "env var before file and secrets dir",
+			env:   map[string]string{"KEY": "from-env", "KEY_FILE": "file:from-file"},
+			files: map[string]string{"KEY": "from-dir"},
+			want:  []string{"from-env"},
+		},
+		{name: "file before secrets dir", env: map[string]string{"KEY_FILE": "file:from-file"}, files: map[string]string{"KEY": "from-dir"}, want: []string{"from-file"}},
+		{
+			name:  "next key from any source",
+			env:   map[string]string{"KEY": "current"},
+			files: map[string]string{"KEY_NEXT": "next"},
+			want:  []string{"current", "next"},
+		},
+		{name: "only the next key", env: map[string]string{"KEY_NEXT": "next"}, want: []string{"next"}},
+		{name: "not set"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := withSecretsDir(t)
+			name := "CREDENTIAL_SOURCES"
+			for suffix, value := range tc.env {
+				if content, ok := strings.CutPrefix(value, "file:"); ok {
+					path := filepath.Join(t.TempDir(), "secret")
+					writeSecret(t, path, content)
+					value = path
+				}
+				t.Setenv(strings.Replace(suffix, "KEY", name, 1), value)
+			}
+			for suffix, content := range tc.files {
+				writeSecret(t, filepath.Join(dir, strings.Replace(suffix, "KEY", name, 1)), content)
+			}
+
+			credential := &Credential{name: name}
+			if _, err := credential.load(); err != nil {
+				t.Fatal(err)
+			}
+			if got := keyValues(credential); !slices.Equal(got, tc.want) {
+				t.Errorf("keys are %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRequiredCredential(t *testing.T) {
+	withSecretsDir(t)
+	credential := &Credential{name: "CREDENTIAL_MISSING", required: true}
+	if _, err := credential.load(); err == nil {
+		t.Error("a missing required credential was loaded")
+	}
+
+	// an unreadable file fails the load, its path is reported
+	t.Setenv("CREDENTIAL_MISSING_FILE", filepath.Join(t.TempDir(), "missing"))
+	if _, err := credential.load(); err == nil || !strings.Contains(err.Error(), "CREDENTIAL_MISSING") {
+		t.Errorf("loading an unreadable file failed with %v", err)
+	}
+}
+
+func TestCredentialRotation(t *testing.T) {
+	dir := withSecretsDir(t)
+	name := "CREDENTIAL_ROTATION"
+	writeSecret(t, filepath.Join(dir, name), "old")
+	credential := &Credential{name: name}
+	if _, err := credential.load(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the next key is added, the old one stays first until the provider accepts the next one
+	writeSecret(t, filepath.Join(dir, name+rotationSuffix), "new")
+	if changed, err := credential.load(); err != nil || !changed {
+		t.Fatalf("adding the next key changed the credential: %t, %v", changed, err)
+	}
+	if got := keyValues(credential); !slices.Equal(got, []string{"old", "new"}) {
+		t.Errorf("keys are %q, want the old key first", got)
+	}
+	credential.Accepted(credential.Keys()[1])
+	if got := keyValues(credential); !slices.Equal(got, []string{"new", "old"}) {
+		t.Errorf("keys are %q, want the accepted key first", got)
+	}
+
+	// the rotation ends, the next key becomes the only key
+	writeSecret(t, filepath.Join(dir, name), "new")
+	if err := os.Remove(filepath.Join(dir, name+rotationSuffix)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := credential.load(); err != nil {
+		t.Fatal(err)
+	}
+	if got := keyValues(credential); !slices.Equal(got, []string{"new"}) {
+		t.Errorf("keys are %q, want only the new key", got)
+	}
+	if changed, err := credential.load(); err != nil || changed {
+		t.Errorf("loading unchanged keys changed the credential: %t, %v", changed, err)
+	}
+}
+
+func TestWatchCredentialsReloadsSecretsDir(t *testing.T) {
+	dir := withSecretsDir(t)
+	Cfg.Secrets.ReloadIntervalSec = 1
+	name := "CREDENTIAL_WATCHED"
+	writeSecret(t, filepath.Join(dir, name), "old")
+	credential := NewCredential(name, false)
+	if _, err := credential.load(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		WatchCredentials(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	writeSecret(t, filepath.Join(dir, name), "new")
+	deadline := time.Now().Add(3 * time.Second)
+	for credential.Current().Reveal() != "new" {
+		if time.Now().After(deadline) {
+			t.Fatalf("credential was not reloaded, keys are %q", keyValues(credential))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSecretIsNotFormatted(t *testing.T) {
+	secret := Secret{value: "secret-value"}
+	marshalled, err := json.Marshal(struct{ Key Secret }{Key: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, formatted := range []string{
+		fmt.Sprint(secret),
+		fmt.Sprintf("%v %+v %#v %s", secret, secret, secret, secret),
+		string(marshalled),
+	} {
+		if strings.Contains(formatted, "secret-value") {
+			t.Errorf("secret was formatted as %s", formatted)
+		}
+	}
+}
+
+func TestSecretRedactionHook(t *testing.T) {
+	withSecretsDir(t)
+	name := "CREDENTIAL_REDACTED"
+	t.Setenv(name, "current-secret")
+	t.Setenv(name+rotationSuffix, "next-secret")
+	credential := NewCredential(name, false)
+	if _, err := credential.load(); err != nil {
+		t.Fatal(err)
+	}
+	// the credential stays registered, later tests must not see its keys redacted
+	t.Cleanup(func() {
+		credential.mu.Lock()
+		credential.keys = nil
+		credential.mu.Unlock()
+	})
+
+	var output bytes.Buffer
+	logger := log.New()
+	logger.SetOutput(&output)
+	logger.AddHook(secretRedactionHook{})
+
+	// the secrets reach the logger in the message, string fields and errors, e.g. an echoing provider or a failed URL
+	logger.WithField("body", `{"error":"invalid key next-secret"}`).
+		WithError(fmt.Errorf("GET https://provider.test/offers?apiKey=current-secret: timeout")).
+		Errorf("Provider rejected key current-secret")
+
+	logged := output.String()
+	for _, secret := range []string{"current-secret", "next-secret"} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("log output contains the secret %s: %s", secret, logged)
+		}
+	}
+	if !strings.Contains(logged, redactedSecret) || !strings.Contains(logged, "apiKey=") {
+		t.Errorf("log output lost more than the secrets: %s", logged)
+	}
+}
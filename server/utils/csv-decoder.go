@@ -0,0 +1,255 @@
+package utils
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CSVFieldError describes a single cell which could not be decoded into its field
+type CSVFieldError struct {
+	Row    int
+	Column string
+	Value  string
+	Err    error
+}
+
+func (e *CSVFieldError) Error() string {
+	return fmt.Sprintf("row %d, column %q, value %q: %v", e.Row, e.Column, e.Value, e.Err)
+}
+
+func (e *CSVFieldError) Unwrap() error {
+	return e.Err
+}
+
+// CSVRowError collects all field errors of a row, the row itself is skipped by the caller
+type CSVRowError struct {
+	Row    int
+	Fields []*CSVFieldError
+}
+
+func (e *CSVRowError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		messages = append(messages, field.Error())
+	}
+
+	return fmt.Sprintf("failed to decode CSV row %d: %s", e.Row, strings.Join(messages, "; "))
+}
+
+func (e *CSVRowError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		errs = append(errs, field)
+	}
+
+	return errs
+}
+
+var ErrCSVRequiredValue = errors.New("required value is empty")
+
+type csvField struct {
+	index    int
+	name     string
+	required bool
+}
+
+// CSVDecoder streams CSV records into structs of type T.
+// Header names are bound to struct fields with the tag `csv:"name"` or `csv:"name,required"`.
+// A field of type map[string]string tagged with `csv:",extra"` receives all columns without a field.
+// Supported field types are string, bool, int, float64 and pointers to them, pointers stay nil for empty cells
+type CSVDecoder[T any] struct {
+	reader  *csv.Reader
+	headers []string
+	fields  []*csvField // by column index, nil for unmapped columns
+	extra   int         // field index of the extra map, -1 if not present
+	// required columns which are not part of the header
+	missingRequired []string
+	record          []string
+	row             int
+}
+
+// NewCSVDecoder reads the header row and binds the columns to the fields of T
+func NewCSVDecoder[T any](r io.Reader) (*CSVDecoder[T], error) {
+	reader := csv.NewReader(r)
+	// missing cells are reported per field instead of failing the whole record
+	reader.FieldsPerRecord = -1
+
+	headers, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV headers: %w", err)
+	}
+	for i, header := range headers {
+		headers[i] = strings.TrimSpace(header)
+	}
+
+	decoder := &CSVDecoder[T]{
+		reader:  reader,
+		headers: headers,
+		fields:  make([]*csvField, len(headers)),
+		extra:   -1,
+	}
+
+	structType := reflect.TypeFor[T]()
+	if structType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("CSV decoder target must be a struct, got %s", structType)
+	}
+
+	boundFields := make(map[string]*csvField)
+	for i := 0; i < structType.NumField(); i++ {
+		tag, ok := structType.Field(i).Tag.Lookup("csv")
+		if !ok || tag == "-" {
+			continue
+		}
+
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" && options == "extra" {
+			if structType.Field(i).Type != reflect.TypeFor[map[string]string]() {
+				return nil, fmt.Errorf("CSV extra field %s must be of type map[string]string", structType.Field(i).Name)
+			}
+			decoder.extra = i
+			continue
+		}
+		if err := checkCSVFieldType(structType.Field(i).Type); err != nil {
+			return nil, fmt.Errorf("CSV field %s: %w", structType.Field(i).Name, err)
+		}
+		boundFields[name] = &csvField{index: i, name: name, required: options == "required"}
+	}
+
+	for i, header := range headers {
+		decoder.fields[i] = boundFields[header]
+		delete(boundFields, header)
+	}
+	for name, field := range boundFields {
+		if field.required {
+			decoder.missingRequired = append(decoder.missingRequired, name)
+		}
+	}
+	slices.Sort(decoder.missingRequired)
+
+	return decoder, nil
+}
+
+// Headers returns the trimmed header row
+func (d *CSVDecoder[T]) Headers() []string {
+	return d.headers
+}
+
+// Record returns the raw cells of the row decoded last
+func (d *CSVDecoder[T]) Record() []string {
+	return d.record
+}
+
+// Decode reads the next row. It returns io.EOF after the last row and a *CSVRowError if single cells
+// could not be decoded, in that case the returned value must not be used. Errors of the underlying
+// CSV reader are returned as is
+func (d *CSVDecoder[T]) Decode() (value T, err error) {
+	record, err := d.reader.Read()
+	if err != nil {
+		return value, err
+	}
+	d.record = record
+	d.row++
+
+	target := reflect.ValueOf(&value).Elem()
+	rowErr := &CSVRowError{Row: d.row}
+	for i, field := range d.fields {
+		cell := ""
+		if i < len(record) {
+			cell = strings.TrimSpace(record[i])
+		}
+
+		if field == nil {
+			if d.extra >= 0 {
+				extra := target.Field(d.extra)
+				if extra.IsNil() {
+					extra.Set(reflect.ValueOf(make(map[string]string)))
+				}
+				extra.SetMapIndex(reflect.ValueOf(d.headers[i]), reflect.ValueOf(cell))
+			}
+			continue
+		}
+
+		if cell == "" {
+			if field.required {
+				rowErr.Fields = append(rowErr.Fields, &CSVFieldError{Row: d.row, Column: field.name, Value: cell, Err: ErrCSVRequiredValue})
+			}
+			continue
+		}
+		if err := setCSVValue(target.Field(field.index), cell); err != nil {
+			rowErr.Fields = append(rowErr.Fields, &CSVFieldError{Row: d.row, Column: field.name, Value: cell, Err: err})
+		}
+	}
+
+	// required columns which are missing in the header are an error for every row
+	for _, field := range d.missingRequired {
+		rowErr.Fields = append(rowErr.Fields, &CSVFieldError{Row: d.row, Column: field, Err: ErrCSVRequiredValue})
+	}
+
+	if len(rowErr.Fields) > 0 {
+		return value, rowErr
+	}
+
+	return value, nil
+}
+
+func checkCSVFieldType(fieldType reflect.Type) error {
+	if fieldType.Kind() == reflect.Pointer {
+		fieldType = fieldType.Elem()
+	}
+
+	switch fieldType.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Float64:
+		return nil
+	default:
+		return fmt.Errorf("unsupported type %s", fieldType)
+	}
+}
+
+func setCSVValue(field reflect.Value, cell string) error {
+	if field.Kind() == reflect.Pointer {
+		field.Set(reflect.New(field.Type().Elem()))
+		field = field.Elem()
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(cell)
+	case reflect.Bool:
+		value, err := parseCSVBool(cell)
+		if err != nil {
+			return err
+		}
+		field.SetBool(value)
+	case reflect.Int:
+		value, err := strconv.Atoi(cell)
+		if err != nil {
+			return fmt.Errorf("not an integer: %w", err)
+		}
+		field.SetInt(int64(value))
+	case reflect.Float64:
+		value, err := strconv.ParseFloat(cell, 64)
+		if err != nil {
+			return fmt.Errorf("not a number: %w", err)
+		}
+		field.SetFloat(value)
+	}
+
+	return nil
+}
+
+func parseCSVBool(cell string) (bool, error) {
+	switch strings.ToLower(cell) {
+	case "true", "yes", "1":
+		return true, nil
+	case "false", "no", "0":
+		return false, nil
+	default:
+		return false, fmt.Errorf("not a boolean")
+	}
+}
@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// FuzzCSVToMap makes sure malformed CSV is reported as error instead of panicking
+func FuzzCSVToMap(f *testing.F) {
+	// the CSV payloads of the golden corpus of the provider adapters
+	seeds, err := filepath.Glob("../service/testdata/golden/*/*.csv")
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, seed := range seeds {
+		payload, err := os.ReadFile(seed)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(payload)
+	}
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		CSVToMap(payload)
+	})
+}
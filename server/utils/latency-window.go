@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// LatencyWindow keeps the latest latency samples, older samples are overwritten so quantiles follow recent behavior
+type LatencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	// position of the next sample once the window is full
+	next int
+}
+
+func NewLatencyWindow(size int) *LatencyWindow {
+	return &LatencyWindow{samples: make([]time.Duration, 0, max(size, 1))}
+}
+
+func (w *LatencyWindow) Add(latency time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.samples) < cap(w.samples) {
+		w.samples = append(w.samples, latency)
+		return
+	}
+
+	w.samples[w.next] = latency
+	w.next = (w.next + 1) % len(w.samples)
+}
+
+func (w *LatencyWindow) Count() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return len(w.samples)
+}
+
+// Quantile returns the nearest-rank quantile of the samples, e.g. 0.99 for p99, or 0 without samples
+func (w *LatencyWindow) Quantile(q float64) time.Duration {
+	w.mu.Lock()
+	sorted := slices.Clone(w.samples)
+	w.mu.Unlock()
+
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	slices.Sort(sorted)
+	rank := int(math.Ceil(min(max(q, 0), 1)*float64(len(sorted)))) - 1
+	return sorted[max(rank, 0)]
+}
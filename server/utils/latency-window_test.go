@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// milliseconds returns a sample of every duration in milliseconds from first to last
+func milliseconds(first int, last int) []time.Duration {
+	var samples []time.Duration
+	for ms := first; ms <= last; ms++ {
+		samples = append(samples, time.Duration(ms)*time.Millisecond)
+	}
+	return samples
+}
+
+func TestLatencyWindowQuantile(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		size    int
+		samples []time.Duration
+		q       float64
+		want    time.Duration
+		count   int
+	}{
+		{name: "no samples", size: 10, q: 0.99, want: 0},
+		{name: "single sample", size: 10, samples: milliseconds(7, 7), q: 0.99, want: 7 * time.Millisecond, count: 1},
+		{name: "median", size: 100, samples: milliseconds(1, 100), q: 0.5, want: 50 * time.Millisecond, count: 100},
+		{name: "p99", size: 100, samples: milliseconds(1, 100), q: 0.99, want: 99 * time.Millisecond, count: 100},
+		{name: "nearest rank rounds up", size: 10, samples: milliseconds(1, 10), q: 0.95, want: 10 * time.Millisecond, count: 10},
+		{name: "maximum", size: 100, samples: milliseconds(1, 100), q: 1, want: 100 * time.Millisecond, count: 100},
+		{name: "minimum", size: 100, samples: milliseconds(1, 100), q: 0, want: time.Millisecond, count: 100},
+		{name: "quantile below 0 is clamped", size: 100, samples: milliseconds(1, 100), q: -1, want: time.Millisecond, count: 100},
+		{name: "quantile above 1 is clamped", size: 100, samples: milliseconds(1, 100), q: 2, want: 100 * time.Millisecond, count: 100},
+		{name: "unsorted samples", size: 10, samples: []time.Duration{30, 10, 20}, q: 0.5, want: 20, count: 3},
+		// the oldest samples are overwritten, only 8 to 10 are left
+		{name: "full window", size: 3, samples: milliseconds(1, 10), q: 0, want: 8 * time.Millisecond, count: 3},
+		{name: "at least one sample", size: 0, samples: milliseconds(1, 5), q: 0.5, want: 5 * time.Millisecond, count: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := NewLatencyWindow(tc.size)
+			for _, sample := range tc.samples {
+				w.Add(sample)
+			}
+
+			if got := w.Quantile(tc.q); got != tc.want {
+				t.Errorf("quantile %g is %s, want %s", tc.q, got, tc.want)
+			}
+			if got := w.Count(); got != tc.count {
+				t.Errorf("window holds %d samples, want %d", got, tc.count)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"sync"
+)
+
+// CounterVec is a monotonic counter partitioned by a single label, e.g. the provider name
+type CounterVec struct {
+	mu     sync.Mutex
+	values map[string]int64
+}
+
+var (
+	metricsMu       sync.Mutex
+	metricsRegistry = make(map[string]*CounterVec)
+)
+
+// NewCounterVec creates a counter and registers it under the given name so it shows up in MetricsSnapshot.
+// Registering the same name twice returns the already registered counter
+func NewCounterVec(name string) *CounterVec {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	if counter, ok := metricsRegistry[name]; ok {
+		return counter
+	}
+
+	counter := &CounterVec{values: make(map[string]int64)}
+	metricsRegistry[name] = counter
+	return counter
+}
+
+func (c *CounterVec) Inc(label string) {
+	c.Add(label, 1)
+}
+
+func (c *CounterVec) Add(label string, delta int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.values[label] += delta
+}
+
+func (c *CounterVec) Get(label string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.values[label]
+}
+
+func (c *CounterVec) snapshot() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := make(map[string]int64, len(c.values))
+	for label, value := range c.values {
+		result[label] = value
+	}
+
+	return result
+}
+
+// MetricsSnapshot returns the current values of all registered counters by name and label
+func MetricsSnapshot() map[string]map[string]int64 {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	result := make(map[string]map[string]int64, len(metricsRegistry))
+	for name, counter := range metricsRegistry {
+		result[name] = counter.snapshot()
+	}
+
+	return result
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"time"
@@ -9,7 +10,46 @@ import (
 
 type RetryableFunc[T any] func() (T, error)
 
+// RetryableError is implemented by errors which know whether repeating the request can succeed,
+// all other errors are retried
+type RetryableError interface {
+	error
+	Retryable() bool
+}
+
+type nonRetryableError struct {
+	err error
+}
+
+func (e nonRetryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e nonRetryableError) Unwrap() error {
+	return e.err
+}
+
+func (e nonRetryableError) Retryable() bool {
+	return false
+}
+
+// NonRetryable marks an error so RetryWrapper returns it without retrying
+func NonRetryable(err error) error {
+	return nonRetryableError{err: err}
+}
+
+// IsRetryable reports whether RetryWrapper would retry after the error
+func IsRetryable(err error) bool {
+	var retryable RetryableError
+	if errors.As(err, &retryable) {
+		return retryable.Retryable()
+	}
+
+	return true
+}
+
 // RetryWrapper executes a retryable function with a context and retries on error.
+// Errors which are not retryable (see RetryableError) are returned immediately.
 // We could also take a request builder function as an argument and only return the response object
 func RetryWrapper[T any](ctx context.Context, fn RetryableFunc[T]) (ret T, err error) {
 
@@ -17,6 +57,9 @@ func RetryWrapper[T any](ctx context.Context, fn RetryableFunc[T]) (ret T, err e
 	if err == nil {
 		return ret, nil // Success
 	}
+	if !IsRetryable(err) {
+		return ret, err
+	}
 
 	for _, delaySeconds := range Cfg.Server.RetryFrequencyMilli {
 		// Add jitter: random value between -500 and +500 milliseconds
@@ -32,6 +75,9 @@ func RetryWrapper[T any](ctx context.Context, fn RetryableFunc[T]) (ret T, err e
 			if err == nil {
 				return ret, nil
 			}
+			if !IsRetryable(err) {
+				return ret, err
+			}
 		}
 	}
 
@@ -0,0 +1,286 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+type Priority int
+
+// priority classes of the Scheduler, a lower value is served first
+const (
+	// a user waits for the result
+	PRIORITY_INTERACTIVE Priority = iota
+	// nobody waits, e.g. cache warming
+	PRIORITY_BACKGROUND
+)
+
+var priorityNames = []string{"interactive", "background"}
+
+func (p Priority) String() string {
+	return priorityNames[p]
+}
+
+// wait times per class the stats are computed from
+const schedulerWaitWindowSize = 1000
+
+// ErrPreempted is the cause of the slot context of a background call which gave its slot to an interactive one
+var ErrPreempted = errors.New("preempted by an interactive call")
+
+// Scheduler caps the number of calls running at once. Waiting calls are served by priority, within a priority the
+// keys take turns, so a key with many calls does not hold up the others. An interactive call which finds all slots
+// taken preempts the most recently started background call
+type Scheduler struct {
+	mu       sync.Mutex
+	capacity int
+	running  int
+	classes  []*schedulerClass
+	// running background slots in the order they started
+	background []*Slot
+}
+
+type schedulerClass struct {
+	// waiting calls by key, keys take turns in the order of keys
+	queues    map[string][]*schedulerWaiter
+	keys      []string
+	queued    int
+	running   int
+	preempted int64
+	waits     *LatencyWindow
+}
+
+type schedulerWaiter struct {
+	ctx      context.Context
+	priority Priority
+	key      string
+	enqueued time.Time
+	// buffered, the slot is handed over without blocking the scheduler
+	granted chan *Slot
+}
+
+// Slot is the permission to run one call, its context ends when the caller's context ends or the slot is preempted
+type Slot struct {
+	scheduler *Scheduler
+	priority  Priority
+	ctx       context.Context
+	cancel    context.CancelCauseFunc
+	// guarded by the mutex of the scheduler
+	released bool
+}
+
+func NewScheduler(capacity int) *Scheduler {
+	classes := make([]*schedulerClass, len(priorityNames))
+	for i := range classes {
+		classes[i] = &schedulerClass{
+			queues: make(map[string][]*schedulerWaiter),
+			waits:  NewLatencyWindow(schedulerWaitWindowSize),
+		}
+	}
+
+	return &Scheduler{capacity: max(capacity, 1), classes: classes}
+}
+
+// Acquire blocks until the call may run, the slot has to be released once the call is done
+func (s *Scheduler) Acquire(ctx context.Context, priority Priority, key string) (*Slot, error) {
+	waiter := &schedulerWaiter{ctx: ctx, priority: priority, key: key, enqueued: time.Now(), granted: make(chan *Slot, 1)}
+
+	s.mu.Lock()
+	class := s.classes[priority]
+	if len(class.queues[key]) == 0 {
+		class.keys = append(class.keys, key)
+	}
+	class.queues[key] = append(class.queues[key], waiter)
+	class.queued++
+	s.dispatch()
+	s.mu.Unlock()
+
+	select {
+	case slot := <-waiter.granted:
+		return slot, nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		removed := s.remove(waiter)
+		s.mu.Unlock()
+		// the slot was granted in the meantime
+		if !removed {
+			(<-waiter.granted).Release()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// dispatch grants free slots to waiting calls and preempts background calls for waiting interactive ones.
+// s.mu must be held
+func (s *Scheduler) dispatch() {
+	for {
+		if s.running >= s.capacity {
+			if s.classes[PRIORITY_INTERACTIVE].queued == 0 || len(s.background) == 0 {
+				return
+			}
+			s.preempt()
+			continue
+		}
+
+		waiter := s.next()
+		if waiter == nil {
+			return
+		}
+		s.grant(waiter)
+	}
+}
+
+// next takes the waiting call of the highest priority whose key is next in turn
+func (s *Scheduler) next() *schedulerWaiter {
+	for _, class := range s.classes {
+		if class.queued == 0 {
+			continue
+		}
+
+		key := class.keys[0]
+		queue := class.queues[key]
+		waiter := queue[0]
+		class.queued--
+		class.keys = class.keys[1:]
+		if len(queue) > 1 {
+			class.queues[key] = queue[1:]
+			class.keys = append(class.keys, key)
+		} else {
+			delete(class.queues, key)
+		}
+
+		return waiter
+	}
+
+	return nil
+}
+
+// remove takes a waiting call out of its queue, it reports false if the call is not waiting anymore
+func (s *Scheduler) remove(waiter *schedulerWaiter) bool {
+	class := s.classes[waiter.priority]
+	queue := class.queues[waiter.key]
+	i := slices.Index(queue, waiter)
+	if i < 0 {
+		return false
+	}
+
+	class.queued--
+	if len(queue) > 1 {
+		class.queues[waiter.key] = slices.Delete(queue, i, i+1)
+	} else {
+		delete(class.queues, waiter.key)
+		class.keys = slices.DeleteFunc(class.keys, func(key string) bool { return key == waiter.key })
+	}
+	return true
+}
+
+func (s *Scheduler) grant(waiter *schedulerWaiter) {
+	ctx, cancel := context.WithCancelCause(waiter.ctx)
+	slot := &Slot{scheduler: s, priority: waiter.priority, ctx: ctx, cancel: cancel}
+	// a slot whose context ended is free again even if the caller never releases it
+	context.AfterFunc(ctx, slot.Release)
+
+	class := s.classes[waiter.priority]
+	class.running++
+	class.waits.Add(time.Since(waiter.enqueued))
+	s.running++
+	if waiter.priority == PRIORITY_BACKGROUND {
+		s.background = append(s.background, slot)
+	}
+
+	waiter.granted <- slot
+}
+
+// preempt frees the slot of the most recently started background call, it lost the least work
+func (s *Scheduler) preempt() {
+	slot := s.background[len(s.background)-1]
+	s.classes[PRIORITY_BACKGROUND].preempted++
+	slot.cancel(ErrPreempted)
+	s.free(slot)
+}
+
+// free returns the slot to the scheduler, s.mu must be held
+func (s *Scheduler) free(slot *Slot) {
+	slot.released = true
+	s.running--
+	s.classes[slot.priority].running--
+	if slot.priority == PRIORITY_BACKGROUND {
+		s.background = slices.DeleteFunc(s.background, func(running *Slot) bool { return running == slot })
+	}
+}
+
+// Context ends once the caller's context ends or the slot is preempted, context.Cause is then ErrPreempted
+func (slot *Slot) Context() context.Context {
+	return slot.ctx
+}
+
+// Detach frees the slot but keeps its context, the call goes on outside the cap and cannot be preempted anymore.
+// Release still has to be called once the call is done
+func (slot *Slot) Detach() {
+	s := slot.scheduler
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if slot.released {
+		return
+	}
+	s.free(slot)
+	s.dispatch()
+}
+
+// Release frees the slot, it may be called more than once
+func (slot *Slot) Release() {
+	slot.cancel(nil)
+
+	s := slot.scheduler
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if slot.released {
+		return
+	}
+	s.free(slot)
+	s.dispatch()
+}
+
+// SchedulerClassStats describe the calls of one priority class
+type SchedulerClassStats struct {
+	Queued    int   `json:"queued"`
+	Running   int   `json:"running"`
+	Preempted int64 `json:"preempted"`
+	// time calls waited for their slot, over the recent calls
+	WaitP50Ms int64 `json:"waitP50Ms"`
+	WaitP99Ms int64 `json:"waitP99Ms"`
+	WaitMaxMs int64 `json:"waitMaxMs"`
+}
+
+type SchedulerStats struct {
+	Capacity int                            `json:"capacity"`
+	Running  int                            `json:"running"`
+	Classes  map[string]SchedulerClassStats `json:"classes"`
+}
+
+func (s *Scheduler) Stats() SchedulerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := SchedulerStats{
+		Capacity: s.capacity,
+		Running:  s.running,
+		Classes:  make(map[string]SchedulerClassStats, len(s.classes)),
+	}
+	for priority, class := range s.classes {
+		stats.Classes[Priority(priority).String()] = SchedulerClassStats{
+			Queued:    class.queued,
+			Running:   class.running,
+			Preempted: class.preempted,
+			WaitP50Ms: class.waits.Quantile(0.5).Milliseconds(),
+			WaitP99Ms: class.waits.Quantile(0.99).Milliseconds(),
+			WaitMaxMs: class.waits.Quantile(1).Milliseconds(),
+		}
+	}
+
+	return stats
+}
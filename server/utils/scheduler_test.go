@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+)
+
+// time a call gets for its slot before the test gives up
+const schedulerTimeout = time.Second
+
+type grantedSlot struct {
+	name string
+	slot *Slot
+}
+
+func TestSchedulerServesInteractiveFirst(t *testing.T) {
+	s := NewScheduler(1)
+	running := acquire(t, s, PRIORITY_INTERACTIVE, "")
+
+	granted := make(chan grantedSlot, 2)
+	acquireAsync(t, s, PRIORITY_BACKGROUND, "", "background", granted)
+	waitQueued(t, s, 1)
+	acquireAsync(t, s, PRIORITY_INTERACTIVE, "", "interactive", granted)
+	waitQueued(t, s, 2)
+
+	running.Release()
+	if got := grantOrder(t, granted, 2); !slices.Equal(got, []string{"interactive", "background"}) {
+		t.Errorf("slots were granted to %v, want the interactive call first", got)
+	}
+}
+
+func TestSchedulerKeysTakeTurns(t *testing.T) {
+	s := NewScheduler(1)
+	running := acquire(t, s, PRIORITY_INTERACTIVE, "")
+
+	granted := make(chan grantedSlot, 4)
+	for i, key := range []string{"a", "a", "a", "b"} {
+		acquireAsync(t, s, PRIORITY_INTERACTIVE, key, key, granted)
+		waitQueued(t, s, i+1)
+	}
+
+	running.Release()
+	if got := grantOrder(t, granted, 4); !slices.Equal(got, []string{"a", "b", "a", "a"}) {
+		t.Errorf("slots were granted to %v, want the keys to take turns", got)
+	}
+}
+
+func TestSchedulerPreemptsBackground(t *testing.T) {
+	s := NewScheduler(1)
+	background := acquire(t, s, PRIORITY_BACKGROUND, "")
+
+	interactive := acquire(t, s, PRIORITY_INTERACTIVE, "")
+	select {
+	case <-background.Context().Done():
+	case <-time.After(schedulerTimeout):
+		t.Fatal("the context of the preempted call did not end")
+	}
+	if cause := context.Cause(background.Context()); !errors.Is(cause, ErrPreempted) {
+		t.Errorf("preempted call ended with %v, want %v", cause, ErrPreempted)
+	}
+	// releasing the preempted slot must not free the slot of the interactive call
+	background.Release()
+
+	stats := s.Stats()
+	if stats.Running != 1 || stats.Classes["background"].Preempted != 1 {
+		t.Errorf("stats are %+v, want 1 running and 1 preempted", stats)
+	}
+
+	// interactive calls are not preempted
+	granted := make(chan grantedSlot, 1)
+	acquireAsync(t, s, PRIORITY_INTERACTIVE, "", "second", granted)
+	waitQueued(t, s, 1)
+	if interactive.Context().Err() != nil {
+		t.Errorf("the running interactive call was preempted")
+	}
+	interactive.Release()
+	grantOrder(t, granted, 1)
+}
+
+func TestSchedulerDetach(t *testing.T) {
+	s := NewScheduler(1)
+	detached := acquire(t, s, PRIORITY_BACKGROUND, "")
+	detached.Detach()
+
+	next := acquire(t, s, PRIORITY_BACKGROUND, "")
+	if detached.Context().Err() != nil {
+		t.Errorf("the context of the detached call ended")
+	}
+
+	// a detached call is outside the cap, it is not preempted and its release does not free a slot
+	acquire(t, s, PRIORITY_INTERACTIVE, "")
+	if detached.Context().Err() != nil {
+		t.Errorf("the detached call was preempted")
+	}
+	if !errors.Is(context.Cause(next.Context()), ErrPreempted) {
+		t.Errorf("the running background call was not preempted")
+	}
+	detached.Release()
+	if running := s.Stats().Running; running != 1 {
+		t.Errorf("%d calls are running, want 1", running)
+	}
+}
+
+func acquire(t *testing.T, s *Scheduler, priority Priority, key string) *Slot {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), schedulerTimeout)
+	t.Cleanup(cancel)
+	slot, err := s.Acquire(ctx, priority, key)
+	if err != nil {
+		t.Fatalf("Acquire returned %v", err)
+	}
+	return slot
+}
+
+// acquireAsync waits for a slot in the background, the granted slot is sent to granted with the name
+func acquireAsync(t *testing.T, s *Scheduler, priority Priority, key string, name string, granted chan<- grantedSlot) {
+	ctx, cancel := context.WithTimeout(context.Background(), schedulerTimeout)
+	t.Cleanup(cancel)
+	go func() {
+		slot, err := s.Acquire(ctx, priority, key)
+		if err == nil {
+			granted <- grantedSlot{name: name, slot: slot}
+		}
+	}()
+}
+
+// grantOrder receives n granted slots and releases each before the next is granted
+func grantOrder(t *testing.T, granted <-chan grantedSlot, n int) []string {
+	t.Helper()
+
+	var names []string
+	for len(names) < n {
+		select {
+		case g := <-granted:
+			names = append(names, g.name)
+			g.slot.Release()
+		case <-time.After(schedulerTimeout):
+			t.Fatalf("slots were granted to %v, waited for %d", names, n)
+		}
+	}
+	return names
+}
+
+// waitQueued waits until n calls are waiting for a slot
+func waitQueued(t *testing.T, s *Scheduler, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(schedulerTimeout)
+	for {
+		queued := 0
+		for _, class := range s.Stats().Classes {
+			queued += class.Queued
+		}
+		if queued == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d calls are queued, want %d", queued, n)
+		}
+		runtime.Gosched()
+	}
+}
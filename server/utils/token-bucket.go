@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TokenBucket limits the rate of requests. The bucket fills with rate tokens per second up to burst, every request
+// takes a token and waits until one is available
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(max(burst, 1)),
+		tokens: float64(max(burst, 1)),
+		last:   time.Now(),
+	}
+}
+
+// Wait takes a token, it blocks until the token is available or the context is done. It reports whether it had to wait
+func (b *TokenBucket) Wait(ctx context.Context) (bool, error) {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	// the token is reserved right away, so waiting requests are served in order
+	b.tokens--
+	if b.tokens >= 0 {
+		b.mu.Unlock()
+		return false, nil
+	}
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		// return the reservation, requests behind it may go earlier
+		b.mu.Lock()
+		b.tokens = min(b.burst, b.tokens+1)
+		b.mu.Unlock()
+		return true, ctx.Err()
+	case <-timer.C:
+		return true, nil
+	}
+}
+
+// Rate returns the tokens added per second
+func (b *TokenBucket) Rate() float64 {
+	return b.rate
+}
+
+// Burst returns the number of tokens the bucket holds at most
+func (b *TokenBucket) Burst() int {
+	return int(b.burst)
+}
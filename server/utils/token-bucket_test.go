@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketWait(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		rate  float64
+		burst int
+		calls int
+		// calls which have to wait for a token
+		waited int
+		// least time all calls take together
+		minElapsed time.Duration
+	}{
+		{name: "within the burst", rate: 10, burst: 3, calls: 3, waited: 0},
+		{name: "beyond the burst", rate: 100, burst: 2, calls: 4, waited: 2, minElapsed: 15 * time.Millisecond},
+		{name: "burst of at least one", rate: 100, burst: 0, calls: 2, waited: 1, minElapsed: 5 * time.Millisecond},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := NewTokenBucket(tc.rate, tc.burst)
+
+			start := time.Now()
+			waited := 0
+			for range tc.calls {
+				w, err := b.Wait(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if w {
+					waited++
+				}
+			}
+			if waited != tc.waited {
+				t.Errorf("%d calls waited, want %d", waited, tc.waited)
+			}
+			if elapsed := time.Since(start); elapsed < tc.minElapsed {
+				t.Errorf("calls took %s, want at least %s", elapsed, tc.minElapsed)
+			}
+		})
+	}
+}
+
+func TestTokenBucketReturnsReservation(t *testing.T) {
+	b := NewTokenBucket(10, 1)
+	if _, err := b.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the call gives up long before its token is available
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if waited, err := b.Wait(ctx); !waited || err != context.DeadlineExceeded {
+		t.Fatalf("cancelled call returned %t, %v", waited, err)
+	}
+
+	// the next call only waits for its own token, not for the one of the cancelled call
+	start := time.Now()
+	if _, err := b.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("next call waited %s, want at most one token", elapsed)
+	}
+}
+
+func TestTokenBucketLimits(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		rate      float64
+		burst     int
+		wantBurst int
+	}{
+		{name: "configured burst", rate: 2.5, burst: 5, wantBurst: 5},
+		{name: "no burst", rate: 0.5, burst: 0, wantBurst: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := NewTokenBucket(tc.rate, tc.burst)
+			if b.Rate() != tc.rate || b.Burst() != tc.wantBurst {
+				t.Errorf("bucket has rate %g and burst %d, want %g and %d", b.Rate(), b.Burst(), tc.rate, tc.wantBurst)
+			}
+		})
+	}
+}
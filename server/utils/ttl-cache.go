@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// purgeInterval is the minimum time between two sweeps over all entries for expired ones
+const purgeInterval = time.Minute
+
+type ttlEntry[V any] struct {
+	value     V
+	expiresAt time.Time
+}
+
+// TTLCache is an in-memory cache whose entries expire individually
+type TTLCache[K comparable, V any] struct {
+	mu        sync.Mutex
+	entries   map[K]ttlEntry[V]
+	lastPurge time.Time
+}
+
+func NewTTLCache[K comparable, V any]() *TTLCache[K, V] {
+	return &TTLCache[K, V]{entries: make(map[K]ttlEntry[V])}
+}
+
+// Get returns the value of the key if it did not expire yet
+func (c *TTLCache[K, V]) Get(key K) (value V, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return value, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(c.entries, key)
+		return value, false
+	}
+
+	return entry.value, true
+}
+
+// Set stores the value for ttl, a ttl of zero or less does not store anything
+func (c *TTLCache[K, V]) Set(key K, value V, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	// expired entries which are never read again are dropped here, so the cache does not grow forever
+	if now.Sub(c.lastPurge) > purgeInterval {
+		for k, entry := range c.entries {
+			if now.After(entry.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		c.lastPurge = now
+	}
+
+	c.entries[key] = ttlEntry[V]{value: value, expiresAt: now.Add(ttl)}
+}
+
+func (c *TTLCache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+}
+
+func (c *TTLCache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries)
+}